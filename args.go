@@ -6,15 +6,19 @@ import (
 	"os"
 )
 
-var flagNoNxtArgVal = []string{"p", "pretty", "l", "left-operand-is-field-reference", "r", "right-operand-is-field-reference"}
-
 var arg0 = os.Args[0]
 var aArgs = os.Args[1:] // All arguments
 var remArgs []string    // Remaining arguments
 var flags []*sflag.Flag
 
 func parseArgs() error {
-	fs, pa, err := sflag.ParseNoNxtArgVal(aArgs, flagNoNxtArgVal)
+	fs, pa, err := sflag.ParseNoNxtArgVal(aArgs, noNxtArgVal(nil))
+	if err == nil && len(pa) > 0 {
+		// The optional-valued flags differ per command; parse again, knowing the command.
+		if c := lookupCmd(pa[0]); c != nil {
+			fs, pa, err = sflag.ParseNoNxtArgVal(aArgs, noNxtArgVal(c))
+		}
+	}
 	flags = fs
 	remArgs = pa
 
@@ -24,3 +28,16 @@ func parseArgs() error {
 
 	return nil
 }
+
+// hasFlag reports whether a flag of any of the given names is given.
+func hasFlag(names ...string) bool {
+	for _, f := range flags {
+		for _, n := range names {
+			if f.Name == n {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"github.com/agcom/dirb/bin"
+	"testing"
+	"time"
+)
+
+// parseTestArgs parses args as the command line arguments.
+func parseTestArgs(t *testing.T, args ...string) {
+	t.Helper()
+	aArgs = args
+	err := parseArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func flagArg(n string) string {
+	if len(n) == 1 {
+		return "-" + n
+	}
+
+	return "--" + n
+}
+
+// A flag's value is the next argument, unless it's optional in the command; regardless of the other commands.
+func TestParseArgsNxtArgVal(t *testing.T) {
+	for _, c := range cmdSpecs {
+		for _, f := range c.allFlags() {
+			for _, n := range f.names {
+				parseTestArgs(t, c.name, flagArg(n), "v")
+
+				if len(flags) != 1 || flags[0].Name != n {
+					t.Fatalf("%s %s v: got %d flags; want the %q flag only", c.name, flagArg(n), len(flags), n)
+				}
+				if f.optVal() {
+					if flags[0].HasVal || len(remArgs) != 2 || remArgs[1] != "v" {
+						t.Errorf("%s %s v: took the next argument as the value of an optional-valued flag", c.name, flagArg(n))
+					}
+				} else if !flags[0].HasVal || flags[0].Val != "v" || len(remArgs) != 1 {
+					t.Errorf("%s %s v: didn't take the next argument as the value of the flag", c.name, flagArg(n))
+				}
+			}
+		}
+	}
+}
+
+func TestParseArgsKeep(t *testing.T) {
+	for _, args := range [][]string{
+		{"update", "x", "{}", "-k", "10"},
+		{"overwrite", "x", "{}", "-k", "10"},
+		{"remove", "x", "-k", "10"},
+		{"transaction", "-k", "10"},
+		{"history", "restore", "x", "1", "-k", "10"},
+		// The command is not known yet
+		{"-k", "10", "update", "x", "{}"},
+	} {
+		parseTestArgs(t, args...)
+
+		if !hasFlag("k") || flags[0].Val != "10" {
+			t.Errorf("%q: got the arguments %q; want -k=10", args, remArgs)
+		}
+	}
+
+	parseTestArgs(t, "grep", "re", "-k", "x")
+	if len(flags) != 1 || flags[0].HasVal || len(remArgs) != 3 {
+		t.Errorf("grep re -k x: got the arguments %q; want a -k without a value", remArgs)
+	}
+}
+
+func TestLookupCmd(t *testing.T) {
+	seen := make(map[string]string)
+	for _, c := range cmdSpecs {
+		for _, n := range append([]string{c.name}, c.aliases...) {
+			if o, ok := seen[n]; ok {
+				t.Errorf("%q is of both %q and %q", n, o, c.name)
+			}
+			seen[n] = c.name
+		}
+	}
+
+	// Search was an alias of grep, before the text search took it.
+	for n, want := range map[string]string{"grep": "grep", "search": "search"} {
+		if c := lookupCmd(n); c == nil || c.name != want {
+			t.Errorf("%q: not a name of the %q command", n, want)
+		}
+	}
+}
+
+// parseTestCmd parses args as the command line arguments, and then the flags by the command (or the sub-command) they name.
+func parseTestCmd(t *testing.T, args ...string) (*cmdSpec, flagVals, bool) {
+	t.Helper()
+	parseTestArgs(t, args...)
+	c := lookupCmd(remArgs[0])
+	if c == nil {
+		t.Fatalf("%q: unknown command", args)
+	}
+	remArgs = remArgs[1:]
+	if len(c.subs) > 0 {
+		c = c.popSub()
+	}
+
+	fv, ok := c.parseFlags()
+	return c, fv, ok
+}
+
+func TestParseFlags(t *testing.T) {
+	_, fv, ok := parseTestCmd(t, "find", "a == 1", "--limit", "3", "--directory", "x", "--docs", "--sort", "a:desc", "-w=1s")
+	if !ok {
+		t.Fatal("failed to parse valid flags")
+	}
+	if fv.integer("limit", -1) != 3 || fv.integer("offset", 0) != 0 {
+		t.Errorf("got the limit %d, and the offset %d; want 3, and 0", fv.integer("limit", -1), fv.integer("offset", 0))
+	}
+	if fv.dir() != "x" || !fv.boolean("docs") || fv.boolean("p") {
+		t.Errorf("got the directory %q, docs %v, and pretty %v; want x, true, and false", fv.dir(), fv.boolean("docs"), fv.boolean("p"))
+	}
+	if sks, _ := fv["sort"].([]*sortKey); len(sks) != 1 || !sks[0].desc {
+		t.Errorf("got the sort keys %v; want a:desc", sks)
+	}
+	if w := fv.wait(); w == nil || w.Timeout != time.Second {
+		t.Errorf("got the wait %v; want 1s", w)
+	}
+	if fv.durability() != bin.DurabilityFileDir {
+		t.Errorf("got the durability %v; want the default", fv.durability())
+	}
+
+	c, fv, ok := parseTestCmd(t, "hist", "rollback", "x", "1", "-k", "3", "-p=0")
+	if ok || c.fullName() != "history restore" {
+		t.Errorf("got %q (%v); want history restore, without -p", c.fullName(), ok)
+	}
+	if fv.integer("k", 0) != 3 {
+		t.Errorf("got -k %d; want 3", fv.integer("k", 0))
+	}
+
+	for _, args := range [][]string{
+		{"ls", "--limt", "3"},
+		{"ls", "-d", "a", "-d", "b"},
+		{"ls", "-d", "a", "--directory", "b"},
+		{"ls", "--limit", "-1"},
+		{"ls", "--limit"},
+		{"read", "x", "-e=maybe"},
+		{"read", "x", "-w=forever"},
+		{"update", "x", "{}", "-k", "all"},
+		{"update", "x", "{}", "--merge", "shallow"},
+		{"create", "{}", "-s", "disk"},
+		{"init", "--schema="},
+		{"init", "--ext", "json"},
+		{"history", "ls", "x", "-k", "3"},
+		{"index", "rm", "i", "--text"},
+		{"trash", "ls", "-a", "1h"},
+	} {
+		_, _, ok := parseTestCmd(t, args...)
+		if ok {
+			t.Errorf("%q: parsed invalid flags", args)
+		}
+	}
+}
@@ -4,6 +4,7 @@
 package bin
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"go.uber.org/multierr"
@@ -13,15 +14,27 @@ import (
 )
 
 func New(path string, b io.Reader) error {
-	return NewLckPath(path, b, DefLckPath(path))
+	return NewOpts(path, b, nil)
+}
+
+func NewOpts(path string, b io.Reader, o *Opts) error {
+	return NewLckPathOpts(path, b, DefLckPath(path), o)
 }
 
 func NewLckPath(path string, b io.Reader, lckPath string) error {
-	return newOrOverLckPath(true, path, b, lckPath)
+	return NewLckPathOpts(path, b, lckPath, nil)
+}
+
+func NewLckPathOpts(path string, b io.Reader, lckPath string, o *Opts) error {
+	return newOrOverLckPath(true, path, b, lckPath, "", o)
 }
 
 func NewBare(path string, b io.Reader) error {
-	return newOrOverBare(true, path, b)
+	return NewBareOpts(path, b, nil)
+}
+
+func NewBareOpts(path string, b io.Reader, o *Opts) error {
+	return newOrOverBare(true, path, b, o)
 }
 
 func Open(path string) (*os.File, error) {
@@ -38,30 +51,64 @@ func Open(path string) (*os.File, error) {
 }
 
 func Over(path string, b io.Reader) error {
-	return OverLckPath(path, b, DefLckPath(path))
+	return OverOpts(path, b, nil)
+}
+
+func OverOpts(path string, b io.Reader, o *Opts) error {
+	return OverLckPathOpts(path, b, DefLckPath(path), o)
 }
 
 func OverLckPath(path string, b io.Reader, lckPath string) error {
-	return newOrOverLckPath(false, path, b, lckPath)
+	return OverLckPathOpts(path, b, lckPath, nil)
+}
+
+func OverLckPathOpts(path string, b io.Reader, lckPath string, o *Opts) error {
+	return newOrOverLckPath(false, path, b, lckPath, "", o)
+}
+
+// OverIfMatch is like OverOpts, but fails with an ErrMismatch if the version token of the binary is not etag (unless it's empty); see ETagOf.
+func OverIfMatch(path string, b io.Reader, etag string, o *Opts) error {
+	return newOrOverLckPath(false, path, b, DefLckPath(path), etag, o)
 }
 
 func OverBare(path string, b io.Reader) error {
-	return newOrOverBare(false, path, b)
+	return OverBareOpts(path, b, nil)
 }
 
-func Rm(path string) (rErr error) {
-	return RmLckPath(path, DefLckPath(path))
+func OverBareOpts(path string, b io.Reader, o *Opts) error {
+	return newOrOverBare(false, path, b, o)
 }
 
-func RmLckPath(path string, lckPath string) (rErr error) {
+func Rm(path string) error {
+	return RmOpts(path, nil)
+}
+
+func RmOpts(path string, o *Opts) error {
+	return RmLckPathOpts(path, DefLckPath(path), o)
+}
+
+func RmLckPath(path string, lckPath string) error {
+	return RmLckPathOpts(path, lckPath, nil)
+}
+
+func RmLckPathOpts(path string, lckPath string, o *Opts) error {
+	return rmLckPath(path, lckPath, "", o)
+}
+
+// RmIfMatch is like RmOpts, but fails with an ErrMismatch if the version token of the binary is not etag (unless it's empty); see ETagOf.
+func RmIfMatch(path string, etag string, o *Opts) error {
+	return rmLckPath(path, DefLckPath(path), etag, o)
+}
+
+func rmLckPath(path string, lckPath string, etag string, o *Opts) (rErr error) {
 	// Early existence check (not vital)
 	err := ErrIfNotExist(path)
 	if err != nil {
 		return err
 	}
 
-	// Rm also need to acquire lock file, to avoid clashing with an ongoing overwrite (Over function).
-	lckFile, err := Lck(lckPath)
+	// Rm also need to acquire lock file, to avoid clashing with an ongoing overwrite (Over function) or read.
+	lckFile, err := WLck(lckPath, o)
 	if err != nil {
 		return err
 	}
@@ -72,23 +119,117 @@ func RmLckPath(path string, lckPath string) (rErr error) {
 		}
 	}()
 
-	return RmBare(path)
+	err = ErrIfNotMatch(path, etag)
+	if err != nil {
+		return err
+	}
+
+	return RmBareOpts(path, o)
 }
 
 func RmBare(path string) error {
-	err := os.Remove(path)
+	return RmBareOpts(path, nil)
+}
+
+// RmBareOpts removes the binary at path; into the trash, if o.Trash is set (see TrashEntry).
+func RmBareOpts(path string, o *Opts) error {
+	err := rmBin(path, o)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return NewErrNotExist(path)
-		} else {
-			return fmt.Errorf("failed to remove %q; %w", path, err)
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	err = o.syncDir(dir)
+	if err != nil {
+		return err
+	}
+
+	return autoPurgeTrash(dir, o)
+}
+
+// AppendLinesBareOpts appends b, a line or more (without the last newline), to the binary at path, creating it if it doesn't exist; the caller should hold its lock.
+// Not atomic; a crash may leave a torn line behind, which is ended before appending the next ones, so the readers can skip it (see ReadLines).
+func AppendLinesBareOpts(path string, b []byte, o *Opts) (rErr error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0664)
+	if err != nil {
+		return fmt.Errorf("failed to open binary %q; %w", path, err)
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close binary %q; %w", path, err))
+		}
+	}()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek to the end of binary %q; %w", path, err)
+	}
+
+	line := make([]byte, 0, len(b)+2)
+	if size > 0 {
+		last := make([]byte, 1)
+		_, err := f.ReadAt(last, size-1)
+		if err != nil {
+			return fmt.Errorf("failed to read binary %q; %w", path, err)
+		}
+		if last[0] != '\n' {
+			// Torn
+			line = append(line, '\n')
 		}
 	}
+	line = append(append(line, b...), '\n')
+
+	_, err = f.Write(line)
+	if err != nil {
+		return fmt.Errorf("failed to write to binary %q; %w", path, err)
+	}
+
+	err = o.syncFile(f)
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		// Maybe created
+		return o.syncDir(filepath.Dir(path))
+	}
 
 	return nil
 }
 
-func newOrOverLckPath(new bool, path string, b io.Reader, lckPath string) (rErr error) {
+// ReadLines calls f on each (complete) line of the binary at path, without its newline; the last line is skipped if it's not ended (e.g. torn; see AppendLinesBareOpts).
+// Stops on the first error of f.
+func ReadLines(path string, f func(b []byte) error) (rErr error) {
+	r, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := r.Close()
+		if err != nil {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close binary %q; %w", path, err))
+		}
+	}()
+
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read binary %q; %w", path, err)
+		}
+
+		err = f(b[:len(b)-1])
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func newOrOverLckPath(new bool, path string, b io.Reader, lckPath string, etag string, o *Opts) (rErr error) {
 	// Early existence check (not vital)
 	var err error
 	if new {
@@ -100,7 +241,7 @@ func newOrOverLckPath(new bool, path string, b io.Reader, lckPath string) (rErr
 		return err
 	}
 
-	lckFile, err := Lck(lckPath)
+	lckFile, err := WLck(lckPath, o)
 	if err != nil {
 		return err
 	}
@@ -111,10 +252,17 @@ func newOrOverLckPath(new bool, path string, b io.Reader, lckPath string) (rErr
 		}
 	}()
 
-	return newOrOverBare(new, path, b)
+	if !new {
+		err = ErrIfNotMatch(path, etag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return newOrOverBare(new, path, b, o)
 }
 
-func newOrOverBare(new bool, path string, b io.Reader) (rErr error) {
+func newOrOverBare(new bool, path string, b io.Reader, o *Opts) (rErr error) {
 	var err error
 	// Mandatory existence check (if a lock file is involved)
 	if new {
@@ -130,6 +278,9 @@ func newOrOverBare(new bool, path string, b io.Reader) (rErr error) {
 	// Should reside in the same directory as path to guarantee atomic rename.
 	dir, name := filepath.Split(path)
 	tmpFile, err := openTmp(dir, "."+name+"-*.tmp")
+	if tmpFile == nil {
+		return err
+	}
 	tmpPath := tmpFile.Name()
 	defer func() {
 		tmpFileCloseErr := tmpFile.Close()
@@ -152,24 +303,74 @@ func newOrOverBare(new bool, path string, b io.Reader) (rErr error) {
 
 		rErr = multierr.Combine(rErr, tmpFileRmErr, tmpFileCloseErr)
 	}()
+	if err != nil {
+		return err
+	}
 
 	_, err = io.Copy(tmpFile, b)
 	if err != nil {
 		return fmt.Errorf("failed to read from the given source, or write to temporary file %q; %w", tmpPath, err)
 	}
 
+	err = o.syncFile(tmpFile)
+	if err != nil {
+		return err
+	}
+
 	err = tmpFile.Close()
-	var errTmpFileClose error
 	if err != nil {
-		errTmpFileClose = fmt.Errorf("failed to close temporary file %q; %w", tmpPath, err)
+		return fmt.Errorf("failed to close temporary file %q; %w", tmpPath, err)
+	}
+
+	if dir == "" {
+		dir = "."
+	}
+
+	if !new {
+		err = saveRev(path, o)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.durability() == DurabilityNone || o.noJournal() {
+		// No write ahead; a crash just leaves an orphan temporary file behind.
+		err = os.Rename(tmpPath, path)
+		if err != nil {
+			return fmt.Errorf("failed to rename (move) temporary file %q to %q; %w", tmpPath, path, err)
+		}
+
+		return o.syncDir(dir)
+	}
+
+	// Write ahead; see journal.
+	j := &journal{Owner: newLckInfo(), Ops: []journalOp{{Name: name, Tmp: filepath.Base(tmpPath)}}}
+	jPath, err := writeJournal(dir, genId(), j, o)
+	if err != nil {
+		return err
 	}
 
 	err = os.Rename(tmpPath, path)
 	if err != nil {
-		return multierr.Append(fmt.Errorf("failed to rename (move) temporary file %q to %q; %w", tmpPath, path, err), errTmpFileClose)
+		err = fmt.Errorf("failed to rename (move) temporary file %q to %q; %w", tmpPath, path, err)
+		jRmErr := os.Remove(jPath)
+		if jRmErr != nil {
+			jRmErr = fmt.Errorf("failed to remove journal %q; %w", jPath, jRmErr)
+		}
+		return multierr.Append(err, jRmErr)
+	}
+
+	err = o.syncDir(dir)
+	if err != nil {
+		return err
 	}
 
-	return errTmpFileClose
+	err = os.Remove(jPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove journal %q; %w", jPath, err)
+	}
+
+	return nil
 }
 
 func openTmp(dir string, pattern string) (*os.File, error) {
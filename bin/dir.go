@@ -6,20 +6,32 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// MetaDirName is the name of the (hidden) directory, inside a Dir, that holds its metadata (e.g. transaction journals).
+const MetaDirName = ".dirb"
+
 // Dir is just a fancy wrapper around global bin functions; a binary repository that saves all bins in a specified directory.
 // Note that any name argument should be a valid file name (e.g. no filepath.Separator within); otherwise, strange things will happen.
-type Dir string
+// Names starting with a '.' are reserved for the bookkeeping files (e.g. locks and temporary files).
+type Dir struct {
+	dir  string
+	opts *Opts
+}
 
 func NewDir(d string) *Dir {
-	dir := Dir(d)
-	return &dir
+	return NewDirOpts(d, nil)
+}
+
+// NewDirOpts is like NewDir, but all the operations on the returned Dir use the given options.
+func NewDirOpts(d string, o *Opts) *Dir {
+	return &Dir{d, o}
 }
 
 func (d *Dir) New(name string, b io.Reader) error {
 	path := d.Path(name)
-	return New(path, b)
+	return NewOpts(path, b, d.opts)
 }
 
 func (d *Dir) Open(name string) (*os.File, error) {
@@ -27,14 +39,35 @@ func (d *Dir) Open(name string) (*os.File, error) {
 	return Open(path)
 }
 
+// RLck acquires a shared lock on the named binary; see RLckTkn.
+func (d *Dir) RLck(name string) (*RLckTkn, error) {
+	path := d.Path(name)
+	return RLckOpts(path, d.opts)
+}
+
 func (d *Dir) Over(name string, b io.Reader) error {
 	path := d.Path(name)
-	return Over(path, b)
+	return OverOpts(path, b, d.opts)
+}
+
+func (d *Dir) Rm(name string) error {
+	path := d.Path(name)
+	return RmOpts(path, d.opts)
 }
 
-func (d *Dir) Rm(name string) (rErr error) {
+func (d *Dir) OverIfMatch(name string, b io.Reader, etag string) error {
 	path := d.Path(name)
-	return Rm(path)
+	return OverIfMatch(path, b, etag, d.opts)
+}
+
+func (d *Dir) RmIfMatch(name string, etag string) error {
+	path := d.Path(name)
+	return RmIfMatch(path, etag, d.opts)
+}
+
+func (d *Dir) ReadAllETag(name string) ([]byte, string, error) {
+	path := d.Path(name)
+	return ReadAllETag(path, d.opts)
 }
 
 func (d *Dir) All() (rNs []string, rErr error) {
@@ -59,10 +92,13 @@ func (d *Dir) All() (rNs []string, rErr error) {
 	rNs = make([]string, len(es))[:0]
 	for _, e := range es {
 		n := e.Name()
-		if e.Type().IsRegular() {
+		if strings.HasPrefix(n, ".") {
+			// Bookkeeping
+			continue
+		} else if e.Type().IsRegular() {
 			rNs = append(rNs, n)
 		} else {
-			rErr = multierr.Append(rErr, fmt.Errorf("irregular file %q in the binarys' data directory %q", e.Name(), dPath))
+			rErr = multierr.Append(rErr, NewErrIrregular(d.Path(n)))
 		}
 	}
 
@@ -70,9 +106,18 @@ func (d *Dir) All() (rNs []string, rErr error) {
 }
 
 func (d *Dir) Dir() string {
-	return string(*d)
+	return d.dir
+}
+
+func (d *Dir) Opts() *Opts {
+	return d.opts
 }
 
 func (d *Dir) Path(name string) string {
 	return filepath.Join(d.Dir(), name)
 }
+
+// MetaPath returns the path of the given element of the metadata directory; see MetaDirName.
+func (d *Dir) MetaPath(elem ...string) string {
+	return filepath.Join(append([]string{d.Dir(), MetaDirName}, elem...)...)
+}
@@ -28,6 +28,18 @@ func NewErrNotExist(path string) *ErrNotExist {
 	return &err
 }
 
+// ErrIrregular is an irregular file (e.g. a directory) where a binary is expected.
+type ErrIrregular string
+
+func (e *ErrIrregular) Error() string {
+	return fmt.Sprintf("irregular file %q in the binarys' data directory", string(*e))
+}
+
+func NewErrIrregular(path string) *ErrIrregular {
+	err := ErrIrregular(path)
+	return &err
+}
+
 func ErrIfExists(path string) error {
 	ex, err := exists(path)
 	if err != nil {
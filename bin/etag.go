@@ -0,0 +1,103 @@
+package bin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/multierr"
+	"io"
+	"os"
+)
+
+// ETagOf returns the version token of content b; the (truncated) sha256 hash of it.
+func ETagOf(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:16])
+}
+
+// ReadETag returns the version token of the binary at path; see ETagOf.
+func ReadETag(path string) (rETag string, rErr error) {
+	f, err := Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close %q; %w", path, err))
+		}
+	}()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %q; %w", path, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// ErrMismatch is a failed precondition of a write; the binary has changed since its version token was taken.
+type ErrMismatch struct {
+	Path string
+	Want string
+	Got  string
+}
+
+func (e *ErrMismatch) Error() string {
+	return fmt.Sprintf("%q has changed; its version is %q, not %q", e.Path, e.Got, e.Want)
+}
+
+func NewErrMismatch(path, want, got string) *ErrMismatch {
+	return &ErrMismatch{path, want, got}
+}
+
+// ErrIfNotMatch returns an ErrMismatch if the version token of the binary at path is not etag; does nothing if etag is empty.
+// Should be called while holding the lock of the binary.
+func ErrIfNotMatch(path string, etag string) error {
+	if etag == "" {
+		return nil
+	}
+
+	got, err := ReadETag(path)
+	if err != nil {
+		return err
+	}
+
+	if got != etag {
+		return NewErrMismatch(path, etag, got)
+	}
+
+	return nil
+}
+
+// readAllETag reads the whole binary at path, along with its version token.
+func readAllETag(path string) ([]byte, string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", NewErrNotExist(path)
+		} else {
+			return nil, "", fmt.Errorf("failed to read %q; %w", path, err)
+		}
+	}
+
+	return b, ETagOf(b), nil
+}
+
+// ReadAllETag reads the whole binary at path, along with its version token, under a shared lock; see RLckTkn.
+func ReadAllETag(path string, o *Opts) (rB []byte, rETag string, rErr error) {
+	tkn, err := RLckOpts(path, o)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		err := tkn.Unlck()
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	return readAllETag(path)
+}
@@ -0,0 +1,89 @@
+package bin
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestETag(t *testing.T) {
+	if ETagOf([]byte("a")) != ETagOf([]byte("a")) {
+		t.Errorf("got different version tokens of the same content")
+	}
+	if ETagOf([]byte("a")) == ETagOf([]byte("b")) || ETagOf(nil) == ETagOf([]byte("a")) {
+		t.Errorf("got the same version token of different contents")
+	}
+
+	path := newTestBin(t)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ETagOf(b)
+
+	etag, err := ReadETag(path)
+	if err != nil || etag != want {
+		t.Errorf("got %q (%v); want %q", etag, err, want)
+	}
+
+	rb, etag, err := ReadAllETag(path, nil)
+	if err != nil || etag != want || string(rb) != string(b) {
+		t.Errorf("got %q, and %q (%v); want %q, and %q", rb, etag, err, b, want)
+	}
+
+	_, err = ReadETag(path + ".x")
+	var errNotExist *ErrNotExist
+	if !errors.As(err, &errNotExist) {
+		t.Errorf("got %v of a missing binary; want an ErrNotExist", err)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	d := newTxTestDir(t)
+	b, etag, err := d.ReadAllETag("a.json")
+	if err != nil || string(b) != "a" {
+		t.Fatalf("got %q (%v); want the content", b, err)
+	}
+	stale := ETagOf([]byte("x"))
+
+	var errMismatch *ErrMismatch
+	err = d.OverIfMatch("a.json", strings.NewReader("a2"), stale)
+	if !errors.As(err, &errMismatch) || errMismatch.Want != stale || errMismatch.Got != etag {
+		t.Errorf("got %v of a stale version token; want an ErrMismatch", err)
+	}
+	err = d.RmIfMatch("a.json", stale)
+	if !errors.As(err, &errMismatch) {
+		t.Errorf("got %v of a stale version token; want an ErrMismatch", err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a", "b.json": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the failed preconditions; want %q", got, want)
+	}
+
+	err = d.OverIfMatch("a.json", strings.NewReader("a2"), etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The old one is stale now.
+	err = d.OverIfMatch("a.json", strings.NewReader("a3"), etag)
+	if !errors.As(err, &errMismatch) {
+		t.Errorf("got %v of a replaced version token; want an ErrMismatch", err)
+	}
+
+	// No precondition
+	err = d.OverIfMatch("a.json", strings.NewReader("a3"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.RmIfMatch("a.json", ETagOf([]byte("a3")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"b.json": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the remove; want %q", got, want)
+	}
+	errIfLeftBehinds(t, d)
+}
@@ -0,0 +1,334 @@
+package bin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/multierr"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Problem is an inconsistency found by a consistency check (e.g. Dir.Fsck).
+type Problem struct {
+	Kind   string
+	Path   string
+	Detail string
+	repair func() error
+}
+
+// Kinds of the problems found by Dir.Fsck.
+const (
+	ProblemOrphanTmp      = "orphan-tmp"
+	ProblemStaleLck       = "stale-lock"
+	ProblemStaleReader    = "stale-reader"
+	ProblemPendingJournal = "pending-journal"
+	ProblemInvalidJournal = "invalid-journal"
+	ProblemIrregular      = "irregular"
+)
+
+// NewProblem returns a problem, which can be repaired by calling repair (if not nil).
+func NewProblem(kind, path, detail string, repair func() error) *Problem {
+	return &Problem{kind, path, detail, repair}
+}
+
+func (p *Problem) Repairable() bool {
+	return p.repair != nil
+}
+
+func (p *Problem) Repair() error {
+	if p.repair == nil {
+		return fmt.Errorf("%s problem %q is not repairable", p.Kind, p.Path)
+	}
+
+	return p.repair()
+}
+
+func (p *Problem) String() string {
+	if p.Detail == "" {
+		return fmt.Sprintf("%s: %s", p.Kind, p.Path)
+	}
+
+	return fmt.Sprintf("%s: %s; %s", p.Kind, p.Path, p.Detail)
+}
+
+var lckRegex = regexp.MustCompile(`^\.(.+)\.lck\.tmp$`)
+var rlckDirRegex = regexp.MustCompile(`^\.(.+)\.lck\.tmp\.readers$`)
+
+// Fsck checks the bookkeeping files of the directory (i.e. everything but the binaries themselves), and reports the left-behinds of the dead processes; orphan temporary files, stale locks (see LckInfo.Stale), unrecovered journals, and irregular files.
+func (d *Dir) Fsck(lease time.Duration) ([]*Problem, error) {
+	dir := d.Dir()
+	if dir == "" {
+		dir = "."
+	}
+
+	ps := make([]*Problem, 0)
+	var rErr error
+
+	jDir := journalDir(dir)
+	es, err := os.ReadDir(jDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		rErr = multierr.Append(rErr, fmt.Errorf("failed to read directory entries of %q; %w", jDir, err))
+	}
+
+	for _, e := range es {
+		n := e.Name()
+		path := filepath.Join(jDir, n)
+		if journalTmpRegex.MatchString(n) {
+			p, err := fsckOldTmp(path)
+			rErr = multierr.Append(rErr, err)
+			ps = appendProblem(ps, p)
+		} else if lckRegex.MatchString(n) {
+			p, err := fsckLck(path, lease)
+			rErr = multierr.Append(rErr, err)
+			ps = appendProblem(ps, p)
+		} else if !strings.HasPrefix(n, ".") && filepath.Ext(n) == ".json" {
+			p, err := d.fsckJournal(strings.TrimSuffix(n, ".json"))
+			rErr = multierr.Append(rErr, err)
+			ps = appendProblem(ps, p)
+		}
+	}
+
+	es, err = os.ReadDir(dir)
+	if err != nil {
+		return ps, multierr.Append(rErr, fmt.Errorf("failed to read directory entries of %q; %w", dir, err))
+	}
+
+	for _, e := range es {
+		n := e.Name()
+		path := filepath.Join(dir, n)
+		if m := binTmpRegex.FindStringSubmatch(n); m != nil {
+			p, err := fsckBinTmp(filepath.Join(dir, m[1]), path)
+			rErr = multierr.Append(rErr, err)
+			ps = appendProblem(ps, p)
+		} else if lckRegex.MatchString(n) {
+			p, err := fsckLck(path, lease)
+			rErr = multierr.Append(rErr, err)
+			ps = appendProblem(ps, p)
+		} else if rlckDirRegex.MatchString(n) {
+			rps, err := fsckReaders(path)
+			rErr = multierr.Append(rErr, err)
+			ps = append(ps, rps...)
+		} else if !strings.HasPrefix(n, ".") && !e.Type().IsRegular() {
+			name := n
+			ps = append(ps, NewProblem(ProblemIrregular, path, fmt.Sprintf("file mode %v", e.Type()), func() error {
+				return d.Quarantine(name)
+			}))
+		}
+	}
+
+	return ps, rErr
+}
+
+func appendProblem(ps []*Problem, p *Problem) []*Problem {
+	if p == nil {
+		return ps
+	}
+
+	return append(ps, p)
+}
+
+func fsckOldTmp(tmpPath string) (*Problem, error) {
+	fi, err := os.Stat(tmpPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		} else {
+			return nil, fmt.Errorf("failed to stat temporary file %q; %w", tmpPath, err)
+		}
+	}
+
+	if time.Since(fi.ModTime()) <= orphanTmpMaxAge {
+		return nil, nil
+	}
+
+	return NewProblem(ProblemOrphanTmp, tmpPath, "", func() error {
+		return rmOldTmp(tmpPath)
+	}), nil
+}
+
+// fsckBinTmp checks temporary file tmpPath of the binary at path; it's orphan if no one is writing the binary.
+func fsckBinTmp(path string, tmpPath string) (*Problem, error) {
+	info, err := ReadLck(DefLckPath(path))
+	if err != nil {
+		var errNotExist *ErrNotExist
+		if !errors.As(err, &errNotExist) {
+			return nil, err
+		}
+	} else if !info.Stale(0) {
+		// Being written
+		return nil, nil
+	}
+
+	return NewProblem(ProblemOrphanTmp, tmpPath, "", func() error {
+		return rmOrphanTmp(path, tmpPath)
+	}), nil
+}
+
+func fsckLck(lckPath string, lease time.Duration) (*Problem, error) {
+	info, err := ReadLck(lckPath)
+	if err != nil {
+		var errNotExist *ErrNotExist
+		if errors.As(err, &errNotExist) {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	if !info.Stale(lease) {
+		return nil, nil
+	}
+
+	return NewProblem(ProblemStaleLck, lckPath, fmt.Sprintf("held by %s", info), func() error {
+		broken, err := BreakStaleLck(lckPath, lease)
+		var errNotExist *ErrNotExist
+		if errors.As(err, &errNotExist) {
+			// Already released; e.g. by the recovery of its journal.
+			return nil
+		} else if err == nil && !broken {
+			err = fmt.Errorf("lock file %q is not stale anymore", lckPath)
+		}
+
+		return err
+	}), nil
+}
+
+func fsckReaders(dir string) ([]*Problem, error) {
+	es, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		} else {
+			return nil, fmt.Errorf("failed to read readers directory %q; %w", dir, err)
+		}
+	}
+
+	ps := make([]*Problem, 0)
+	for _, e := range es {
+		tknPath := filepath.Join(dir, e.Name())
+		info, err := ReadLck(tknPath)
+		if err != nil {
+			var errNotExist *ErrNotExist
+			if errors.As(err, &errNotExist) {
+				continue
+			} else {
+				return ps, err
+			}
+		}
+
+		if info.Stale(0) {
+			ps = append(ps, NewProblem(ProblemStaleReader, tknPath, fmt.Sprintf("held by %s", info), func() error {
+				err := os.Remove(tknPath)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("failed to remove stale reader token %q; %w", tknPath, err)
+				}
+
+				return nil
+			}))
+		}
+	}
+
+	return ps, nil
+}
+
+func (d *Dir) fsckJournal(id string) (*Problem, error) {
+	dir := d.Dir()
+	jPath := journalPath(dir, id)
+	b, err := os.ReadFile(jPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		} else {
+			return nil, fmt.Errorf("failed to read journal %q; %w", jPath, err)
+		}
+	}
+
+	j := &journal{}
+	err = json.Unmarshal(b, j)
+	if err != nil || j.Owner == nil {
+		detail := "no owner"
+		if err != nil {
+			detail = err.Error()
+		}
+
+		return NewProblem(ProblemInvalidJournal, jPath, detail, func() error {
+			return d.quarantinePath(jPath)
+		}), nil
+	}
+
+	if !j.Owner.Stale(0) {
+		return nil, nil
+	}
+
+	return NewProblem(ProblemPendingJournal, jPath, fmt.Sprintf("owned by %s", j.Owner), func() error {
+		return recoverJournal(dir, id, d.opts)
+	}), nil
+}
+
+const quarantineDirName = "quarantine"
+
+// Quarantine moves the named (broken) binary out of the way, into the metadata directory.
+func (d *Dir) Quarantine(name string) error {
+	return d.QuarantineIf(name, nil)
+}
+
+// QuarantineIf is like Quarantine, but only if broken (if not nil) reports so, under the lock; fails otherwise.
+// For the problems found without the lock, which may be fixed by a write in the meantime.
+func (d *Dir) QuarantineIf(name string, broken func() (bool, error)) (rErr error) {
+	path := d.Path(name)
+	lckPath := DefLckPath(path)
+	lckFile, err := WLck(lckPath, d.opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := Unlck(lckPath, lckFile)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	if broken != nil {
+		b, err := broken()
+		if err != nil {
+			return err
+		}
+		if !b {
+			return fmt.Errorf("binary %q is not broken anymore", path)
+		}
+	}
+
+	return d.quarantinePath(path)
+}
+
+func (d *Dir) quarantinePath(path string) error {
+	qDir := d.MetaPath(quarantineDirName)
+	err := os.MkdirAll(qDir, 0775)
+	if err != nil {
+		return fmt.Errorf("failed to create directory %q (or one of its parents); %w", qDir, err)
+	}
+
+	qPath := filepath.Join(qDir, filepath.Base(path))
+	ex, err := exists(qPath)
+	if err != nil {
+		return fmt.Errorf("failed to check if %q exists or not; %w", qPath, err)
+	}
+	if ex {
+		qPath = fmt.Sprintf("%s-%d", qPath, time.Now().UnixNano())
+	}
+
+	err = os.Rename(path, qPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return NewErrNotExist(path)
+		} else {
+			return fmt.Errorf("failed to rename (move) %q to %q; %w", path, qPath, err)
+		}
+	}
+
+	return d.opts.syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,252 @@
+package bin
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deadPid returns the pid of a process which just exited.
+func deadPid(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	err := cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd.Process.Pid
+}
+
+// deadLckInfo returns the owner information of a dead process on this host.
+func deadLckInfo(t *testing.T) *LckInfo {
+	t.Helper()
+	return &LckInfo{Pid: deadPid(t), Host: hostname(), Acquired: time.Now()}
+}
+
+// writeTestFile writes file path (and its parent directories); as a json, unless v is a string.
+func writeTestFile(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, ok := v.(string)
+	if !ok {
+		bs, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = string(bs)
+	}
+
+	err = os.WriteFile(path, []byte(b), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// snapshot returns the files (and their contents) and directories under dir.
+func snapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	s := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if e.IsDir() {
+			s[path] = "dir"
+			return nil
+		}
+
+		b, err := os.ReadFile(path)
+		s[path] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestFsck(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDir(dir)
+	for _, n := range []string{"a.json", "b.json", "c.json", "d.json"} {
+		err := d.New(n, strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	jDir := journalDir(dir)
+	old := time.Now().Add(-2 * orphanTmpMaxAge)
+
+	// Broken; by kind
+	writeTestFile(t, filepath.Join(dir, ".a.json-1.tmp"), `{"a":2}`)
+	writeTestFile(t, filepath.Join(jDir, ".0abc-1.tmp"), `{}`)
+	err := os.Chtimes(filepath.Join(jDir, ".0abc-1.tmp"), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, DefLckPath(d.Path("b.json")), deadLckInfo(t))
+	writeTestFile(t, filepath.Join(RLckDir(DefLckPath(d.Path("c.json"))), "1.tmp"), deadLckInfo(t))
+	dead := deadLckInfo(t)
+	writeTestFile(t, filepath.Join(dir, ".d.json-1.tmp"), `{"d":2}`)
+	writeTestFile(t, journalPath(dir, "1"), &journal{Owner: dead, Ops: []journalOp{{Name: "d.json", Tmp: ".d.json-1.tmp"}}})
+	writeTestFile(t, DefLckPath(d.Path("d.json")), dead)
+	writeTestFile(t, journalPath(dir, "2"), `{"ops": [`)
+	err = os.Mkdir(d.Path("e"), 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Busy, not broken
+	writeTestFile(t, filepath.Join(jDir, ".0def-1.tmp"), `{}`)
+	writeTestFile(t, DefLckPath(d.Path("f.json")), newLckInfo())
+	writeTestFile(t, filepath.Join(dir, ".f.json-1.tmp"), `{"f":1}`)
+	writeTestFile(t, filepath.Join(RLckDir(DefLckPath(d.Path("a.json"))), "1.tmp"), newLckInfo())
+	writeTestFile(t, journalPath(dir, "3"), &journal{Owner: newLckInfo(), Ops: []journalOp{{Name: "f.json", Tmp: ".f.json-1.tmp"}}})
+
+	want := map[string]string{
+		filepath.Join(dir, ".a.json-1.tmp"):                           ProblemOrphanTmp,
+		filepath.Join(jDir, ".0abc-1.tmp"):                            ProblemOrphanTmp,
+		DefLckPath(d.Path("b.json")):                                  ProblemStaleLck,
+		filepath.Join(RLckDir(DefLckPath(d.Path("c.json"))), "1.tmp"): ProblemStaleReader,
+		filepath.Join(dir, ".d.json-1.tmp"):                           ProblemOrphanTmp,
+		journalPath(dir, "1"):                                         ProblemPendingJournal,
+		DefLckPath(d.Path("d.json")):                                  ProblemStaleLck,
+		journalPath(dir, "2"):                                         ProblemInvalidJournal,
+		d.Path("e"):                                                   ProblemIrregular,
+	}
+
+	// Checking changes nothing.
+	before := snapshot(t, dir)
+	ps, err := d.Fsck(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := snapshot(t, dir); !reflect.DeepEqual(before, after) {
+		t.Errorf("fsck changed the directory")
+	}
+
+	got := make(map[string]string)
+	for _, p := range ps {
+		got[p.Path] = p.Kind
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got the problems %v; want %v", got, want)
+	}
+
+	// In order, as fsck --repair does; the journals come first.
+	for _, p := range ps {
+		err := p.Repair()
+		if err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+
+	ps, err = d.Fsck(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 0 {
+		t.Errorf("got the problems %q after the repair", ps)
+	}
+
+	// The pending write is rolled forward, as its lock was still there.
+	b, err := os.ReadFile(d.Path("d.json"))
+	if err != nil || string(b) != `{"d":2}` {
+		t.Errorf("got %q (%v); want the journaled content", b, err)
+	}
+	// The irregular and the invalid journal are quarantined.
+	for _, n := range []string{"e", "2.json"} {
+		_, err := os.Stat(filepath.Join(d.MetaPath(quarantineDirName), n))
+		if err != nil {
+			t.Errorf("%q is not quarantined; %v", n, err)
+		}
+	}
+	_, err = os.Stat(filepath.Join(dir, ".f.json-1.tmp"))
+	if err != nil {
+		t.Errorf("the temporary file of a live writer is gone; %v", err)
+	}
+}
+
+func TestFsckLease(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDir(dir)
+	info := newLckInfo()
+	info.Acquired = time.Now().Add(-time.Hour)
+	lckPath := DefLckPath(d.Path("a.json"))
+	writeTestFile(t, lckPath, info)
+
+	ps, err := d.Fsck(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 0 {
+		t.Errorf("got the problems %q of a live lock, without a lease", ps)
+	}
+
+	ps, err = d.Fsck(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 || ps[0].Kind != ProblemStaleLck {
+		t.Fatalf("got the problems %q; want the expired lock", ps)
+	}
+
+	// Re-acquired in the meantime
+	writeTestFile(t, lckPath, newLckInfo())
+	err = ps[0].Repair()
+	if err == nil {
+		t.Errorf("broke a re-acquired lock")
+	}
+	_, err = os.Stat(lckPath)
+	if err != nil {
+		t.Errorf("broke a re-acquired lock; %v", err)
+	}
+}
+
+func TestQuarantineIf(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDir(dir)
+	err := d.New("a.json", strings.NewReader(`x`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.QuarantineIf("a.json", func() (bool, error) {
+		return false, nil
+	})
+	if err == nil {
+		t.Errorf("quarantined a fixed binary")
+	}
+	_, err = os.Stat(d.Path("a.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.QuarantineIf("a.json", func() (bool, error) {
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(d.MetaPath(quarantineDirName), "a.json"))
+	if err != nil || string(b) != "x" {
+		t.Errorf("got %q (%v) in the quarantine; want the binary", b, err)
+	}
+	_, err = os.Stat(d.Path("a.json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("the quarantined binary is left behind; %v", err)
+	}
+}
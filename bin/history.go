@@ -0,0 +1,265 @@
+package bin
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/multierr"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Rev is a prior version (revision) of a binary, kept in its history; see Opts.History.
+// Revisions of a binary are numbered from 1, in the order they're replaced (or removed).
+type Rev struct {
+	N int
+	// Time is when the revision was written.
+	Time time.Time
+	Size int64
+}
+
+const historyDirName = "history"
+
+// HistoryDir returns the path of the (hidden) directory which holds the revisions of the binary at path.
+func HistoryDir(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, MetaDirName, historyDirName, name)
+}
+
+func revPath(path string, n int) string {
+	return filepath.Join(HistoryDir(path), strconv.Itoa(n))
+}
+
+// Revs returns the revisions of the binary at path, oldest first.
+func Revs(path string) ([]*Rev, error) {
+	hDir := HistoryDir(path)
+	es, err := os.ReadDir(hDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []*Rev{}, nil
+		} else {
+			return nil, fmt.Errorf("failed to read directory entries of %q; %w", hDir, err)
+		}
+	}
+
+	rs := make([]*Rev, 0, len(es))
+	for _, e := range es {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil || n <= 0 {
+			// Bookkeeping
+			continue
+		}
+
+		fi, err := e.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Just pruned
+				continue
+			} else {
+				return nil, fmt.Errorf("failed to stat revision %q; %w", filepath.Join(hDir, e.Name()), err)
+			}
+		}
+
+		rs = append(rs, &Rev{n, fi.ModTime(), fi.Size()})
+	}
+
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].N < rs[j].N
+	})
+
+	return rs, nil
+}
+
+// OpenRev opens the nth revision of the binary at path.
+func OpenRev(path string, n int) (*os.File, error) {
+	f, err := os.Open(revPath(path, n))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, NewErrNoRev(path, n)
+		} else {
+			return nil, fmt.Errorf("failed to open revision %d of %q; %w", n, path, err)
+		}
+	}
+
+	return f, nil
+}
+
+// RestoreRev atomically replaces the binary at path with its nth revision; re-creates the binary if it's removed.
+// The replaced content becomes a revision itself (if the history is enabled), so a restore can be undone too.
+func RestoreRev(path string, n int, o *Opts) (rErr error) {
+	lckPath := DefLckPath(path)
+	lckFile, err := WLck(lckPath, o)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := Unlck(lckPath, lckFile)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	f, err := OpenRev(path, n)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close revision %d of %q; %w", n, path, err))
+		}
+	}()
+
+	ex, err := exists(path)
+	if err != nil {
+		return fmt.Errorf("failed to check if %q exists or not; %w", path, err)
+	}
+
+	return newOrOverBare(!ex, path, f, o)
+}
+
+// saveRev keeps the current content of the binary at path as its next revision, and prunes the old ones; to be called (while holding the lock) right before replacing or removing it.
+// Does nothing if the history is disabled, the binary doesn't exist, or it's already the last revision (e.g. when re-applying a journal; see applyJournal).
+func saveRev(path string, o *Opts) error {
+	keep := o.history()
+	if keep == 0 {
+		return nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else {
+			return fmt.Errorf("failed to stat %q; %w", path, err)
+		}
+	}
+
+	rs, err := Revs(path)
+	if err != nil {
+		return err
+	}
+
+	n := 1
+	if len(rs) > 0 {
+		last := rs[len(rs)-1]
+		lastFi, err := os.Stat(revPath(path, last.N))
+		if err == nil && os.SameFile(fi, lastFi) {
+			return nil
+		}
+
+		n = last.N + 1
+	}
+
+	hDir := HistoryDir(path)
+	err = os.MkdirAll(hDir, 0775)
+	if err != nil {
+		return fmt.Errorf("failed to create directory %q (or one of its parents); %w", hDir, err)
+	}
+
+	// The binary is replaced by renaming, never written in place; so a hard link is as good as a copy.
+	rPath := revPath(path, n)
+	err = os.Link(path, rPath)
+	if err != nil {
+		err = copyFile(path, rPath, o)
+		if err != nil {
+			rmErr := os.Remove(rPath)
+			if rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+				err = multierr.Append(err, fmt.Errorf("failed to remove incomplete revision %q; %w", rPath, rmErr))
+			}
+			return err
+		}
+	}
+
+	err = o.syncDir(hDir)
+	if err != nil {
+		return err
+	}
+
+	if keep < 0 || len(rs)+1 <= keep {
+		return nil
+	}
+
+	var rErr error
+	for _, r := range rs[:len(rs)+1-keep] {
+		err := os.Remove(revPath(path, r.N))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to remove old revision %d of %q; %w", r.N, path, err))
+		}
+	}
+
+	return rErr
+}
+
+// copyFile durably copies the file at src to (new file) dst, along with its modification time.
+func copyFile(src string, dst string, o *Opts) (rErr error) {
+	sf, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %q; %w", src, err)
+	}
+	defer sf.Close()
+
+	fi, err := sf.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %q; %w", src, err)
+	}
+
+	df, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+	if err != nil {
+		return fmt.Errorf("failed to create %q; %w", dst, err)
+	}
+	defer func() {
+		err := df.Close()
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close %q; %w", dst, err))
+		}
+	}()
+
+	_, err = io.Copy(df, sf)
+	if err != nil {
+		return fmt.Errorf("failed to copy %q to %q; %w", src, dst, err)
+	}
+
+	err = o.syncFile(df)
+	if err != nil {
+		return err
+	}
+
+	err = os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+	if err != nil {
+		return fmt.Errorf("failed to change the times of %q; %w", dst, err)
+	}
+
+	return nil
+}
+
+type ErrNoRev struct {
+	Path string
+	N    int
+}
+
+func (e *ErrNoRev) Error() string {
+	return fmt.Sprintf("%q has no revision %d", e.Path, e.N)
+}
+
+func NewErrNoRev(path string, n int) *ErrNoRev {
+	return &ErrNoRev{path, n}
+}
+
+func (d *Dir) Revs(name string) ([]*Rev, error) {
+	path := d.Path(name)
+	return Revs(path)
+}
+
+func (d *Dir) OpenRev(name string, n int) (*os.File, error) {
+	path := d.Path(name)
+	return OpenRev(path, n)
+}
+
+func (d *Dir) RestoreRev(name string, n int) error {
+	path := d.Path(name)
+	return RestoreRev(path, n, d.opts)
+}
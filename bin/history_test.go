@@ -0,0 +1,156 @@
+package bin
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// revContents returns the revisions of name in d, and their contents.
+func revContents(t *testing.T, d *Dir, name string) map[int]string {
+	t.Helper()
+	rs, err := d.Revs(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := make(map[int]string)
+	for _, r := range rs {
+		f, err := d.OpenRev(name, r.N)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size != int64(len(b)) {
+			t.Errorf("got the size %d of revision %d; want %d", r.Size, r.N, len(b))
+		}
+		c[r.N] = string(b)
+	}
+
+	return c
+}
+
+func TestHistory(t *testing.T) {
+	d := NewDirOpts(t.TempDir(), &Opts{History: 2})
+	err := d.New("a.json", strings.NewReader("a1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := revContents(t, d, "a.json"); len(got) != 0 {
+		t.Errorf("got %q of a new binary; want no revisions", got)
+	}
+
+	for _, s := range []string{"a2", "a3", "a4"} {
+		err := d.Over("a.json", strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The oldest is pruned.
+	if got, want := revContents(t, d, "a.json"), map[int]string{2: "a2", 3: "a3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the overwrites; want %q", got, want)
+	}
+	var errNoRev *ErrNoRev
+	_, err = d.OpenRev("a.json", 1)
+	if !errors.As(err, &errNoRev) || errNoRev.N != 1 {
+		t.Errorf("got %v of a pruned revision; want an ErrNoRev", err)
+	}
+
+	err = d.Rm("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := revContents(t, d, "a.json"), map[int]string{3: "a3", 4: "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the remove; want %q", got, want)
+	}
+
+	// Re-creates the removed binary.
+	err = d.RestoreRev("a.json", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after restoring revision 4; want %q", got, want)
+	}
+	if got, want := revContents(t, d, "a.json"), map[int]string{3: "a3", 4: "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after re-creating; want %q", got, want)
+	}
+
+	// The replaced content becomes a revision.
+	err = d.RestoreRev("a.json", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after restoring revision 3; want %q", got, want)
+	}
+	if got, want := revContents(t, d, "a.json"), map[int]string{4: "a4", 5: "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the restore; want %q", got, want)
+	}
+
+	err = d.RestoreRev("a.json", 1)
+	if !errors.As(err, &errNoRev) {
+		t.Errorf("got %v of restoring a pruned revision; want an ErrNoRev", err)
+	}
+	errIfLeftBehinds(t, d)
+}
+
+func TestHistoryKeep(t *testing.T) {
+	for _, c := range []struct {
+		keep int
+		want map[int]string
+	}{
+		{0, map[int]string{}},
+		{1, map[int]string{3: "a3"}},
+		{-1, map[int]string{1: "a1", 2: "a2", 3: "a3"}},
+	} {
+		d := NewDirOpts(t.TempDir(), &Opts{History: c.keep})
+		err := d.New("a.json", strings.NewReader("a1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"a2", "a3", "a4"} {
+			err := d.Over("a.json", strings.NewReader(s))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := revContents(t, d, "a.json"); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("keep %d: got %q; want %q", c.keep, got, c.want)
+		}
+		if c.keep == 0 {
+			_, err := os.Stat(HistoryDir(d.Path("a.json")))
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("keep 0: the history directory is created; %v", err)
+			}
+		}
+	}
+}
+
+func TestHistoryTx(t *testing.T) {
+	d := newTxTestDir(t)
+	d = NewDirOpts(d.Dir(), &Opts{History: -1})
+	tx := stageTestTx(t, d)
+	err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := revContents(t, d, "a.json"), map[int]string{1: "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q of the overwritten a.json; want %q", got, want)
+	}
+	if got, want := revContents(t, d, "b.json"), map[int]string{1: "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q of the removed b.json; want %q", got, want)
+	}
+	if got := revContents(t, d, "c.json"); len(got) != 0 {
+		t.Errorf("got %q of the created c.json; want no revisions", got)
+	}
+}
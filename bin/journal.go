@@ -0,0 +1,380 @@
+package bin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/multierr"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A journal records the changes about to be applied on the binaries of a directory, e.g. renaming a temporary file, holding the new content of a binary, over it.
+// It's durably written into the metadata directory before applying the changes (the commit point), and removed after; one per transaction (see Tx), or per single write (see New and Over).
+// If its owner dies in between, Recover finishes the job:
+//   - A transaction is rolled forward; its locks can't be broken while its journal exists (see BreakStaleLck).
+//   - A single write is rolled forward only if its (stale) lock is still there, so nothing could have been written since; otherwise, it's dropped. Either is fine, as the write was never acknowledged.
+type journal struct {
+	Owner *LckInfo    `json:"owner"`
+	Ops   []journalOp `json:"ops"`
+}
+
+type journalOp struct {
+	Name string `json:"name"`
+	Tmp  string `json:"tmp,omitempty"`
+	Rm   bool   `json:"rm,omitempty"`
+}
+
+const journalDirName = "journal"
+
+// orphanTmpMaxAge is how old a temporary file, without a live writer, should be to be considered orphan.
+const orphanTmpMaxAge = time.Minute
+
+// Patterns of the temporary file names; see openTmp.
+var binTmpRegex = regexp.MustCompile(`^\.(.+)-[0-9]+\.tmp$`)
+var journalTmpRegex = regexp.MustCompile(`^\.[0-9a-f]+-[0-9]+\.tmp$`)
+
+func journalDir(dir string) string {
+	return filepath.Join(dir, MetaDirName, journalDirName)
+}
+
+func journalPath(dir string, id string) string {
+	return filepath.Join(journalDir(dir), id+".json")
+}
+
+// writeJournal durably writes j as the journal with the given id, for the binaries of dir; returns its path.
+func writeJournal(dir string, id string, j *journal, o *Opts) (string, error) {
+	jDir := journalDir(dir)
+	err := os.MkdirAll(jDir, 0775)
+	if err != nil {
+		return "", fmt.Errorf("failed to create directory %q (or one of its parents); %w", jDir, err)
+	}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode journal %q; %w", id, err)
+	}
+
+	tmpFile, err := openTmp(jDir, "."+id+"-*.tmp")
+	if err != nil {
+		if tmpFile != nil {
+			err = multierr.Append(err, rmTmp(tmpFile))
+		}
+		return "", err
+	}
+	tmpPath := tmpFile.Name()
+
+	_, err = tmpFile.Write(b)
+	if err != nil {
+		err = fmt.Errorf("failed to write to temporary file %q; %w", tmpPath, err)
+		return "", multierr.Append(err, rmTmp(tmpFile))
+	}
+
+	err = o.syncFile(tmpFile)
+	if err != nil {
+		return "", multierr.Append(err, rmTmp(tmpFile))
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		err = fmt.Errorf("failed to close temporary file %q; %w", tmpPath, err)
+		return "", multierr.Append(err, rmTmp(tmpFile))
+	}
+
+	jPath := journalPath(dir, id)
+	err = os.Rename(tmpPath, jPath)
+	if err != nil {
+		err = fmt.Errorf("failed to rename (move) temporary file %q to %q; %w", tmpPath, jPath, err)
+		return "", multierr.Append(err, rmTmp(tmpFile))
+	}
+
+	err = o.syncDir(jDir)
+	if err != nil {
+		return "", multierr.Append(err, os.Remove(jPath))
+	}
+
+	return jPath, nil
+}
+
+// applyJournal applies (or re-applies) the changes of j on the binaries of dir; idempotent.
+func applyJournal(dir string, j *journal, o *Opts) error {
+	for _, op := range j.Ops {
+		path := filepath.Join(dir, op.Name)
+		if op.Rm {
+			err := rmBin(path, o)
+			var errNotExist *ErrNotExist
+			if err != nil && !errors.As(err, &errNotExist) {
+				return err
+			}
+		} else {
+			tmpPath := filepath.Join(dir, op.Tmp)
+			ex, err := exists(tmpPath)
+			if err != nil {
+				return fmt.Errorf("failed to check if %q exists or not; %w", tmpPath, err)
+			}
+			if !ex {
+				// Already applied
+				continue
+			}
+
+			err = saveRev(path, o)
+			if err != nil {
+				return err
+			}
+
+			err = os.Rename(tmpPath, path)
+			if err != nil {
+				return fmt.Errorf("failed to rename (move) temporary file %q to %q; %w", tmpPath, path, err)
+			}
+		}
+	}
+
+	return o.syncDir(dir)
+}
+
+// dropJournal removes the temporary files of j; the opposite of applyJournal.
+func dropJournal(dir string, j *journal) error {
+	var rErr error
+	for _, op := range j.Ops {
+		if op.Tmp == "" {
+			continue
+		}
+
+		tmpPath := filepath.Join(dir, op.Tmp)
+		err := os.Remove(tmpPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to remove temporary file %q; %w", tmpPath, err))
+		}
+	}
+
+	return rErr
+}
+
+// Recover finishes (or drops) the writes left behind by dead processes in dir, i.e. recovers the journals of dead owners (see journal), and removes the orphan temporary files.
+// Should be run before working with a directory which may have been left behind by a crashed process.
+// Note that a temporary file of a write done without holding the default lock (e.g. NewBare) may be mistaken for an orphan.
+func Recover(dir string) error {
+	return RecoverOpts(dir, nil)
+}
+
+func RecoverOpts(dir string, o *Opts) error {
+	if dir == "" {
+		dir = "."
+	}
+
+	var rErr error
+
+	jDir := journalDir(dir)
+	es, err := os.ReadDir(jDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		rErr = multierr.Append(rErr, fmt.Errorf("failed to read directory entries of %q; %w", jDir, err))
+	}
+
+	for _, e := range es {
+		n := e.Name()
+		if journalTmpRegex.MatchString(n) {
+			rErr = multierr.Append(rErr, rmOldTmp(filepath.Join(jDir, n)))
+		} else if !strings.HasPrefix(n, ".") && filepath.Ext(n) == ".json" {
+			rErr = multierr.Append(rErr, recoverJournal(dir, strings.TrimSuffix(n, ".json"), o))
+		}
+	}
+
+	es, err = os.ReadDir(dir)
+	if err != nil {
+		return multierr.Append(rErr, fmt.Errorf("failed to read directory entries of %q; %w", dir, err))
+	}
+
+	for _, e := range es {
+		n := e.Name()
+		if m := binTmpRegex.FindStringSubmatch(n); m != nil {
+			rErr = multierr.Append(rErr, rmOrphanTmp(filepath.Join(dir, m[1]), filepath.Join(dir, n)))
+		}
+	}
+
+	return rErr
+}
+
+func (d *Dir) Recover() error {
+	return RecoverOpts(d.Dir(), d.opts)
+}
+
+func recoverJournal(dir string, id string, o *Opts) (rErr error) {
+	jPath := journalPath(dir, id)
+
+	// Serialize the recoverers
+	lckPath := DefLckPath(jPath)
+	lckFile, err := Lck(lckPath)
+	if err != nil {
+		var errLcked *ErrLcked
+		if errors.As(err, &errLcked) {
+			// Another recoverer is at work.
+			return nil
+		} else {
+			return err
+		}
+	}
+	defer func() {
+		err := Unlck(lckPath, lckFile)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	b, err := os.ReadFile(jPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Just finished by its owner, or another recoverer
+			return nil
+		} else {
+			return fmt.Errorf("failed to read journal %q; %w", jPath, err)
+		}
+	}
+
+	j := &journal{}
+	err = json.Unmarshal(b, j)
+	if err != nil {
+		return fmt.Errorf("failed to decode journal %q; %w", jPath, err)
+	} else if j.Owner == nil {
+		return fmt.Errorf("journal %q has no owner", jPath)
+	}
+
+	if !j.Owner.Stale(0) {
+		// Still in progress
+		return nil
+	}
+
+	if j.Owner.Tx != "" {
+		return recoverTxJournal(dir, jPath, j, o)
+	} else {
+		return recoverWriteJournal(dir, jPath, j, o)
+	}
+}
+
+func recoverTxJournal(dir string, jPath string, j *journal, o *Opts) error {
+	id := j.Owner.Tx
+	err := applyJournal(dir, j, o)
+	if err != nil {
+		return fmt.Errorf("failed to roll forward transaction %q; %w", id, err)
+	}
+
+	err = os.Remove(jPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove the journal %q of recovered transaction %q; %w", jPath, id, err)
+	}
+
+	// The journal only records the changed binaries; the locks of the others are released by the regular stale lock breaking.
+	var rErr error
+	for _, op := range j.Ops {
+		lckPath := DefLckPath(filepath.Join(dir, op.Name))
+		rErr = multierr.Append(rErr, withBrkLck(lckPath, func() error {
+			if !ownsLck(lckPath, j.Owner) {
+				return nil
+			}
+
+			err := os.Remove(lckPath)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove lock file %q; %w", lckPath, err)
+			}
+
+			return nil
+		}))
+	}
+
+	return rErr
+}
+
+func recoverWriteJournal(dir string, jPath string, j *journal, o *Opts) error {
+	var rErr error
+	for _, op := range j.Ops {
+		lckPath := DefLckPath(filepath.Join(dir, op.Name))
+		rErr = multierr.Append(rErr, withBrkLck(lckPath, func() error {
+			owned := ownsLck(lckPath, j.Owner)
+			if owned {
+				err := applyJournal(dir, j, o)
+				if err != nil {
+					return fmt.Errorf("failed to roll forward the write of %q; %w", op.Name, err)
+				}
+			} else {
+				err := dropJournal(dir, j)
+				if err != nil {
+					return fmt.Errorf("failed to drop the write of %q; %w", op.Name, err)
+				}
+			}
+
+			err := os.Remove(jPath)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove journal %q; %w", jPath, err)
+			}
+
+			if owned {
+				err := os.Remove(lckPath)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("failed to remove lock file %q; %w", lckPath, err)
+				}
+			}
+
+			return nil
+		}))
+	}
+
+	return rErr
+}
+
+// ownsLck reports whether the lock file at lckPath is held by owner.
+func ownsLck(lckPath string, owner *LckInfo) bool {
+	info, err := ReadLck(lckPath)
+	return err == nil && info.Pid == owner.Pid && info.Host == owner.Host && info.Tx == owner.Tx
+}
+
+// rmOrphanTmp removes temporary file tmpPath of the binary at path, if no one is writing the binary.
+func rmOrphanTmp(path string, tmpPath string) (rErr error) {
+	lckPath := DefLckPath(path)
+	lckFile, err := Lck(lckPath)
+	if err != nil {
+		if isBusy(err) {
+			return nil
+		} else {
+			return err
+		}
+	}
+	defer func() {
+		err := Unlck(lckPath, lckFile)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	// The journals of the dead are already recovered, and the living hold the lock while writing.
+	err = os.Remove(tmpPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove orphan temporary file %q; %w", tmpPath, err)
+	}
+
+	return nil
+}
+
+// rmOldTmp removes temporary file tmpPath if it's older than orphanTmpMaxAge; for the temporary files which are not protected by a lock.
+func rmOldTmp(tmpPath string) error {
+	fi, err := os.Stat(tmpPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else {
+			return fmt.Errorf("failed to stat temporary file %q; %w", tmpPath, err)
+		}
+	}
+
+	if time.Since(fi.ModTime()) <= orphanTmpMaxAge {
+		return nil
+	}
+
+	err = os.Remove(tmpPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove orphan temporary file %q; %w", tmpPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package bin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNoJournal(t *testing.T) {
+	for _, noJ := range []bool{false, true} {
+		// A directory of user data, which just happens to have a MetaDirName in its path
+		d := filepath.Join(t.TempDir(), MetaDirName, "books")
+		err := os.MkdirAll(d, 0775)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = NewOpts(filepath.Join(d, "x.json"), strings.NewReader(`{"a":1}`), &Opts{NoJournal: noJ})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = os.Stat(journalDir(d))
+		if journaled := err == nil; journaled == noJ {
+			t.Errorf("no journal %v: journaled %v; %v", noJ, journaled, err)
+		}
+	}
+}
+
+// plantWrite plants a single write of content s over the named binary of d, as left by a writer which died after writing its journal; its lock is left too, if lcked.
+func plantWrite(t *testing.T, d *Dir, name string, s string, lcked bool) string {
+	t.Helper()
+	dead := deadLckInfo(t)
+	tmp := "." + name + "-1.tmp"
+	writeTestFile(t, filepath.Join(d.Dir(), tmp), s)
+	id := genId()
+	writeTestFile(t, journalPath(d.Dir(), id), &journal{Owner: dead, Ops: []journalOp{{Name: name, Tmp: tmp}}})
+	if lcked {
+		writeTestFile(t, DefLckPath(d.Path(name)), dead)
+	}
+
+	return id
+}
+
+func TestRecoverWriteJournal(t *testing.T) {
+	d := newTxTestDir(t)
+
+	// Lock still there; nothing could have been written since.
+	plantWrite(t, d, "a.json", "a2", true)
+	// Lock broken, and maybe written since; dropped.
+	plantWrite(t, d, "b.json", "b2", false)
+	// A create
+	plantWrite(t, d, "c.json", "c", true)
+
+	err := d.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := contents(t, d), map[string]string{"a.json": "a2", "b.json": "b", "c.json": "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the recovery; want %q", got, want)
+	}
+	errIfLeftBehinds(t, d)
+}
+
+func TestRecoverLiveJournal(t *testing.T) {
+	d := newTxTestDir(t)
+	tmp := ".a.json-1.tmp"
+	writeTestFile(t, filepath.Join(d.Dir(), tmp), "a2")
+	writeTestFile(t, journalPath(d.Dir(), "1"), &journal{Owner: newLckInfo(), Ops: []journalOp{{Name: "a.json", Tmp: tmp}}})
+	writeTestFile(t, DefLckPath(d.Path("a.json")), newLckInfo())
+
+	before := snapshot(t, d.Dir())
+	err := d.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := snapshot(t, d.Dir()); !reflect.DeepEqual(before, after) {
+		t.Errorf("recovered the journal of a live owner")
+	}
+}
+
+func TestRecoverTxJournal(t *testing.T) {
+	d := newTxTestDir(t)
+	err := d.New("c.json", strings.NewReader("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dead := deadLckInfo(t)
+	dead.Tx = "1"
+	writeTestFile(t, filepath.Join(d.Dir(), ".a.json-1.tmp"), "a2")
+	writeTestFile(t, filepath.Join(d.Dir(), ".d.json-1.tmp"), "d")
+	j := &journal{Owner: dead, Ops: []journalOp{
+		{Name: "a.json", Tmp: ".a.json-1.tmp"},
+		{Name: "b.json", Rm: true},
+		// Already applied, before the crash
+		{Name: "c.json", Tmp: ".c.json-1.tmp"},
+		{Name: "d.json", Tmp: ".d.json-1.tmp"},
+	}}
+	writeTestFile(t, journalPath(d.Dir(), "1"), j)
+	// Rolled forward, regardless of the locks; some may be released already.
+	for _, n := range []string{"a.json", "b.json"} {
+		writeTestFile(t, DefLckPath(d.Path(n)), dead)
+	}
+
+	err = d.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := contents(t, d), map[string]string{"a.json": "a2", "c.json": "c", "d.json": "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the recovery; want %q", got, want)
+	}
+	errIfLeftBehinds(t, d)
+
+	// Idempotent
+	writeTestFile(t, journalPath(d.Dir(), "1"), j)
+	err = d.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a2", "c.json": "c", "d.json": "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after recovering an applied journal; want %q", got, want)
+	}
+	errIfLeftBehinds(t, d)
+}
+
+func TestRecoverInvalidJournal(t *testing.T) {
+	d := newTxTestDir(t)
+	writeTestFile(t, journalPath(d.Dir(), "1"), `{"ops": [`)
+	writeTestFile(t, journalPath(d.Dir(), "2"), `{"ops": []}`)
+
+	err := d.Recover()
+	if err == nil {
+		t.Errorf("recovered invalid journals")
+	}
+	for _, id := range []string{"1", "2"} {
+		_, err := os.Stat(journalPath(d.Dir(), id))
+		if err != nil {
+			t.Errorf("invalid journal %q is removed; %v", id, err)
+		}
+	}
+}
+
+func TestRecoverOrphanTmp(t *testing.T) {
+	d := newTxTestDir(t)
+	jDir := journalDir(d.Dir())
+	old := time.Now().Add(-2 * orphanTmpMaxAge)
+
+	// Orphans
+	writeTestFile(t, filepath.Join(d.Dir(), ".a.json-1.tmp"), "a2")
+	writeTestFile(t, filepath.Join(d.Dir(), ".c.json-1.tmp"), "c")
+	writeTestFile(t, filepath.Join(d.Dir(), ".b.json-1.tmp"), "b2")
+	writeTestFile(t, DefLckPath(d.Path("b.json")), deadLckInfo(t))
+	writeTestFile(t, filepath.Join(jDir, ".0abc-1.tmp"), "{}")
+	err := os.Chtimes(filepath.Join(jDir, ".0abc-1.tmp"), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Of the living; a write in progress, and a journal just being written.
+	writeTestFile(t, filepath.Join(d.Dir(), ".d.json-1.tmp"), "d")
+	writeTestFile(t, DefLckPath(d.Path("d.json")), newLckInfo())
+	writeTestFile(t, filepath.Join(jDir, ".0def-1.tmp"), "{}")
+
+	err = d.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{filepath.Join(d.Dir(), ".a.json-1.tmp"), filepath.Join(d.Dir(), ".b.json-1.tmp"), filepath.Join(d.Dir(), ".c.json-1.tmp"), DefLckPath(d.Path("b.json")), filepath.Join(jDir, ".0abc-1.tmp")} {
+		_, err := os.Stat(p)
+		if !os.IsNotExist(err) {
+			t.Errorf("orphan %q is left behind; %v", p, err)
+		}
+	}
+	for _, p := range []string{filepath.Join(d.Dir(), ".d.json-1.tmp"), DefLckPath(d.Path("d.json")), filepath.Join(jDir, ".0def-1.tmp")} {
+		_, err := os.Stat(p)
+		if err != nil {
+			t.Errorf("%q of a live writer is removed; %v", p, err)
+		}
+	}
+
+	if got, want := contents(t, d), map[string]string{"a.json": "a", "b.json": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the recovery; want %q", got, want)
+	}
+}
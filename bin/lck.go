@@ -1,13 +1,136 @@
 package bin
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"go.uber.org/multierr"
+	"io"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
+// LckInfo is the owner metadata recorded in a lock file by Lck.
+type LckInfo struct {
+	Pid      int       `json:"pid"`
+	Host     string    `json:"host"`
+	Acquired time.Time `json:"acquired"`
+	// Tx is the id of the transaction holding the lock, if any; see Tx.
+	Tx string `json:"tx,omitempty"`
+	// Reader tells whether the lock is held by a reader, just while it registers its token; see RLckLckPath.
+	Reader bool `json:"reader,omitempty"`
+}
+
+// brkLckMaxAge is how long a lock breaker may hold its own (breaker) lock before it's considered stale; breaking a lock takes a few file operations, not minutes.
+const brkLckMaxAge = time.Minute
+
+// Lck acquires the lock file at path, recording the current process as its owner.
+// If the lock is already held by a dead process on this host, the stale lock is broken (see BreakStaleLck) and the acquisition is retried once.
 func Lck(path string) (*os.File, error) {
+	return lckTx(path, "")
+}
+
+func lckTx(path string, tx string) (*os.File, error) {
+	info := newLckInfo()
+	info.Tx = tx
+	return lckInfo(path, info)
+}
+
+// lckInfo is like Lck, but records info as the owner.
+func lckInfo(path string, info *LckInfo) (*os.File, error) {
+	f, err := lck(path, info)
+	if err == nil {
+		return f, nil
+	}
+
+	var errLcked *ErrLcked
+	if !errors.As(err, &errLcked) {
+		return nil, err
+	}
+
+	broken, brkErr := BreakStaleLck(path, 0)
+	if brkErr != nil || !broken {
+		// Report the original lock clash; the breaking attempt is just a bonus.
+		return nil, err
+	}
+
+	return lck(path, info)
+}
+
+// LckWait is like Lck, but if w is not nil, keeps retrying (with exponential backoff) while the lock is held by someone else.
+// Gives up on w's timeout or context cancellation; the returned error then wraps the last ErrLcked or the context's error.
+func LckWait(path string, w *WaitOpts) (*os.File, error) {
+	return lckWaitTx(path, w, "")
+}
+
+func lckWaitTx(path string, w *WaitOpts, tx string) (*os.File, error) {
+	var f *os.File
+	err := retry(w, func() error {
+		var err error
+		f, err = lckTx(path, tx)
+		return err
+	})
+
+	return f, err
+}
+
+// retry calls try until it doesn't fail with an ErrLcked or an ErrRLcked; waits in between according to w.
+// A nil w means a single try.
+func retry(w *WaitOpts, try func() error) error {
+	if w == nil {
+		return try()
+	}
+
+	ctx := w.ctx()
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+
+	backoff := w.minBackoff()
+	for {
+		err := try()
+		if !isBusy(err) {
+			return err
+		}
+
+		t := time.NewTimer(jitter(backoff))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			if w.Timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out after %v waiting for the lock; %w", w.Timeout, err)
+			} else {
+				return multierr.Append(fmt.Errorf("stopped waiting for the lock; %w", ctx.Err()), err)
+			}
+		case <-t.C:
+		}
+
+		backoff *= 2
+		if max := w.maxBackoff(); backoff > max {
+			backoff = max
+		}
+	}
+}
+
+func isBusy(err error) bool {
+	var errLcked *ErrLcked
+	var errRLcked *ErrRLcked
+	return errors.As(err, &errLcked) || errors.As(err, &errRLcked)
+}
+
+// jitter returns a random duration in [d/2, d]; spreads the retries of competing waiters.
+func jitter(d time.Duration) time.Duration {
+	h := int64(d / 2)
+	return time.Duration(h + rand.Int63n(h+1))
+}
+
+func lck(path string, info *LckInfo) (*os.File, error) {
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR|os.O_TRUNC, 0664)
 	if err != nil {
 		if errors.Is(err, os.ErrExist) {
@@ -17,6 +140,12 @@ func Lck(path string) (*os.File, error) {
 		}
 	}
 
+	err = json.NewEncoder(f).Encode(info)
+	if err != nil {
+		err = fmt.Errorf("failed to write the owner information into lock file %q; %w", path, err)
+		return nil, multierr.Append(err, Unlck(path, f))
+	}
+
 	return f, nil
 }
 
@@ -34,6 +163,129 @@ func Unlck(path string, f *os.File) (rErr error) {
 	return
 }
 
+// ReadLck reads the owner information of the lock file at path.
+// A lock file without (valid) owner information, e.g. one that's just being created, is reported with a zero Pid and its modification time as the acquire time.
+func ReadLck(path string) (*LckInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, NewErrNotExist(path)
+		} else {
+			return nil, fmt.Errorf("failed to open lock file %q; %w", path, err)
+		}
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file %q; %w", path, err)
+	}
+
+	info := &LckInfo{}
+	err = json.Unmarshal(b, info)
+	if err != nil || info.Acquired.IsZero() {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat lock file %q; %w", path, err)
+		}
+
+		info = &LckInfo{Acquired: fi.ModTime()}
+	}
+
+	return info, nil
+}
+
+// Stale reports whether the lock is abandoned; its owner is a dead process on this host, or (if lease is positive) it's been held for longer than lease.
+func (i *LckInfo) Stale(lease time.Duration) bool {
+	if lease > 0 && time.Since(i.Acquired) > lease {
+		return true
+	}
+
+	return i.Pid > 0 && i.Host == hostname() && !procAlive(i.Pid)
+}
+
+func (i *LckInfo) String() string {
+	if i.Pid <= 0 {
+		return fmt.Sprintf("unknown owner since %s", i.Acquired.Format(time.RFC3339))
+	}
+
+	return fmt.Sprintf("process %d on host %q since %s", i.Pid, i.Host, i.Acquired.Format(time.RFC3339))
+}
+
+// BreakStaleLck removes the lock file at path if it's stale (see LckInfo.Stale); reports whether it did.
+// Breakers are serialized through a lock of their own, so a lock that's re-acquired right after being judged stale is never removed.
+// A lock of a committed, but not yet recovered, transaction is never broken (see Dir.Recover); doing so may let a write in, just to be overwritten by the recovery.
+func BreakStaleLck(path string, lease time.Duration) (bool, error) {
+	broken := false
+	err := withBrkLck(path, func() error {
+		info, err := ReadLck(path)
+		if err != nil {
+			return err
+		}
+
+		if !info.Stale(lease) {
+			return nil
+		}
+
+		if info.Tx != "" {
+			jPath := journalPath(filepath.Dir(path), info.Tx)
+			ex, err := exists(jPath)
+			if err != nil {
+				return fmt.Errorf("failed to check if %q exists or not; %w", jPath, err)
+			}
+			if ex {
+				return fmt.Errorf("lock file %q belongs to committed transaction %q, which awaits recovery", path, info.Tx)
+			}
+		}
+
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale lock file %q; %w", path, err)
+		}
+
+		broken = true
+		return nil
+	})
+
+	return broken, err
+}
+
+// withBrkLck runs f while holding the breaker lock of the lock file at path; so f is the only one who may remove the lock file, while it exists.
+func withBrkLck(path string, f func() error) (rErr error) {
+	brkPath := DefLckPath(path)
+	brkFile, err := lck(brkPath, newLckInfo())
+	if err != nil {
+		var errLcked *ErrLcked
+		if !errors.As(err, &errLcked) {
+			return err
+		}
+
+		// Another breaker is at work, or died at work.
+		info, err := ReadLck(brkPath)
+		if err != nil || !info.Stale(brkLckMaxAge) {
+			return NewErrLcked(brkPath)
+		}
+
+		err = os.Remove(brkPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale lock file %q; %w", brkPath, err)
+		}
+
+		brkFile, err = lck(brkPath, newLckInfo())
+		if err != nil {
+			return err
+		}
+	}
+	defer func() {
+		err := Unlck(brkPath, brkFile)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	return f()
+}
+
 type ErrLcked string
 
 func (e *ErrLcked) Error() string {
@@ -48,3 +300,21 @@ func NewErrLcked(path string) *ErrLcked {
 	e := ErrLcked(path)
 	return &e
 }
+
+func newLckInfo() *LckInfo {
+	return &LckInfo{Pid: os.Getpid(), Host: hostname(), Acquired: time.Now()}
+}
+
+var hostnameOnce sync.Once
+var hostnameVal string
+
+func hostname() string {
+	hostnameOnce.Do(func() {
+		h, err := os.Hostname()
+		if err == nil {
+			hostnameVal = h
+		}
+	})
+
+	return hostnameVal
+}
@@ -0,0 +1,191 @@
+package bin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLckInfoStale(t *testing.T) {
+	dead := deadPid(t)
+	now := time.Now()
+	for _, c := range []struct {
+		info  LckInfo
+		lease time.Duration
+		want  bool
+	}{
+		{LckInfo{Pid: os.Getpid(), Host: hostname(), Acquired: now}, 0, false},
+		{LckInfo{Pid: dead, Host: hostname(), Acquired: now}, 0, true},
+		// Of another host; can't tell if it's dead.
+		{LckInfo{Pid: dead, Host: hostname() + "-other", Acquired: now}, 0, false},
+		// Unknown owner (e.g. just being created)
+		{LckInfo{Acquired: now}, 0, false},
+		// By lease, regardless of the owner
+		{LckInfo{Pid: os.Getpid(), Host: hostname(), Acquired: now.Add(-time.Hour)}, 0, false},
+		{LckInfo{Pid: os.Getpid(), Host: hostname(), Acquired: now.Add(-time.Hour)}, time.Minute, true},
+		{LckInfo{Pid: dead, Host: hostname() + "-other", Acquired: now.Add(-time.Hour)}, time.Minute, true},
+		{LckInfo{Acquired: now.Add(-time.Hour)}, time.Minute, true},
+		{LckInfo{Pid: os.Getpid(), Host: hostname(), Acquired: now}, time.Minute, false},
+	} {
+		if got := c.info.Stale(c.lease); got != c.want {
+			t.Errorf("%s, lease %v: got stale %v; want %v", &c.info, c.lease, got, c.want)
+		}
+	}
+}
+
+func TestReadLck(t *testing.T) {
+	lckPath := filepath.Join(t.TempDir(), ".a.json.lck.tmp")
+	_, err := ReadLck(lckPath)
+	var errNotExist *ErrNotExist
+	if !errors.As(err, &errNotExist) {
+		t.Errorf("got %v of a missing lock; want an ErrNotExist", err)
+	}
+
+	// Just being created; no owner information yet.
+	writeTestFile(t, lckPath, "")
+	info, err := ReadLck(lckPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Pid != 0 || info.Acquired.IsZero() || info.Stale(0) {
+		t.Errorf("got %s of an empty lock; want an unknown, live, owner since its modification", info)
+	}
+}
+
+func TestBreakStaleLck(t *testing.T) {
+	dir := t.TempDir()
+	lckPath := DefLckPath(filepath.Join(dir, "a.json"))
+	for _, c := range []struct {
+		info  *LckInfo
+		lease time.Duration
+		want  bool
+	}{
+		{newLckInfo(), 0, false},
+		{deadLckInfo(t), 0, true},
+		{&LckInfo{Pid: 1, Host: hostname() + "-other", Acquired: time.Now()}, 0, false},
+		{&LckInfo{Pid: 1, Host: hostname() + "-other", Acquired: time.Now().Add(-time.Hour)}, time.Minute, true},
+	} {
+		writeTestFile(t, lckPath, c.info)
+		broken, err := BreakStaleLck(lckPath, c.lease)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = os.Stat(lckPath)
+		if broken != c.want || os.IsNotExist(err) != c.want {
+			t.Errorf("%s, lease %v: got broken %v (%v); want %v", c.info, c.lease, broken, err, c.want)
+		}
+	}
+
+	// The breaker lock is released.
+	_, err := os.Stat(DefLckPath(lckPath))
+	if !os.IsNotExist(err) {
+		t.Errorf("the breaker lock is left behind; %v", err)
+	}
+
+	// A missing lock
+	_, err = BreakStaleLck(lckPath, 0)
+	var errNotExist *ErrNotExist
+	if !errors.As(err, &errNotExist) {
+		t.Errorf("got %v of a missing lock; want an ErrNotExist", err)
+	}
+}
+
+func TestBreakStaleLckBusy(t *testing.T) {
+	dir := t.TempDir()
+	lckPath := DefLckPath(filepath.Join(dir, "a.json"))
+	writeTestFile(t, lckPath, deadLckInfo(t))
+
+	// Another breaker at work
+	writeTestFile(t, DefLckPath(lckPath), newLckInfo())
+	_, err := BreakStaleLck(lckPath, 0)
+	var errLcked *ErrLcked
+	if !errors.As(err, &errLcked) {
+		t.Errorf("got %v while another breaker is at work; want an ErrLcked", err)
+	}
+	_, err = os.Stat(lckPath)
+	if err != nil {
+		t.Errorf("broke the lock while another breaker is at work; %v", err)
+	}
+
+	// Another breaker died at work.
+	info := deadLckInfo(t)
+	info.Acquired = time.Now().Add(-2 * brkLckMaxAge)
+	writeTestFile(t, DefLckPath(lckPath), info)
+	broken, err := BreakStaleLck(lckPath, 0)
+	if err != nil || !broken {
+		t.Errorf("got broken %v (%v) after a dead breaker; want broken", broken, err)
+	}
+}
+
+func TestBreakStaleLckCommittedTx(t *testing.T) {
+	dir := t.TempDir()
+	lckPath := DefLckPath(filepath.Join(dir, "a.json"))
+	info := deadLckInfo(t)
+	info.Tx = "1"
+	writeTestFile(t, lckPath, info)
+	writeTestFile(t, journalPath(dir, "1"), &journal{Owner: info, Ops: []journalOp{{Name: "a.json", Rm: true}}})
+
+	broken, err := BreakStaleLck(lckPath, 0)
+	if err == nil || broken {
+		t.Errorf("got broken %v (%v) of a lock of a committed transaction; want an error", broken, err)
+	}
+	_, err = os.Stat(lckPath)
+	if err != nil {
+		t.Errorf("broke a lock of a committed transaction; %v", err)
+	}
+
+	// Nor by a lease
+	broken, err = BreakStaleLck(lckPath, time.Nanosecond)
+	if err == nil || broken {
+		t.Errorf("got broken %v (%v) of a lock of a committed transaction, by a lease; want an error", broken, err)
+	}
+
+	// Nor by a locker
+	_, err = Lck(lckPath)
+	var errLcked *ErrLcked
+	if !errors.As(err, &errLcked) {
+		t.Errorf("got %v of locking a lock of a committed transaction; want an ErrLcked", err)
+	}
+
+	// Once recovered
+	err = os.Remove(journalPath(dir, "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken, err = BreakStaleLck(lckPath, 0)
+	if err != nil || !broken {
+		t.Errorf("got broken %v (%v) of a lock of a recovered transaction; want broken", broken, err)
+	}
+}
+
+func TestLckBreaksStale(t *testing.T) {
+	lckPath := DefLckPath(filepath.Join(t.TempDir(), "a.json"))
+	writeTestFile(t, lckPath, deadLckInfo(t))
+
+	f, err := Lck(lckPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ReadLck(lckPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Pid != os.Getpid() {
+		t.Errorf("got the owner %s; want this process", info)
+	}
+
+	// Not a live one
+	_, err = Lck(lckPath)
+	var errLcked *ErrLcked
+	if !errors.As(err, &errLcked) {
+		t.Errorf("got %v of locking a live lock; want an ErrLcked", err)
+	}
+
+	err = Unlck(lckPath, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,119 @@
+package bin
+
+import (
+	"context"
+	"time"
+)
+
+// Opts holds the optional knobs of the bin operations; a nil *Opts means the defaults.
+type Opts struct {
+	// Wait, if not nil, makes the lock acquisitions wait for a held lock (see LckWait), instead of failing fast with ErrLcked.
+	Wait *WaitOpts
+	// Durability of the writes; see Durability.
+	Durability Durability
+	// FS, if not nil, replaces OsFS.
+	FS FS
+	// NoJournal makes the single writes skip the write ahead journal (see journal); a crash may then leave an orphan temporary file behind, but never a torn binary.
+	// Meant for the bookkeeping files (e.g. indexes) of a directory; their journals would nest a metadata directory in another.
+	NoJournal bool
+	// History is how many prior versions (revisions) of each binary are kept when it's overwritten or removed; zero keeps none (the default), and a negative keeps them all. See Rev.
+	History int
+	// Trash makes the removes move the binaries into the trash of their directory, instead of removing them for good; see TrashEntry.
+	Trash bool
+	// TrashMaxAge, if positive, is how long a trashed binary is kept; older ones are purged on the next remove.
+	TrashMaxAge time.Duration
+}
+
+func (o *Opts) wait() *WaitOpts {
+	if o == nil {
+		return nil
+	}
+
+	return o.Wait
+}
+
+func (o *Opts) durability() Durability {
+	if o == nil {
+		return DurabilityFileDir
+	}
+
+	return o.Durability
+}
+
+func (o *Opts) history() int {
+	if o == nil {
+		return 0
+	}
+
+	return o.History
+}
+
+func (o *Opts) noJournal() bool {
+	if o == nil {
+		return false
+	}
+
+	return o.NoJournal
+}
+
+func (o *Opts) trash() bool {
+	if o == nil {
+		return false
+	}
+
+	return o.Trash
+}
+
+func (o *Opts) trashMaxAge() time.Duration {
+	if o == nil {
+		return 0
+	}
+
+	return o.TrashMaxAge
+}
+
+func (o *Opts) fs() FS {
+	if o == nil || o.FS == nil {
+		return OsFS{}
+	}
+
+	return o.FS
+}
+
+// WaitOpts configures a blocking lock acquisition.
+type WaitOpts struct {
+	// Ctx, if not nil, cancels the waiting.
+	Ctx context.Context
+	// Timeout is the maximum time to wait for the lock; zero means no timeout.
+	Timeout time.Duration
+	// MinBackoff and MaxBackoff bound the (exponentially growing and jittered) pause between tries; zero means defMinBackoff and defMaxBackoff respectively.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+const defMinBackoff = 10 * time.Millisecond
+const defMaxBackoff = time.Second
+
+func (w *WaitOpts) ctx() context.Context {
+	if w.Ctx == nil {
+		return context.Background()
+	}
+
+	return w.Ctx
+}
+
+func (w *WaitOpts) minBackoff() time.Duration {
+	if w.MinBackoff <= 0 {
+		return defMinBackoff
+	}
+
+	return w.MinBackoff
+}
+
+func (w *WaitOpts) maxBackoff() time.Duration {
+	if w.MaxBackoff <= 0 {
+		return defMaxBackoff
+	}
+
+	return w.MaxBackoff
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package bin
+
+import (
+	"errors"
+	"syscall"
+)
+
+// procAlive reports whether a process with the given pid exists on this host.
+func procAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package bin
+
+import "os"
+
+// procAlive reports whether a process with the given pid exists on this host.
+func procAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	_ = p.Release()
+	return true
+}
@@ -0,0 +1,246 @@
+package bin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/multierr"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The readers/writer protocol on top of the (exclusive) lock files:
+//  - A reader acquires the exclusive lock, registers a token file (holding its owner information) in the readers directory of the lock, and releases the exclusive lock right away.
+//    The readers wait for each other (regardless of the options), as they hold the exclusive lock for a few file operations only; see regLck.
+//  - A writer acquires the exclusive lock, and keeps it while waiting for the readers directory to drain; no new reader can register in the meantime.
+// So, as long as a reader holds its token, no writer can change the binary; multiple reads of it see the same content.
+
+// RLckTkn is the token of a held shared (read) lock.
+type RLckTkn struct {
+	path string
+	dir  string
+}
+
+// RLckDir returns the readers directory of the lock file at lckPath.
+func RLckDir(lckPath string) string {
+	return lckPath + ".readers"
+}
+
+func RLck(path string) (*RLckTkn, error) {
+	return RLckOpts(path, nil)
+}
+
+func RLckOpts(path string, o *Opts) (*RLckTkn, error) {
+	return RLckLckPath(path, DefLckPath(path), o)
+}
+
+// RLckLckPath acquires a shared lock on the binary at path, through the lock file at lckPath; waits for the writer (if any) according to o.
+// If the lock file can't be created for the lack of permission (e.g. in a read-only directory, where no writer can be either), the returned token holds nothing.
+func RLckLckPath(path string, lckPath string, o *Opts) (rTkn *RLckTkn, rErr error) {
+	// Early existence check (not vital)
+	err := ErrIfNotExist(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lckFile, err := regLck(lckPath, o.wait())
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return &RLckTkn{}, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		err := Unlck(lckPath, lckFile)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	// Mandatory existence check (no writer from now on)
+	err = ErrIfNotExist(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := RLckDir(lckPath)
+	var f *os.File
+	for {
+		err = os.Mkdir(dir, 0775)
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create readers directory %q; %w", dir, err)
+		}
+
+		f, err = os.CreateTemp(dir, "*.tmp")
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// The last reader just removed the directory on its way out.
+				continue
+			}
+			return nil, fmt.Errorf("failed to create a reader token in directory %q; %w", dir, err)
+		}
+
+		break
+	}
+	tknPath := f.Name()
+
+	err = json.NewEncoder(f).Encode(newLckInfo())
+	err = multierr.Append(err, f.Close())
+	if err != nil {
+		err = fmt.Errorf("failed to write the owner information into reader token %q; %w", tknPath, err)
+		rmErr := os.Remove(tknPath)
+		if rmErr != nil {
+			rmErr = fmt.Errorf("failed to remove reader token %q; %w", tknPath, rmErr)
+		}
+		return nil, multierr.Append(err, rmErr)
+	}
+
+	return &RLckTkn{tknPath, dir}, nil
+}
+
+// regLckTimeout is how long a reader waits for the other readers to register, unless it waits for the writers too; see regLck.
+const regLckTimeout = 30 * time.Second
+
+// regLck acquires the lock file at lckPath for registering a reader; waits for a writer according to w, and for the other registering readers regardless.
+func regLck(lckPath string, w *WaitOpts) (*os.File, error) {
+	info := newLckInfo()
+	info.Reader = true
+
+	var f *os.File
+	var wErr error
+	rw := w
+	if rw == nil {
+		rw = &WaitOpts{Timeout: regLckTimeout}
+	}
+	err := retry(rw, func() error {
+		var err error
+		f, err = lckInfo(lckPath, info)
+		if err != nil && w == nil && isBusy(err) && !regLcked(lckPath) {
+			// Held by a writer; don't wait.
+			wErr = err
+			return nil
+		}
+		return err
+	})
+	if wErr != nil {
+		return nil, wErr
+	}
+
+	return f, err
+}
+
+// regLcked reports whether the lock file at lckPath is held by a registering reader, or may be (i.e. its owner is yet unknown, or it's just released).
+func regLcked(lckPath string) bool {
+	info, err := ReadLck(lckPath)
+	if err != nil {
+		var errNotExist *ErrNotExist
+		return errors.As(err, &errNotExist)
+	}
+
+	return info.Reader || info.Pid <= 0
+}
+
+// Unlck releases the shared lock; the last reader also removes the readers directory.
+func (l *RLckTkn) Unlck() error {
+	if l.path == "" {
+		// Holds nothing; see RLckLckPath.
+		return nil
+	}
+
+	err := os.Remove(l.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove reader token %q; %w", l.path, err)
+	}
+
+	// Fails if other readers are still there; fine.
+	_ = os.Remove(l.dir)
+
+	return nil
+}
+
+// WLck acquires the exclusive (write) lock through the lock file at lckPath, and waits for the current readers to go; both according to o.
+// Tokens of dead readers are removed on the way.
+func WLck(lckPath string, o *Opts) (*os.File, error) {
+	return wlckTx(lckPath, o, "")
+}
+
+func wlckTx(lckPath string, o *Opts, tx string) (*os.File, error) {
+	f, err := lckWaitTx(lckPath, o.wait(), tx)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := RLckDir(lckPath)
+	err = retry(o.wait(), func() error {
+		return drainReaders(dir)
+	})
+	if err != nil {
+		return nil, multierr.Append(err, Unlck(lckPath, f))
+	}
+
+	return f, nil
+}
+
+// drainReaders removes the readers directory if it has no live reader token; returns an ErrRLcked otherwise.
+func drainReaders(dir string) error {
+	es, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else {
+			return fmt.Errorf("failed to read readers directory %q; %w", dir, err)
+		}
+	}
+
+	live := 0
+	for _, e := range es {
+		tknPath := filepath.Join(dir, e.Name())
+		info, err := ReadLck(tknPath)
+		if err != nil {
+			var errNotExist *ErrNotExist
+			if errors.As(err, &errNotExist) {
+				// Just released
+				continue
+			} else {
+				return err
+			}
+		}
+
+		if info.Stale(0) {
+			err := os.Remove(tknPath)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove stale reader token %q; %w", tknPath, err)
+			}
+		} else {
+			live++
+		}
+	}
+
+	if live > 0 {
+		return NewErrRLcked(dir)
+	}
+
+	err = os.Remove(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove readers directory %q; %w", dir, err)
+	}
+
+	return nil
+}
+
+type ErrRLcked string
+
+func (e *ErrRLcked) Error() string {
+	return fmt.Sprintf("File %q is locked by readers", e.Path())
+}
+
+// Path returns the readers directory.
+func (e *ErrRLcked) Path() string {
+	return string(*e)
+}
+
+func NewErrRLcked(path string) *ErrRLcked {
+	e := ErrRLcked(path)
+	return &e
+}
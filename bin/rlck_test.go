@@ -0,0 +1,154 @@
+package bin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestBin(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "x.json")
+	err := New(path, strings.NewReader(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRLckConcurrent(t *testing.T) {
+	path := newTestBin(t)
+
+	for round := 0; round < 5; round++ {
+		const n = 30
+		tkns := make([]*RLckTkn, n)
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tkns[i], errs[i] = RLck(path)
+			}(i)
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("round %d, reader %d: %v", round, i, err)
+			}
+		}
+		for _, tkn := range tkns {
+			err := tkn.Unlck()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err := os.Stat(RLckDir(DefLckPath(path)))
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("readers directory is left behind; %v", err)
+		}
+	}
+}
+
+func TestRLckBlocksWriter(t *testing.T) {
+	path := newTestBin(t)
+
+	tkn, err := RLck(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = WLck(DefLckPath(path), nil)
+	var errRLcked *ErrRLcked
+	if !errors.As(err, &errRLcked) {
+		t.Fatalf("got %v, want an ErrRLcked", err)
+	}
+
+	err = tkn.Unlck()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := WLck(DefLckPath(path), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Unlck(DefLckPath(path), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRLckWriterFailsFast(t *testing.T) {
+	path := newTestBin(t)
+	lckPath := DefLckPath(path)
+
+	f, err := WLck(lckPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Unlck(lckPath, f)
+
+	start := time.Now()
+	_, err = RLck(path)
+	var errLcked *ErrLcked
+	if !errors.As(err, &errLcked) {
+		t.Fatalf("got %v, want an ErrLcked", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("waited %v for a writer, without any wait options", d)
+	}
+}
+
+func TestRLckWaitsForWriter(t *testing.T) {
+	path := newTestBin(t)
+	lckPath := DefLckPath(path)
+
+	f, err := WLck(lckPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = Unlck(lckPath, f)
+	}()
+
+	tkn, err := RLckOpts(path, &Opts{Wait: &WaitOpts{Timeout: 10 * time.Second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tkn.Unlck()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRLckReadOnlyDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions don't apply to root")
+	}
+
+	path := newTestBin(t)
+	dir := filepath.Dir(path)
+	err := os.Chmod(dir, 0555)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0775)
+
+	tkn, err := RLck(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tkn.Unlck()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,98 @@
+package bin
+
+import (
+	"fmt"
+	"os"
+)
+
+// Durability is how hard a write tries to survive a power loss once it's acknowledged.
+type Durability int
+
+const (
+	// DurabilityFileDir syncs the written files, and their directories (so the renames and removes survive too); the default.
+	DurabilityFileDir Durability = iota
+	// DurabilityFile syncs only the written files.
+	DurabilityFile
+	// DurabilityNone leaves it all to the operating system; a crashed single write is just dropped (see journal).
+	DurabilityNone
+)
+
+func ParseDurability(s string) (Durability, error) {
+	switch s {
+	case "file+dir":
+		return DurabilityFileDir, nil
+	case "file":
+		return DurabilityFile, nil
+	case "none":
+		return DurabilityNone, nil
+	default:
+		return 0, fmt.Errorf("unknown durability %q; should be \"none\", \"file\", or \"file+dir\"", s)
+	}
+}
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityFileDir:
+		return "file+dir"
+	case DurabilityFile:
+		return "file"
+	case DurabilityNone:
+		return "none"
+	default:
+		return fmt.Sprintf("Durability(%d)", int(d))
+	}
+}
+
+// FS is the layer of the file system operations, under the bin operations, whose effects can't be observed through the files themselves; replaceable, e.g. to verify them.
+type FS interface {
+	// SyncFile flushes the content of f to the storage.
+	SyncFile(f *os.File) error
+	// SyncDir flushes the entries of directory dir (e.g. a rename within it) to the storage.
+	SyncDir(dir string) error
+}
+
+// OsFS is the FS of the operating system; the default.
+type OsFS struct{}
+
+func (OsFS) SyncFile(f *os.File) error {
+	err := f.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync file %q; %w", f.Name(), err)
+	}
+
+	return nil
+}
+
+func (OsFS) SyncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %q; %w", dir, err)
+	}
+
+	err = f.Sync()
+	closeErr := f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to sync directory %q; %w", dir, err)
+	} else if closeErr != nil {
+		return fmt.Errorf("failed to close directory %q; %w", dir, closeErr)
+	}
+
+	return nil
+}
+
+func (o *Opts) syncFile(f *os.File) error {
+	d := o.durability()
+	if d != DurabilityFileDir && d != DurabilityFile {
+		return nil
+	}
+
+	return o.fs().SyncFile(f)
+}
+
+func (o *Opts) syncDir(dir string) error {
+	if o.durability() != DurabilityFileDir {
+		return nil
+	}
+
+	return o.fs().SyncDir(dir)
+}
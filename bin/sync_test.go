@@ -0,0 +1,105 @@
+package bin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recFS is an FS which records the syncs, instead of doing them.
+type recFS struct {
+	mu    sync.Mutex
+	files []string
+	dirs  []string
+}
+
+func (fs *recFS) SyncFile(f *os.File) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.files = append(fs.files, f.Name())
+	return nil
+}
+
+func (fs *recFS) SyncDir(dir string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.dirs = append(fs.dirs, filepath.Clean(dir))
+	return nil
+}
+
+func TestSync(t *testing.T) {
+	ops := []struct {
+		name string
+		// Whether it writes the content of a file (and not just removes one)
+		writes bool
+		// Runs the op on x.json of directory d (which exists, if exists)
+		run    func(d string, o *Opts) error
+		exists bool
+	}{
+		{"new", true, func(d string, o *Opts) error {
+			return NewOpts(filepath.Join(d, "x.json"), strings.NewReader(`{"a":1}`), o)
+		}, false},
+		{"over", true, func(d string, o *Opts) error {
+			return OverOpts(filepath.Join(d, "x.json"), strings.NewReader(`{"a":2}`), o)
+		}, true},
+		{"rm", false, func(d string, o *Opts) error {
+			return RmOpts(filepath.Join(d, "x.json"), o)
+		}, true},
+		{"tx", true, func(d string, o *Opts) error {
+			tx := NewDirOpts(d, o).Begin()
+			err := tx.Over("x.json", strings.NewReader(`{"a":2}`))
+			if err != nil {
+				return err
+			}
+			err = tx.New("y.json", strings.NewReader(`{"b":1}`))
+			if err != nil {
+				return err
+			}
+			return tx.Commit()
+		}, true},
+	}
+
+	for _, op := range ops {
+		for _, dur := range []Durability{DurabilityNone, DurabilityFile, DurabilityFileDir} {
+			t.Run(op.name+"/"+dur.String(), func(t *testing.T) {
+				d := t.TempDir()
+				if op.exists {
+					err := New(filepath.Join(d, "x.json"), strings.NewReader(`{"a":1}`))
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				fs := &recFS{}
+				err := op.run(d, &Opts{Durability: dur, FS: fs})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				wantFiles := op.writes && dur != DurabilityNone
+				if wantFiles != (len(fs.files) > 0) {
+					t.Errorf("synced files %q; want some: %v", fs.files, wantFiles)
+				}
+				for _, f := range fs.files {
+					if !strings.HasSuffix(f, ".tmp") {
+						t.Errorf("synced %q; want only the temporary files", f)
+					}
+				}
+
+				wantDir := dur == DurabilityFileDir
+				syncedDir := false
+				for _, sd := range fs.dirs {
+					syncedDir = syncedDir || sd == filepath.Clean(d)
+				}
+				if wantDir != syncedDir {
+					t.Errorf("synced directories %q; want %q among them: %v", fs.dirs, d, wantDir)
+				}
+				if !wantDir && len(fs.dirs) > 0 {
+					t.Errorf("synced directories %q; want none", fs.dirs)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package bin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/multierr"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// TrashEntry is a binary removed into the trash of its directory; see Opts.Trash.
+type TrashEntry struct {
+	Id      string    `json:"-"`
+	Name    string    `json:"name"`
+	Deleted time.Time `json:"deleted"`
+	// Pid and Host identify the process which removed the binary.
+	Pid  int    `json:"pid"`
+	Host string `json:"host"`
+}
+
+// Each trash entry is a directory (named after its id), holding the removed binary and a file of its TrashEntry; an entry without the binary is incomplete (e.g. its remover crashed, or it's just restored), and is ignored.
+const trashDirName = "trash"
+const trashEntryFileName = ".entry.json"
+
+func trashDir(dir string) string {
+	return filepath.Join(dir, MetaDirName, trashDirName)
+}
+
+// rmBin removes the binary at path, keeping it in the history and (or) the trash if asked so; doesn't sync the directory.
+func rmBin(path string, o *Opts) error {
+	err := saveRev(path, o)
+	if err != nil {
+		return err
+	}
+
+	if o.trash() {
+		return trashBin(path, o)
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return NewErrNotExist(path)
+		} else {
+			return fmt.Errorf("failed to remove %q; %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// trashBin moves the binary at path into the trash.
+func trashBin(path string, o *Opts) error {
+	dir, name := filepath.Split(path)
+	e := &TrashEntry{Id: genId(), Name: name, Deleted: time.Now(), Pid: os.Getpid(), Host: hostname()}
+	eDir := filepath.Join(trashDir(dir), e.Id)
+	err := os.MkdirAll(eDir, 0775)
+	if err != nil {
+		return fmt.Errorf("failed to create directory %q (or one of its parents); %w", eDir, err)
+	}
+
+	err = writeTrashEntry(eDir, e, o)
+	if err != nil {
+		return multierr.Append(err, rmTrashEntry(eDir))
+	}
+
+	tPath := filepath.Join(eDir, name)
+	err = os.Rename(path, tPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = NewErrNotExist(path)
+		} else {
+			err = fmt.Errorf("failed to rename (move) %q to %q; %w", path, tPath, err)
+		}
+		return multierr.Append(err, rmTrashEntry(eDir))
+	}
+
+	return o.syncDir(eDir)
+}
+
+// autoPurgeTrash purges the old entries of the trash of dir, if asked so; see Opts.TrashMaxAge.
+func autoPurgeTrash(dir string, o *Opts) error {
+	maxAge := o.trashMaxAge()
+	if !o.trash() || maxAge <= 0 {
+		return nil
+	}
+
+	return PurgeTrash(dir, maxAge)
+}
+
+func writeTrashEntry(eDir string, e *TrashEntry, o *Opts) (rErr error) {
+	ePath := filepath.Join(eDir, trashEntryFileName)
+	f, err := os.OpenFile(ePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+	if err != nil {
+		return fmt.Errorf("failed to create trash entry %q; %w", ePath, err)
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close trash entry %q; %w", ePath, err))
+		}
+	}()
+
+	err = json.NewEncoder(f).Encode(e)
+	if err != nil {
+		return fmt.Errorf("failed to write trash entry %q; %w", ePath, err)
+	}
+
+	return o.syncFile(f)
+}
+
+// readTrashEntry reads the entry at eDir; returns nil (and no error) if it's incomplete.
+func readTrashEntry(eDir string) (*TrashEntry, error) {
+	ePath := filepath.Join(eDir, trashEntryFileName)
+	b, err := os.ReadFile(ePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		} else {
+			return nil, fmt.Errorf("failed to read trash entry %q; %w", ePath, err)
+		}
+	}
+
+	e := &TrashEntry{}
+	err = json.Unmarshal(b, e)
+	if err != nil || e.Name == "" {
+		return nil, nil
+	}
+	e.Id = filepath.Base(eDir)
+
+	ex, err := exists(filepath.Join(eDir, e.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if %q exists or not; %w", filepath.Join(eDir, e.Name), err)
+	}
+	if !ex {
+		return nil, nil
+	}
+
+	return e, nil
+}
+
+func rmTrashEntry(eDir string) error {
+	err := os.RemoveAll(eDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove trash entry %q; %w", eDir, err)
+	}
+
+	return nil
+}
+
+// Trashed returns the entries of the trash of dir, oldest first.
+func Trashed(dir string) ([]*TrashEntry, error) {
+	tDir := trashDir(dir)
+	ds, err := os.ReadDir(tDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []*TrashEntry{}, nil
+		} else {
+			return nil, fmt.Errorf("failed to read directory entries of %q; %w", tDir, err)
+		}
+	}
+
+	es := make([]*TrashEntry, 0, len(ds))
+	var rErr error
+	for _, d := range ds {
+		if !d.IsDir() {
+			continue
+		}
+
+		e, err := readTrashEntry(filepath.Join(tDir, d.Name()))
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		} else if e != nil {
+			es = append(es, e)
+		}
+	}
+
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].Deleted.Before(es[j].Deleted)
+	})
+
+	return es, rErr
+}
+
+// Untrash moves the binary of trash entry id (of dir) back to its place; fails with an ErrExists if another binary has taken its name since.
+func Untrash(dir string, id string, o *Opts) (rErr error) {
+	eDir := filepath.Join(trashDir(dir), id)
+	e, err := readTrashEntry(eDir)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return NewErrNotExist(eDir)
+	}
+
+	path := filepath.Join(dir, e.Name)
+	lckPath := DefLckPath(path)
+	lckFile, err := WLck(lckPath, o)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := Unlck(lckPath, lckFile)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	err = ErrIfExists(path)
+	if err != nil {
+		return err
+	}
+
+	tPath := filepath.Join(eDir, e.Name)
+	err = os.Rename(tPath, path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Just restored, or purged
+			return NewErrNotExist(eDir)
+		} else {
+			return fmt.Errorf("failed to rename (move) %q to %q; %w", tPath, path, err)
+		}
+	}
+
+	err = o.syncDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	// Now incomplete; no harm if it's left behind.
+	return rmTrashEntry(eDir)
+}
+
+// Purge permanently removes trash entry id of dir.
+func Purge(dir string, id string) error {
+	eDir := filepath.Join(trashDir(dir), id)
+	ex, err := exists(eDir)
+	if err != nil {
+		return fmt.Errorf("failed to check if %q exists or not; %w", eDir, err)
+	}
+	if !ex {
+		return NewErrNotExist(eDir)
+	}
+
+	return rmTrashEntry(eDir)
+}
+
+// PurgeTrash permanently removes the entries of the trash of dir which are older than maxAge (all of them, if it's not positive), along with the incomplete ones.
+func PurgeTrash(dir string, maxAge time.Duration) error {
+	tDir := trashDir(dir)
+	ds, err := os.ReadDir(tDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else {
+			return fmt.Errorf("failed to read directory entries of %q; %w", tDir, err)
+		}
+	}
+
+	var rErr error
+	for _, d := range ds {
+		eDir := filepath.Join(tDir, d.Name())
+		e, err := readTrashEntry(eDir)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+			continue
+		}
+
+		if e != nil && maxAge > 0 && time.Since(e.Deleted) <= maxAge {
+			continue
+		}
+
+		if e == nil {
+			// Incomplete; may be just being created.
+			fi, err := d.Info()
+			if err != nil || time.Since(fi.ModTime()) <= orphanTmpMaxAge {
+				continue
+			}
+		}
+
+		rErr = multierr.Append(rErr, rmTrashEntry(eDir))
+	}
+
+	return rErr
+}
+
+func (d *Dir) Trashed() ([]*TrashEntry, error) {
+	return Trashed(d.Dir())
+}
+
+func (d *Dir) Untrash(id string) error {
+	return Untrash(d.Dir(), id, d.opts)
+}
+
+func (d *Dir) Purge(id string) error {
+	return Purge(d.Dir(), id)
+}
+
+func (d *Dir) PurgeTrash(maxAge time.Duration) error {
+	return PurgeTrash(d.Dir(), maxAge)
+}
@@ -0,0 +1,191 @@
+package bin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trashedNames returns the names of the trash entries of d, oldest first.
+func trashedNames(t *testing.T, d *Dir) []string {
+	t.Helper()
+	es, err := d.Trashed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns := make([]string, 0, len(es))
+	for _, e := range es {
+		ns = append(ns, e.Name)
+	}
+
+	return ns
+}
+
+// ageTrashEntry moves the time trash entry id of d is removed at back by age.
+func ageTrashEntry(t *testing.T, d *Dir, id string, age time.Duration) {
+	t.Helper()
+	eDir := filepath.Join(trashDir(d.Dir()), id)
+	e, err := readTrashEntry(eDir)
+	if err != nil || e == nil {
+		t.Fatalf("got %v (%v) of trash entry %q", e, err, id)
+	}
+
+	e.Deleted = e.Deleted.Add(-age)
+	writeTestFile(t, filepath.Join(eDir, trashEntryFileName), e)
+}
+
+func TestTrash(t *testing.T) {
+	d := newTxTestDir(t)
+	d = NewDirOpts(d.Dir(), &Opts{Trash: true})
+	err := d.Rm("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"b.json": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the remove; want %q", got, want)
+	}
+
+	es, err := d.Trashed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 || es[0].Name != "a.json" || es[0].Pid != os.Getpid() || es[0].Id == "" {
+		t.Fatalf("got %+v; want an entry of a.json", es)
+	}
+	id := es[0].Id
+
+	// Another binary has taken its name.
+	err = d.New("a.json", strings.NewReader("a2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errExists *ErrExists
+	err = d.Untrash(id)
+	if !errors.As(err, &errExists) {
+		t.Errorf("got %v of restoring over a binary; want an ErrExists", err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a2", "b.json": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the failed restore; want %q", got, want)
+	}
+
+	err = d.Rm("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := trashedNames(t, d), []string{"a.json", "a.json"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	err = d.Untrash(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a", "b.json": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the restore; want %q", got, want)
+	}
+
+	var errNotExist *ErrNotExist
+	err = d.Untrash(id)
+	if !errors.As(err, &errNotExist) {
+		t.Errorf("got %v of restoring a restored entry; want an ErrNotExist", err)
+	}
+
+	es, err = d.Trashed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 {
+		t.Fatalf("got %+v; want an entry", es)
+	}
+	err = d.Purge(es[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Purge(es[0].Id)
+	if !errors.As(err, &errNotExist) {
+		t.Errorf("got %v of purging a purged entry; want an ErrNotExist", err)
+	}
+	if got := trashedNames(t, d); len(got) != 0 {
+		t.Errorf("got %q after the purge; want none", got)
+	}
+	errIfLeftBehinds(t, d)
+}
+
+func TestPurgeTrash(t *testing.T) {
+	d := newTxTestDir(t)
+	d = NewDirOpts(d.Dir(), &Opts{Trash: true})
+	for _, n := range []string{"a.json", "b.json"} {
+		err := d.Rm(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	es, err := d.Trashed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ageTrashEntry(t, d, es[0].Id, time.Hour)
+
+	// Incomplete ones; an old and a fresh one.
+	tDir := trashDir(d.Dir())
+	writeTestFile(t, filepath.Join(tDir, "old", trashEntryFileName), &TrashEntry{Name: "c.json"})
+	writeTestFile(t, filepath.Join(tDir, "fresh", trashEntryFileName), &TrashEntry{Name: "c.json"})
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(filepath.Join(tDir, "old"), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.PurgeTrash(30 * time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := trashedNames(t, d), []string{"b.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the purge; want %q", got, want)
+	}
+	for n, want := range map[string]bool{"old": false, "fresh": true} {
+		ex, err := exists(filepath.Join(tDir, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ex != want {
+			t.Errorf("incomplete entry %q exists: %v; want %v", n, ex, want)
+		}
+	}
+
+	err = d.PurgeTrash(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := trashedNames(t, d); len(got) != 0 {
+		t.Errorf("got %q after purging all; want none", got)
+	}
+}
+
+func TestTrashMaxAge(t *testing.T) {
+	d := newTxTestDir(t)
+	d = NewDirOpts(d.Dir(), &Opts{Trash: true, TrashMaxAge: 30 * time.Minute})
+	err := d.Rm("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := d.Trashed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ageTrashEntry(t, d, es[0].Id, time.Hour)
+
+	// The next remove purges the old ones.
+	err = d.Rm("b.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := trashedNames(t, d), []string{"b.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the remove; want %q", got, want)
+	}
+}
@@ -0,0 +1,295 @@
+package bin
+
+import (
+	cryptoRand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/multierr"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Tx is an all-or-nothing batch of creates, overwrites, and removes on the binaries of a Dir.
+//
+// Each touched binary is locked (exclusively) on its first touch, and stays locked until the end of the transaction; its new content is staged in a temporary file beside it.
+// Commit writes a journal of the staged changes into the metadata directory (the commit point), applies them, and removes the journal.
+// If the process dies halfway through applying, Dir.Recover rolls the journal forward; see journal.
+type Tx struct {
+	d     *Dir
+	id    string
+	ops   map[string]*txOp
+	order []string
+	done  bool
+}
+
+type txOp struct {
+	lckFile *os.File
+	existed bool
+	tmp     string // Staged content; empty if none.
+	rm      bool
+}
+
+// exists reports whether the binary exists from the transaction's point of view.
+func (op *txOp) exists() bool {
+	if op.tmp != "" {
+		return true
+	} else if op.rm {
+		return false
+	} else {
+		return op.existed
+	}
+}
+
+// Begin starts a transaction.
+func (d *Dir) Begin() *Tx {
+	return &Tx{d: d, id: genId(), ops: make(map[string]*txOp)}
+}
+
+func (t *Tx) Id() string {
+	return t.id
+}
+
+func (t *Tx) Dir() *Dir {
+	return t.d
+}
+
+// Names returns the names of the binaries touched by the transaction, in the order of their first touch.
+func (t *Tx) Names() []string {
+	return t.order
+}
+
+// New stages the creation of the named binary.
+func (t *Tx) New(name string, b io.Reader) error {
+	op, err := t.op(name)
+	if err != nil {
+		return err
+	}
+
+	if op.exists() {
+		return NewErrExists(t.d.Path(name))
+	}
+
+	return t.stage(name, op, b)
+}
+
+// Over stages the overwrite of the named binary.
+func (t *Tx) Over(name string, b io.Reader) error {
+	op, err := t.op(name)
+	if err != nil {
+		return err
+	}
+
+	if !op.exists() {
+		return NewErrNotExist(t.d.Path(name))
+	}
+
+	return t.stage(name, op, b)
+}
+
+// Rm stages the removal of the named binary.
+func (t *Tx) Rm(name string) error {
+	op, err := t.op(name)
+	if err != nil {
+		return err
+	}
+
+	if !op.exists() {
+		return NewErrNotExist(t.d.Path(name))
+	}
+
+	err = t.unstage(op)
+	op.rm = true
+
+	return err
+}
+
+// Open opens the named binary as seen by the transaction (i.e. with the staged changes).
+func (t *Tx) Open(name string) (*os.File, error) {
+	op, err := t.op(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !op.exists() {
+		return nil, NewErrNotExist(t.d.Path(name))
+	}
+
+	if op.tmp != "" {
+		return Open(op.tmp)
+	} else {
+		return Open(t.d.Path(name))
+	}
+}
+
+// op returns the operation of the named binary; locks the binary on its first touch.
+func (t *Tx) op(name string) (*txOp, error) {
+	if t.done {
+		return nil, fmt.Errorf("transaction %q is already finished", t.id)
+	}
+
+	if op, ok := t.ops[name]; ok {
+		return op, nil
+	}
+
+	path := t.d.Path(name)
+	lckFile, err := wlckTx(DefLckPath(path), t.d.opts, t.id)
+	if err != nil {
+		return nil, err
+	}
+
+	ex, err := exists(path)
+	if err != nil {
+		err = fmt.Errorf("failed to check if %q exists or not; %w", path, err)
+		return nil, multierr.Append(err, Unlck(DefLckPath(path), lckFile))
+	}
+
+	op := &txOp{lckFile: lckFile, existed: ex}
+	t.ops[name] = op
+	t.order = append(t.order, name)
+
+	return op, nil
+}
+
+func (t *Tx) stage(name string, op *txOp, b io.Reader) error {
+	tmpFile, err := openTmp(t.d.Dir(), "."+name+"-*.tmp")
+	if err != nil {
+		if tmpFile != nil {
+			err = multierr.Append(err, rmTmp(tmpFile))
+		}
+		return err
+	}
+	tmpPath := tmpFile.Name()
+
+	_, err = io.Copy(tmpFile, b)
+	if err != nil {
+		err = fmt.Errorf("failed to read from the given source, or write to temporary file %q; %w", tmpPath, err)
+		return multierr.Append(err, rmTmp(tmpFile))
+	}
+
+	err = t.d.opts.syncFile(tmpFile)
+	if err != nil {
+		return multierr.Append(err, rmTmp(tmpFile))
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		err = fmt.Errorf("failed to close temporary file %q; %w", tmpPath, err)
+		return multierr.Append(err, rmTmp(tmpFile))
+	}
+
+	err = t.unstage(op)
+	op.tmp = tmpPath
+	op.rm = false
+
+	return err
+}
+
+// unstage drops the staged content of op, if any.
+func (t *Tx) unstage(op *txOp) error {
+	if op.tmp == "" {
+		return nil
+	}
+
+	tmpPath := op.tmp
+	op.tmp = ""
+	err := os.Remove(tmpPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove temporary file %q; %w", tmpPath, err)
+	}
+
+	return nil
+}
+
+// Commit applies all the staged changes, or none of them.
+func (t *Tx) Commit() (rErr error) {
+	if t.done {
+		return fmt.Errorf("transaction %q is already finished", t.id)
+	}
+
+	j := &journal{Owner: newLckInfo()}
+	j.Owner.Tx = t.id
+	for _, name := range t.order {
+		op := t.ops[name]
+		if op.tmp != "" {
+			j.Ops = append(j.Ops, journalOp{Name: name, Tmp: filepath.Base(op.tmp)})
+		} else if op.rm && op.existed {
+			j.Ops = append(j.Ops, journalOp{Name: name, Rm: true})
+		}
+	}
+
+	if len(j.Ops) == 0 {
+		return t.Rollback()
+	}
+
+	jPath, err := writeJournal(t.d.Dir(), t.id, j, t.d.opts)
+	if err != nil {
+		return multierr.Append(err, t.Rollback())
+	}
+
+	// Committed; from now on, it's roll forward only.
+	t.done = true
+
+	err = applyJournal(t.d.Dir(), j, t.d.opts)
+	if err != nil {
+		// Keep the journal and the locks for the recovery.
+		return fmt.Errorf("failed to apply committed transaction %q; it'll be rolled forward by the next recovery; %w", t.id, err)
+	}
+
+	err = os.Remove(jPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove the journal %q of applied transaction %q; %w", jPath, t.id, err)
+	}
+
+	err = t.unlckAll()
+	if err != nil {
+		return err
+	}
+
+	return autoPurgeTrash(t.d.Dir(), t.d.opts)
+}
+
+// Rollback drops all the staged changes.
+func (t *Tx) Rollback() error {
+	if t.done {
+		return fmt.Errorf("transaction %q is already finished", t.id)
+	}
+	t.done = true
+
+	var rErr error
+	for _, name := range t.order {
+		rErr = multierr.Append(rErr, t.unstage(t.ops[name]))
+	}
+
+	return multierr.Append(rErr, t.unlckAll())
+}
+
+func (t *Tx) unlckAll() error {
+	var rErr error
+	for _, name := range t.order {
+		rErr = multierr.Append(rErr, Unlck(DefLckPath(t.d.Path(name)), t.ops[name].lckFile))
+	}
+
+	return rErr
+}
+
+func rmTmp(f *os.File) error {
+	_ = f.Close()
+	err := os.Remove(f.Name())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove temporary file %q; %w", f.Name(), err)
+	}
+
+	return nil
+}
+
+func genId() string {
+	b := make([]byte, 8)
+	_, err := cryptoRand.Read(b)
+	if err != nil {
+		panic(fmt.Errorf("cryptographic random number generator failed; %w", err))
+	}
+
+	return hex.EncodeToString(b)
+}
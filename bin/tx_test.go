@@ -0,0 +1,320 @@
+package bin
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTxTestDir returns a directory of a.json and b.json.
+func newTxTestDir(t *testing.T) *Dir {
+	t.Helper()
+	d := NewDir(t.TempDir())
+	for n, s := range map[string]string{"a.json": "a", "b.json": "b"} {
+		err := d.New(n, strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return d
+}
+
+// contents returns the binaries of d, and their contents.
+func contents(t *testing.T, d *Dir) map[string]string {
+	t.Helper()
+	ns, err := d.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := make(map[string]string)
+	for _, n := range ns {
+		b, err := os.ReadFile(d.Path(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c[n] = string(b)
+	}
+
+	return c
+}
+
+// errIfLeftBehinds returns an error if a temporary file, a lock, or a journal is left in d.
+func errIfLeftBehinds(t *testing.T, d *Dir) {
+	t.Helper()
+	es, err := os.ReadDir(d.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range es {
+		if n := e.Name(); strings.HasSuffix(n, ".tmp") || strings.HasSuffix(n, ".readers") {
+			t.Errorf("%q is left behind", n)
+		}
+	}
+
+	es, err = os.ReadDir(journalDir(d.Dir()))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	for _, e := range es {
+		t.Errorf("journal %q is left behind", e.Name())
+	}
+}
+
+// stageTestTx stages an overwrite of a.json, a remove of b.json, and a create of c.json, on d.
+func stageTestTx(t *testing.T, d *Dir) *Tx {
+	t.Helper()
+	tx := d.Begin()
+	err := tx.Over("a.json", strings.NewReader("a2"))
+	if err == nil {
+		err = tx.Rm("b.json")
+	}
+	if err == nil {
+		err = tx.New("c.json", strings.NewReader("c"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+func TestTxCommit(t *testing.T) {
+	d := newTxTestDir(t)
+	tx := stageTestTx(t, d)
+
+	// Isolated until the commit
+	f, err := tx.Open("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil || string(b) != "a2" {
+		t.Errorf("got %q (%v) within the transaction; want the staged content", b, err)
+	}
+	_, err = tx.Open("b.json")
+	var errNotExist *ErrNotExist
+	if !errors.As(err, &errNotExist) {
+		t.Errorf("got %v of a removed binary within the transaction; want an ErrNotExist", err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a", "b.json": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q before the commit; want %q", got, want)
+	}
+
+	// Locked until the end
+	err = d.Over("a.json", strings.NewReader("x"))
+	var errLcked *ErrLcked
+	if !errors.As(err, &errLcked) {
+		t.Errorf("got %v of a write on a binary of a transaction; want an ErrLcked", err)
+	}
+	err = d.Begin().New("c.json", strings.NewReader("x"))
+	if !errors.As(err, &errLcked) {
+		t.Errorf("got %v of another transaction on a binary of a transaction; want an ErrLcked", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a2", "c.json": "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the commit; want %q", got, want)
+	}
+	errIfLeftBehinds(t, d)
+
+	// Finished
+	err = tx.Over("a.json", strings.NewReader("x"))
+	if err == nil {
+		t.Errorf("staged on a committed transaction")
+	}
+	err = tx.Rollback()
+	if err == nil {
+		t.Errorf("rolled back a committed transaction")
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	d := newTxTestDir(t)
+	tx := stageTestTx(t, d)
+
+	err := tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a", "b.json": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the rollback; want %q", got, want)
+	}
+	errIfLeftBehinds(t, d)
+
+	err = tx.Commit()
+	if err == nil {
+		t.Errorf("committed a rolled back transaction")
+	}
+
+	// Unlocked
+	err = d.Over("a.json", strings.NewReader("a3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxStagedOps(t *testing.T) {
+	d := newTxTestDir(t)
+	tx := d.Begin()
+
+	var errExists *ErrExists
+	var errNotExist *ErrNotExist
+	for _, c := range []struct {
+		op   string
+		name string
+		// The error the op should fail with, if any
+		want interface{}
+	}{
+		{"new", "a.json", &errExists},
+		{"over", "c.json", &errNotExist},
+		{"rm", "c.json", &errNotExist},
+		// Against the staged ones
+		{"rm", "a.json", nil},
+		{"over", "a.json", &errNotExist},
+		{"rm", "a.json", &errNotExist},
+		{"new", "a.json", nil},
+		{"new", "a.json", &errExists},
+		{"over", "a.json", nil},
+		{"new", "c.json", nil},
+		{"over", "c.json", nil},
+		{"rm", "c.json", nil},
+		{"over", "b.json", nil},
+		{"over", "b.json", nil},
+	} {
+		var err error
+		switch c.op {
+		case "new":
+			err = tx.New(c.name, strings.NewReader(c.op+" "+c.name))
+		case "over":
+			err = tx.Over(c.name, strings.NewReader(c.op+" "+c.name))
+		case "rm":
+			err = tx.Rm(c.name)
+		}
+
+		if c.want == nil {
+			if err != nil {
+				t.Fatalf("%s %s: %v", c.op, c.name, err)
+			}
+		} else if !errors.As(err, c.want) {
+			t.Fatalf("%s %s: got %v; want a %T", c.op, c.name, err, c.want)
+		}
+	}
+
+	if got, want := tx.Names(), []string{"a.json", "c.json", "b.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got the names %q; want %q", got, want)
+	}
+
+	// A single staged content per binary; the last one.
+	tmps := 0
+	es, err := os.ReadDir(d.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range es {
+		if binTmpRegex.MatchString(e.Name()) {
+			tmps++
+		}
+	}
+	if tmps != 2 {
+		t.Errorf("got %d staged contents; want 2", tmps)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "over a.json", "b.json": "over b.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the commit; want %q", got, want)
+	}
+	errIfLeftBehinds(t, d)
+}
+
+// crashTestTx simulates the death of the owner of tx, right before or after (if committed) writing its journal; see Tx.Commit.
+func crashTestTx(t *testing.T, tx *Tx, committed bool) {
+	t.Helper()
+	dead := deadLckInfo(t)
+	dead.Tx = tx.id
+	tx.done = true
+
+	if committed {
+		j := &journal{Owner: dead}
+		for _, name := range tx.order {
+			op := tx.ops[name]
+			if op.tmp != "" {
+				j.Ops = append(j.Ops, journalOp{Name: name, Tmp: filepath.Base(op.tmp)})
+			} else if op.rm && op.existed {
+				j.Ops = append(j.Ops, journalOp{Name: name, Rm: true})
+			}
+		}
+
+		_, err := writeJournal(tx.d.Dir(), tx.id, j, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range tx.order {
+		_ = tx.ops[name].lckFile.Close()
+		writeTestFile(t, DefLckPath(tx.d.Path(name)), dead)
+	}
+}
+
+func TestTxCommitPoint(t *testing.T) {
+	// Not committed; dropped.
+	d := newTxTestDir(t)
+	crashTestTx(t, stageTestTx(t, d), false)
+
+	err := d.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a", "b.json": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the recovery of an uncommitted transaction; want %q", got, want)
+	}
+	es, err := os.ReadDir(d.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range es {
+		if binTmpRegex.MatchString(e.Name()) {
+			t.Errorf("staged content %q is left behind", e.Name())
+		}
+	}
+	// The stale locks are broken by the next writers.
+	for _, n := range []string{"a.json", "b.json"} {
+		err = d.Over(n, strings.NewReader("x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Committed; rolled forward.
+	d = newTxTestDir(t)
+	crashTestTx(t, stageTestTx(t, d), true)
+
+	// Its locks can't be broken before the recovery.
+	err = d.Over("a.json", strings.NewReader("x"))
+	var errLcked *ErrLcked
+	if !errors.As(err, &errLcked) {
+		t.Errorf("got %v of a write on a binary of a committed transaction; want an ErrLcked", err)
+	}
+
+	err = d.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contents(t, d), map[string]string{"a.json": "a2", "c.json": "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the recovery of a committed transaction; want %q", got, want)
+	}
+	errIfLeftBehinds(t, d)
+}
@@ -2,17 +2,24 @@ package main
 
 import (
 	"encoding/json"
+	stdErrors "errors"
 	"fmt"
+	"github.com/agcom/dirb/bin"
 	"github.com/agcom/dirb/jsn"
 	"io"
 	"os"
-	"reflect"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var dirr *dir
 var pretty = false
+var printETag = false
+var ifMatch = ""
 
 // Usage: dirb command
 func cmd() {
@@ -21,99 +28,51 @@ func cmd() {
 	} else {
 		pArg0 := remArgs[0]
 		remArgs = remArgs[1:]
-		switch pArg0 {
-		case "init":
-			cmdInit()
-		case "create", "new", "add":
-			cmdNew()
-		case "get", "read":
-			cmdGet()
-		case "update", "up", "patch", "pch":
-			cmdUp()
-		case "overwrite", "ow", "replace", "over":
-			cmdOver()
-		case "remove", "rm", "delete":
-			cmdRm()
-		case "help":
-			cmdHelp()
-		case "grep", "search":
-			cmdGrep()
-		case "ls", "list":
-			cmdLs()
-		case "find":
-			cmdFind()
-		case "join":
-			cmdJoin()
-		case "usage", "usg":
-			cmdUsg()
-		default:
+		c := lookupCmd(pArg0)
+		if c == nil {
 			cmdUnk(pArg0)
 		}
+		if len(c.subs) > 0 {
+			c = c.popSub()
+		}
+
+		var ok bool
+		cmdFlags, ok = c.parseFlags()
+		if !ok {
+			os.Exit(2)
+		}
+
+		c.run()
 	}
 }
 
+// Usage: dirb usage command
 func cmdUsg() {
 	if !checkUsg() {
 		os.Exit(2)
 	}
 
-	cmd := remArgs[0]
+	name := remArgs[0]
 	remArgs = remArgs[1:]
 
-	usgs := ""
-
-	switch cmd {
-	case "init":
-		usgs = "dirb init [-d path]"
-	case "create", "new", "add":
-		usgs = "dirb create json [-d path]"
-	case "get", "read":
-		usgs = "dirb read name [-d path] [-p [bool]]"
-	case "update", "up", "patch", "pch":
-		usgs = "dirb update name json [-d path]"
-	case "overwrite", "ow", "replace", "over":
-		usgs = "dirb overwrite name json [-d path]"
-	case "remove", "rm", "delete":
-		usgs = "dirb rm name [-d path]"
-	case "help":
-		cmdHelp()
-	case "grep", "search":
-		cmdGrep()
-	case "ls", "list":
-		usgs = "dirb ls [-d path]"
-	case "find":
-		usgs = "dirb find l op r [-l [bool]] [-r [bool]] [-d path]"
-	case "join":
-		cmdUsg()
-	case "usage", "usg":
-		usgs = "dirb usage command"
-	default:
-		cmdUnk(cmd)
+	c := lookupCmd(name)
+	if c == nil {
+		cmdUnk(name)
 	}
 
-	fmt.Printf("Usage: %s\n", usgs)
+	fmt.Printf("Usage: %s\n", strings.ReplaceAll(c.usage(), "\n", "\n       "))
 }
 
 func checkUsg() bool {
 	fail := false
 
 	// Check args
-	err := errIfNotAtMostRemArgs(1)
+	err := errIfNotExactRemArgs(1)
 	if err != nil {
 		fail = true
 		errorr(err)
 	}
 
-	// Check flags
-
-	for _, f := range flags {
-		switch f.Name {
-		default:
-			fail = true
-			errorf("unexpected flag %q", f.Name)
-		}
-	}
-
 	return !fail
 }
 
@@ -134,7 +93,9 @@ func errIfNotAtMostRemArgs(i int) error {
 	return nil
 }
 
-// Usage: dirb init [-d path]
+// Usage: dirb init [--ext ext] [--names strategy] [-s durability] [--schema json] [-d path]
+// Writes the manifest of the directory (see jsn.Manifest); the given settings, and the defaults for the ones missing (in a new manifest).
+// With --schema, sets the json schema of the instances (or the standard input, if it's "-"; or removes it, if it's null).
 func cmdInit() {
 	if !checkInit() {
 		os.Exit(2)
@@ -143,10 +104,71 @@ func cmdInit() {
 	d := dirr.binDir().Dir()
 	err := os.MkdirAll(d, 0775)
 	if err != nil {
-		errorf("failed to create directory %q (or one of its parents); %v", d, err)
+		fatalf("failed to create directory %q (or one of its parents); %v", d, err)
+	}
+	recoverDir()
+
+	var s interface{}
+	if initSchema != "" {
+		if initSchema == "-" {
+			// Read from stdin
+			s, err = jsn.ReaderToJsn(os.Stdin)
+		} else {
+			s, err = jsn.StrToJsn(initSchema)
+		}
+		if err == nil && s != nil {
+			_, err = jsn.NewSchema(s)
+		}
+		if err != nil {
+			fatalMultiErr(err)
+		}
+	}
+
+	err = dirr.updateManifest(func(m *jsn.Manifest) error {
+		m.Version = jsn.ManifestVersion
+
+		if initExt != "" && initExt != dirr.ext() {
+			// Would orphan the existing instances
+			ns, err := dirr.binDir().All()
+			if err != nil {
+				return err
+			}
+			if len(ns) != 0 {
+				return fmt.Errorf("can't change the extension of %q; it has instances", d)
+			}
+			m.Ext = initExt
+		} else if m.Ext == "" {
+			m.Ext = dirr.ext()
+		}
+
+		if initNames != "" {
+			m.Names = initNames
+		} else if m.Names == "" {
+			m.Names = namesRandom
+		}
+
+		if hasFlag("s", "sync") || m.Durability == "" {
+			m.Durability = dirr.binDir().Opts().Durability.String()
+		}
+
+		if hasFlag("k", "keep") {
+			m.History = initHistory
+		}
+
+		if initSchema != "" {
+			m.Schema = s
+		}
+
+		return nil
+	})
+	if err != nil {
+		fatalMultiErr(err)
 	}
 }
 
+var initSchema, initExt, initNames string
+var initHistory int
+
 func checkInit() bool {
 	fail := false
 
@@ -157,44 +179,21 @@ func checkInit() bool {
 		errorr(err)
 	}
 
-	// Check flags
-
-	d := "."
-	foundD := false
-
-	for i, f := range flags {
-		switch f.Name {
-		case "d", "directory":
-			if foundD {
-				// Already found
-				fail = true
-				errorr("multiple directory flags")
-			} else {
-				foundD = true
-				rmFlag(i)
-				if f.HasVal {
-					d = f.Val
-				} else {
-					fail = true
-					errorr("no value assigned to a \"directory\" flag")
-				}
-			}
-		default:
-			fail = true
-			errorf("unexpected flag %q", f.Name)
-		}
-	}
-
-	dirr = newDir(d)
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Durability: cmdFlags.durability()})
+	initSchema = cmdFlags.str("schema", "")
+	initExt = cmdFlags.str("ext", "")
+	initNames = cmdFlags.str("names", "")
+	initHistory = cmdFlags.integer("k", 0)
 
 	return !fail
 }
 
-// Usage: dirb create json [-d path]
+// Usage: dirb create json [-s durability] [-d path]
 func cmdNew() {
 	if !checkNew() {
 		os.Exit(2)
 	}
+	recoverDir()
 
 	s := remArgs[0]
 
@@ -212,7 +211,7 @@ func cmdNew() {
 
 	name, err := newJsnGenName(dirr, jo)
 	if err != nil {
-		// This command should never fail; unexpected error.
+		// E.g. violates the schema, or a unique constraint.
 		fatalMultiErr(err)
 	} else {
 		fmt.Println(name)
@@ -229,52 +228,30 @@ func checkNew() bool {
 		errorr(err)
 	}
 
-	// Check flags
-
-	d := "."
-	foundD := false
-
-	for i, f := range flags {
-		switch f.Name {
-		case "d", "directory":
-			if foundD {
-				// Already found
-				fail = true
-				errorr("multiple directory flags")
-			} else {
-				foundD = true
-				rmFlag(i)
-				if f.HasVal {
-					d = f.Val
-				} else {
-					fail = true
-					errorr("no value assigned to a \"directory\" flag")
-				}
-			}
-		default:
-			fail = true
-			errorf("unexpected flag %q", f.Name)
-		}
-	}
-
-	dirr = newDir(d)
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Durability: cmdFlags.durability()})
 
 	return !fail
 }
 
-// Usage: dirb get name [-d path] [-p [bool]]
+// Usage: dirb get name [-e [bool]] [-w [duration]] [-d path] [-p [bool]]
+// With -e, prints the version token (etag) of the instance first, in a separate line.
 func cmdGet() {
 	if !checkGet() {
 		os.Exit(2)
 	}
+	recoverDir()
 
 	name := remArgs[0]
 
-	jo, err := dirr.getObj(name)
+	jo, etag, err := dirr.getObjETag(name)
 	if err != nil {
 		fatalMultiErr(err)
 	}
 
+	if printETag {
+		fmt.Println(etag)
+	}
+
 	s, err := jsnObjToStrTabIndent(jo, pretty)
 	if err != nil {
 		fatalMultiErr(err)
@@ -297,76 +274,30 @@ func checkGet() bool {
 		errorr(err)
 	}
 
-	// Check flags
-
-	d := "."
-	foundD := false
-
-	pl := false
-	foundP := false
-
-	for i, f := range flags {
-		switch f.Name {
-		case "d", "directory":
-			if foundD {
-				// Already found
-				fail = true
-				errorr("multiple \"directory\" flags")
-			} else {
-				foundD = true
-				rmFlag(i)
-				if f.HasVal {
-					d = f.Val
-				} else {
-					fail = true
-					errorr("no value assigned to a \"directory\" flag")
-				}
-			}
-		case "p", "pretty":
-			if foundP {
-				// Already found
-				fail = true
-				errorr("multiple \"pretty\" flags")
-			} else {
-				foundP = true
-				rmFlag(i)
-				if f.HasVal {
-					ps := f.Val
-					// 1 | 0 | t | f | T | F | true | false | TRUE | FALSE | True | False
-					switch ps {
-					case "1", "t", "T", "true", "TRUE", "True":
-						pl = true
-					case "0", "f", "F", "false", "FALSE", "False":
-						pl = false
-					default:
-						fail = true
-						errorf("invalid boolean value %q", ps)
-					}
-				} else {
-					pl = true
-				}
-			}
-		default:
-			fail = true
-			errorf("unexpected flag %q", f.Name)
-		}
-	}
-
-	dirr = newDir(d)
-	pretty = pl
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait()})
+	pretty = cmdFlags.boolean("p")
+	printETag = cmdFlags.boolean("e")
 
 	return !fail
 }
 
-// Usage: dirb up name json [-d path]
+// Usage: dirb up name json [--merge mode | --json-patch [bool]] [-m etag] [-k n] [-w [duration]] [-s durability] [-d path]
+// Applies the json as a merge patch (see jsn.MergePatch), or (with --merge deep) merges it recursively, keeping the nulls.
+// With --json-patch, the json is a json patch instead; see jsn.Patch.
 func cmdUp() {
 	if !checkUp() {
 		os.Exit(2)
 	}
+	recoverDir()
 
 	name := remArgs[0]
 	s := remArgs[1]
 
+	if jsonPatch {
+		cmdUpJsonPatch(name, s)
+		return
+	}
+
 	var jo map[string]interface{}
 	var err error
 	if s == "-" {
@@ -379,12 +310,39 @@ func cmdUp() {
 		fatalMultiErr(err)
 	}
 
-	err = dirr.up(name, jo)
+	err = dirr.upModeIfMatch(name, jo, ifMatch, mergeMode)
+	if err != nil {
+		fatalMultiErr(err)
+	}
+}
+
+func cmdUpJsonPatch(name string, s string) {
+	var j interface{}
+	var err error
+	if s == "-" {
+		// Read from stdin
+		j, err = jsn.ReaderToJsn(os.Stdin)
+	} else {
+		j, err = jsn.StrToJsn(s)
+	}
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	p, err := jsn.ParsePatch(j)
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	err = dirr.patchIfMatch(name, p, ifMatch)
 	if err != nil {
 		fatalMultiErr(err)
 	}
 }
 
+var mergeMode jsn.MergeMode
+var jsonPatch = false
+
 func checkUp() bool {
 	fail := false
 
@@ -397,42 +355,29 @@ func checkUp() bool {
 
 	// Check flags
 
-	d := "."
-	foundD := false
-
-	for i, f := range flags {
-		switch f.Name {
-		case "d", "directory":
-			if foundD {
-				// Already found
-				fail = true
-				errorr("multiple \"directory\" flags")
-			} else {
-				foundD = true
-				rmFlag(i)
-				if f.HasVal {
-					d = f.Val
-				} else {
-					fail = true
-					errorr("no value assigned to a \"directory\" flag")
-				}
-			}
-		default:
-			fail = true
-			errorf("unexpected flag %q", f.Name)
-		}
+	jp := cmdFlags.boolean("json-patch")
+	mm, mmf := cmdFlags["merge"].(jsn.MergeMode)
+	if !mmf {
+		mm = jsn.MergeModePatch
+	} else if jp {
+		fail = true
+		errorr("a \"merge\" flag is not of a json patch")
 	}
 
-	dirr = newDir(d)
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait(), Durability: cmdFlags.durability(), History: cmdFlags.integer("k", 0)})
+	ifMatch = cmdFlags.str("m", "")
+	mergeMode = mm
+	jsonPatch = jp
 
 	return !fail
 }
 
-// Usage: dirb over name json [-d path]
+// Usage: dirb over name json [-m etag] [-k n] [-w [duration]] [-s durability] [-d path]
 func cmdOver() {
 	if !checkOver() {
 		os.Exit(2)
 	}
+	recoverDir()
 
 	name := remArgs[0]
 	s := remArgs[1]
@@ -449,7 +394,7 @@ func cmdOver() {
 		fatalMultiErr(err)
 	}
 
-	err = dirr.over(name, jo)
+	err = dirr.overIfMatch(name, jo, ifMatch)
 	if err != nil {
 		fatalMultiErr(err)
 	}
@@ -465,48 +410,23 @@ func checkOver() bool {
 		errorr(err)
 	}
 
-	// Check flags
-
-	d := "."
-	foundD := false
-
-	for i, f := range flags {
-		switch f.Name {
-		case "d", "directory":
-			if foundD {
-				// Already found
-				fail = true
-				errorr("multiple \"directory\" flags")
-			} else {
-				foundD = true
-				rmFlag(i)
-				if f.HasVal {
-					d = f.Val
-				} else {
-					fail = true
-					errorr("no value assigned to a \"directory\" flag")
-				}
-			}
-		default:
-			fail = true
-			errorf("unexpected flag %q", f.Name)
-		}
-	}
-
-	dirr = newDir(d)
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait(), Durability: cmdFlags.durability(), History: cmdFlags.integer("k", 0)})
+	ifMatch = cmdFlags.str("m", "")
 
 	return !fail
 }
 
-// Usage: dirb rm name [-d path]
+// Usage: dirb rm name [-m etag] [-k n] [-t [bool]] [-a duration] [-w [duration]] [-s durability] [-d path]
+// With -t, moves the instance into the trash; then, trashed instances older than -a are purged.
 func cmdRm() {
 	if !checkRm() {
 		os.Exit(2)
 	}
+	recoverDir()
 
 	name := remArgs[0]
 
-	err := dirr.rm(name)
+	err := dirr.rmIfMatch(name, ifMatch)
 	if err != nil {
 		fatalMultiErr(err)
 	}
@@ -522,242 +442,199 @@ func checkRm() bool {
 		errorr(err)
 	}
 
-	// Check flags
-
-	d := "."
-	foundD := false
-
-	for i, f := range flags {
-		switch f.Name {
-		case "d", "directory":
-			if foundD {
-				// Already found
-				fail = true
-				errorr("multiple \"directory\" flags")
-			} else {
-				foundD = true
-				rmFlag(i)
-				if f.HasVal {
-					d = f.Val
-				} else {
-					fail = true
-					errorr("no value assigned to a \"directory\" flag")
-				}
-			}
-		default:
-			fail = true
-			errorf("unexpected flag %q", f.Name)
-		}
-	}
-
-	dirr = newDir(d)
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait(), Durability: cmdFlags.durability(), History: cmdFlags.integer("k", 0), Trash: cmdFlags.boolean("t"), TrashMaxAge: cmdFlags.duration("a")})
+	ifMatch = cmdFlags.str("m", "")
 
 	return !fail
 }
 
 // Usage: dirb
 func cmdNon() {
-	fatalc(2, "no command given")
+	fatalc(2, "no command given; run \"dirb help\" for the list of the commands")
 }
 
-// Usage: dirb help command
+// Usage: dirb help [command]
 func cmdHelp() {
-	fatal("not yet implemented")
+	if !checkHelp() {
+		os.Exit(2)
+	}
+
+	if len(remArgs) == 0 {
+		printCmds()
+		return
+	}
+
+	c := lookupCmd(remArgs[0])
+	if c == nil {
+		cmdUnk(remArgs[0])
+	}
+
+	c.printHelp()
+}
+
+func checkHelp() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotAtMostRemArgs(1)
+	if err != nil {
+		fail = true
+		errorr(err)
+	}
+
+	return !fail
 }
 
 // Usage: dirb unknown-command
 func cmdUnk(unkCmd string) {
-	fatalfc(2, "unknown command %q", unkCmd)
+	if s := suggest(unkCmd, cmdNames()); s != "" {
+		fatalfc(2, "unknown command %q; did you mean %q?", unkCmd, s)
+	}
+
+	fatalfc(2, "unknown command %q; run \"dirb help\" for the list of the commands", unkCmd)
 }
 
-// Usage: dirb find l op r [-l [bool]] [-r [bool]] [-d path]
+// Usage: dirb find query... [--docs [bool]] [--fields field,...] [--format format] [-p [bool]] [--sort field[:desc],...] [--limit n] [--offset n] [-w [duration]] [-d path]
+// The query is a boolean expression over the fields of the instances (see queryExpr), e.g. `dirb find 'lang == "en" and pages > 300'`; multiple arguments are joined by spaces.
+// Prints the names of the matching instances, or (with --docs, or --fields) their documents, or projections; by their names, or in the order of the given fields (see sortKey).
 func cmdFind() {
 	if !checkFind() {
 		os.Exit(2)
 	}
+	recoverDir()
 
-	lops := remArgs[0]
-	ops := remArgs[1]
-	rops := remArgs[2]
-
-	remArgs = remArgs[3:]
-
-	op, err := opFunc(ops)
-	if err != nil {
-		fatalc(2, err)
+	if !find(query) {
+		os.Exit(1)
 	}
+}
 
-	var lop, rop interface{}
+type jsnObjName struct {
+	name string
+	jo   map[string]interface{}
+}
 
-	if leftOperandIsFieldRef {
-		lop = parseFieldRef(lops)
-	} else {
-		lop, err = jsn.StrToJsn(lops)
+func find(q queryExpr) bool {
+	ns, found := findCands(q)
+	ok := true
+	if !found {
+		var err error
+		ns, err = dirr.all()
 		if err != nil {
-			lop = lops
+			ok = false
+			multiErr(err)
 		}
 	}
 
-	if rightOperandIsFieldRef {
-		rop = parseFieldRef(rops)
-	} else {
-		rop, err = jsn.StrToJsn(rops)
-		if err != nil {
-			rop = rops
+	return printPage(dirr, ns, q) && ok
+}
+
+// findCands returns the names of the instances which may match q, sorted; looked up in the indexes of the directory (see lookupIndexes), or false if it can't be.
+func findCands(q queryExpr) ([]string, bool) {
+	ixs, err := dirr.indexes()
+	if err != nil {
+		// Just slower
+		multiWarning(err)
+		return nil, false
+	}
+
+	fns, found := lookupIndexes(q, ixs)
+	if !found {
+		return nil, false
+	}
+
+	ns := make([]string, 0, len(fns))
+	for fn := range fns {
+		if n, ok := dirr.instName(fn); ok {
+			ns = append(ns, n)
 		}
 	}
+	sort.Strings(ns)
 
-	find(lop, rop, op)
+	return ns, true
 }
 
-type jsnObjName struct {
-	name string
-	jo   map[string]interface{}
+// pinAll reads all the instances of d while holding their shared locks; so the caller sees a consistent view of the directory until it calls unpin.
+func pinAll(d *dir) ([]*jsnObjName, func(), bool) {
+	ns, err := d.all()
+	if err != nil {
+		multiErr(err)
+	}
+
+	jons, unpin, ok := pin(d, ns)
+	return jons, unpin, ok && err == nil
 }
 
-func find(lop, rop interface{}, op func(interface{}, interface{}) bool) {
+// pin is like pinAll, but for the named instances only; the missing ones (e.g. just removed) are skipped.
+func pin(d *dir, ns []string) ([]*jsnObjName, func(), bool) {
 	fail := false
-	ns, err := dirr.all()
-	if err != nil {
-		fail = true
-		multiErr(err)
+	tkns := make([]*bin.RLckTkn, 0, len(ns))
+	unpin := func() {
+		for _, tkn := range tkns {
+			err := tkn.Unlck()
+			if err != nil {
+				errorr(err)
+			}
+		}
 	}
 
-	jons := make([]*jsnObjName, 0, len(ns))
+	lns := make([]string, 0, len(ns))
 	for _, n := range ns {
-		jo, err := dirr.getObj(n)
+		tkn, err := d.rlck(n)
 		if err != nil {
-			errorr(err)
+			var errNotExist *bin.ErrNotExist
+			if !stdErrors.As(err, &errNotExist) {
+				fail = true
+				errorr(err)
+			}
 		} else {
-			jons = append(jons, &jsnObjName{n, jo})
+			tkns = append(tkns, tkn)
+			lns = append(lns, n)
 		}
 	}
 
-loop:
-	for _, jon := range jons {
-		n := jon.name
-		jo := jon.jo
-		var l, r interface{}
-
-		if leftOperandIsFieldRef {
-			var nest interface{} = jo
-			for _, k := range lop.(fieldRef) {
-				if k == "root" {
-					continue
-				} else {
-					if nestJo, ok := nest.(map[string]interface{}); ok {
-						if nestJoK, ok := nestJo[k]; ok {
-							nest = nestJoK
-						} else {
-							continue loop
-						}
-					} else {
-						continue loop
-					}
-				}
-			}
-			l = nest
-		} else {
-			l = lop
-		}
-
-		if rightOperandIsFieldRef {
-			var nest interface{} = jo
-			for _, k := range rop.(fieldRef) {
-				if k == "root" {
-					continue
-				} else {
-					if nestJo, ok := nest.(map[string]interface{}); ok {
-						if nestJoK, ok := nestJo[k]; ok {
-							nest = nestJoK
-						} else {
-							continue loop
-						}
-					} else {
-						continue loop
-					}
-				}
-			}
-			r = nest
+	jons := make([]*jsnObjName, 0, len(lns))
+	for _, n := range lns {
+		jo, err := d.getObjBare(n)
+		if err != nil {
+			fail = true
+			errorr(err)
 		} else {
-			r = rop
-		}
-
-		if op(l, r) {
-			fmt.Println(n)
+			jons = append(jons, &jsnObjName{n, jo})
 		}
 	}
 
-	if fail {
-		os.Exit(1)
-	}
+	return jons, unpin, !fail
 }
 
 func opFunc(op string) (func(interface{}, interface{}) bool, error) {
 	switch op {
 	case "<":
 		return func(jl interface{}, jr interface{}) bool {
-			// Both jl & jr should be of a primitive json type.
-			_, jlook := jl.(map[string]interface{})
-			_, jlaok := jl.([]interface{})
-			_, jrook := jl.(map[string]interface{})
-			_, jraok := jl.([]interface{})
-
-			if jlook || jlaok || jrook || jraok {
-				return false
-			} else {
-				return fmt.Sprint(jl) < fmt.Sprint(jr)
-			}
+			c, ok := jsn.Cmp(jl, jr)
+			return ok && c < 0
 		}, nil
 	case "<=":
 		return func(jl interface{}, jr interface{}) bool {
-			// Both jl & jr should be of a primitive json type.
-			_, jlook := jl.(map[string]interface{})
-			_, jlaok := jl.([]interface{})
-			_, jrook := jl.(map[string]interface{})
-			_, jraok := jl.([]interface{})
-
-			if jlook || jlaok || jrook || jraok {
-				return false
-			} else {
-				return fmt.Sprint(jl) <= fmt.Sprint(jr)
-			}
+			c, ok := jsn.Cmp(jl, jr)
+			return ok && c <= 0
 		}, nil
 	case ">":
 		return func(jl interface{}, jr interface{}) bool {
-			// Both jl & jr should be of a primitive json type.
-			_, jlook := jl.(map[string]interface{})
-			_, jlaok := jl.([]interface{})
-			_, jrook := jl.(map[string]interface{})
-			_, jraok := jl.([]interface{})
-
-			if jlook || jlaok || jrook || jraok {
-				return false
-			} else {
-				return fmt.Sprint(jl) > fmt.Sprint(jr)
-			}
+			c, ok := jsn.Cmp(jl, jr)
+			return ok && c > 0
 		}, nil
 	case ">=":
 		return func(jl interface{}, jr interface{}) bool {
-			// Both jl & jr should be of a primitive json type.
-			_, jlook := jl.(map[string]interface{})
-			_, jlaok := jl.([]interface{})
-			_, jrook := jl.(map[string]interface{})
-			_, jraok := jl.([]interface{})
-
-			if jlook || jlaok || jrook || jraok {
-				return false
-			} else {
-				return fmt.Sprint(jl) >= fmt.Sprint(jr)
-			}
+			c, ok := jsn.Cmp(jl, jr)
+			return ok && c >= 0
 		}, nil
 	case "==":
 		return func(jl interface{}, jr interface{}) bool {
-			return jsnEq(jl, jr)
+			return jsn.Equal(jl, jr)
 		}, nil
 	case "!=":
 		return func(jl interface{}, jr interface{}) bool {
-			return !jsnEq(jl, jr)
+			return !jsn.Equal(jl, jr)
 		}, nil
 	case "in":
 		return opIn, nil
@@ -822,7 +699,7 @@ func primInPrim(jl interface{}, jr interface{}) bool {
 
 func valInArr(jl interface{}, jar []interface{}) bool {
 	for _, jarv := range jar {
-		if jsnEq(jl, jarv) {
+		if jsn.Equal(jl, jarv) {
 			return true
 		}
 	}
@@ -860,7 +737,7 @@ func objInObj(jol, jor map[string]interface{}) bool {
 
 func keyInObj(jl interface{}, jor map[string]interface{}) bool {
 	for kr, _ := range jor {
-		if jsnEq(kr, jl) {
+		if jsn.Equal(kr, jl) {
 			return true
 		}
 	}
@@ -868,58 +745,6 @@ func keyInObj(jl interface{}, jor map[string]interface{}) bool {
 	return false
 }
 
-func jsnEq(j1, j2 interface{}) bool {
-	if reflect.TypeOf(j1) != reflect.TypeOf(j2) {
-		return false
-	}
-
-	switch x := j1.(type) {
-	case map[string]interface{}:
-		y := j2.(map[string]interface{})
-
-		if len(x) != len(y) {
-			return false
-		}
-
-		for k, v1 := range x {
-			v2 := y[k]
-
-			if (v1 == nil) != (v2 == nil) {
-				return false
-			}
-
-			if !jsnEq(v1, v2) {
-				return false
-			}
-		}
-
-		return true
-	case []interface{}:
-		y := j2.([]interface{})
-
-		if len(x) != len(y) {
-			return false
-		}
-
-		var matches int
-		flagged := make([]bool, len(y))
-		for _, v1 := range x {
-			for i, v2 := range y {
-				if jsnEq(v1, v2) && !flagged[i] {
-					matches++
-					flagged[i] = true
-
-					break
-				}
-			}
-		}
-
-		return matches == len(x)
-	default:
-		return j1 == j2
-	}
-}
-
 func errIfNotExactRemArgs(i int) error {
 	if i < 0 {
 		panic(fmt.Sprintf("negative number of args %d", i))
@@ -958,155 +783,208 @@ func parseBoolVal(s string) (bool, error) {
 	}
 }
 
-type fieldRef []string
-
-var fieldRefRegex *regexp.Regexp
-
-func parseFieldRef(s string) fieldRef {
-	if fieldRefRegex == nil {
-		fieldRefRegex = regexp.MustCompile(`([^\\])(\.)`)
-	}
-
-	ref := make([]string, 0, 1)
-	iss := fieldRefRegex.FindAllStringSubmatchIndex(s, -1)
-	if len(iss) == 0 {
-		ref = append(ref, s)
-	} else {
-		for _, is := range iss {
-			_ = is[4]
-			end := is[5]
-			ref = append(ref, s[:end-1])
-			s = s[end:]
+// parseWaitVal parses the value of a "wait" flag; a timeout, or none (i.e. waits forever) if it has no value.
+func parseWaitVal(s string, hasVal bool) (*bin.WaitOpts, error) {
+	w := &bin.WaitOpts{}
+	if hasVal {
+		var err error
+		w.Timeout, err = time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q", s)
 		}
 	}
 
-	return ref
+	return w, nil
 }
 
-var leftOperandIsFieldRef, rightOperandIsFieldRef bool
+var query queryExpr
 
 func checkFind() bool {
 	fail := false
 
 	// Check args
-	err := errIfNotExactRemArgs(3)
-	if err != nil {
+	var q queryExpr
+	if len(remArgs) == 0 {
 		fail = true
-		errorr(err)
-	}
+		errorr("no query")
+	} else {
+		var err error
+		q, err = parseQuery(strings.Join(remArgs, " "))
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+	}
 
 	// Check flags
 
-	d, df := ".", false
-	pp, pf := false, false
-	l, lf := false, false
-	r, rf := false, false
+	docs := cmdFlags.boolean("docs")
+	pfs, fieldsf := cmdFlags["fields"].([]*projField)
+	if fieldsf {
+		docs = true
+	}
 
-	for i, f := range flags {
-		switch f.Name {
-		case "d", "directory":
-			if df {
-				// Already found
-				fail = true
-				errorr("multiple \"directory\" flags")
-			} else {
-				df = true
-				rmFlag(i)
-				if f.HasVal {
-					d = f.Val
-				} else {
-					fail = true
-					errorr("no value assigned to a \"directory\" flag")
-				}
-			}
-		case "p", "pretty":
-			if pf {
-				// Already found
-				fail = true
-				errorr("multiple \"pretty\" flags")
-			} else {
-				pf = true
-				rmFlag(i)
-				if f.HasVal {
-					ps := f.Val
-					// 1 | 0 | t | f | T | F | true | false | TRUE | FALSE | True | False
-					switch ps {
-					case "1", "t", "T", "true", "TRUE", "True":
-						pp = true
-					case "0", "f", "F", "false", "FALSE", "False":
-						pp = false
-					default:
-						fail = true
-						errorf("invalid boolean value %q", ps)
-					}
-				} else {
-					pp = true
-				}
-			}
-		case "l", "left-operand-is-field-reference":
-			if lf {
-				// Already found
-				fail = true
-				errorr("multiple \"left-operand-is-field-reference\" flags")
-			} else {
-				lf = true
-				rmFlag(i)
-				if f.HasVal {
-					ls := f.Val
-					var err error
-					l, err = parseBoolVal(ls)
-					if err != nil {
-						fail = true
-						errorr(err)
-					}
-				} else {
-					l = true
-				}
-			}
-		case "r", "right-operand-is-field-reference":
-			if rf {
-				// Already found
-				fail = true
-				errorr("multiple \"right-operand-is-field-reference\" flags")
-			} else {
-				rf = true
-				rmFlag(i)
-				if f.HasVal {
-					rs := f.Val
-					var err error
-					r, err = parseBoolVal(rs)
-					if err != nil {
-						fail = true
-						errorr(err)
-					}
-				} else {
-					r = true
-				}
-			}
-		default:
+	ofmt, fmtf := cmdFlags["format"].(outputFormat)
+	if fmtf && !docs {
+		fail = true
+		errorr("a \"format\" flag is of the documents only; see the \"docs\", and \"fields\" flags")
+	}
+
+	pp := cmdFlags.boolean("p")
+	if pp {
+		// Of the documents only; accepted (with no effect) for the names, for compatibility.
+		if fmtf && ofmt != outputPretty {
 			fail = true
-			errorf("unexpected flag %q", f.Name)
+			errorr("a \"pretty\" flag contradicts the \"format\" flag")
 		}
+		ofmt = outputPretty
 	}
 
-	dirr = newDir(d)
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait()})
 	pretty = pp
-	leftOperandIsFieldRef = l
-	rightOperandIsFieldRef = r
+	query = q
+	printDocs, projection, outFormat = docs, pfs, ofmt
+	sortKeys, _ = cmdFlags["sort"].([]*sortKey)
+	limit, offset = cmdFlags.integer("limit", -1), cmdFlags.integer("offset", 0)
 
 	return !fail
 }
 
-// Usage: dirb grep regex [-d path]
+// Usage: dirb grep regex [-f field] [-k [bool]] [-v [bool]] [-o [bool]] [-w [duration]] [-d path]
+// Searches the keys and the (primitive) values of the instances, or only the keys (-k) or the values (-v), by the regular expression; within the given field only, if any.
+// Prints the names of the matching instances, or (with -o) a json object per match, e.g. {"name": "x", "path": "/a/0", "value": "abc"}.
 func cmdGrep() {
-	fatal("not yet implemented")
+	if !checkGrep() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	jons, unpin, ok := pinAll(dirr)
+	defer unpin()
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for _, jon := range jons {
+		ms := make([]*grepMatch, 0)
+		for _, l := range jsn.Path(grepField).Locate(jon.jo) {
+			ms = grepJsn(l.Ptr, l.Val, ms)
+		}
+		if len(ms) == 0 {
+			continue
+		}
+
+		if !printMatches {
+			fmt.Println(jon.name)
+			continue
+		}
+
+		for _, m := range ms {
+			m.Name = jon.name
+			err := enc.Encode(m)
+			if err != nil {
+				fatalf("failed to write the matches; %v", err)
+			}
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// grepMatch is a match of grep; the value at path (e.g. a null), and the key of it too, if the key matched.
+type grepMatch struct {
+	Name  string      `json:"name"`
+	Path  string      `json:"path"`
+	Key   string      `json:"key,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+var grepRegex *regexp.Regexp
+var grepField fieldRef
+var grepKeys, grepValues, printMatches bool
+
+// grepJsn appends the matches of j (at json pointer ptr) to ms; see cmdGrep.
+func grepJsn(ptr string, j interface{}, ms []*grepMatch) []*grepMatch {
+	switch x := j.(type) {
+	case map[string]interface{}:
+		ks := make([]string, 0, len(x))
+		for k := range x {
+			ks = append(ks, k)
+		}
+		sort.Strings(ks)
+
+		for _, k := range ks {
+			kPtr := ptr + "/" + jsn.EscapePtrToken(k)
+			if grepKeys && grepRegex.MatchString(k) {
+				ms = append(ms, &grepMatch{Path: kPtr, Key: k, Value: x[k]})
+			}
+
+			ms = grepJsn(kPtr, x[k], ms)
+		}
+	case []interface{}:
+		for i, v := range x {
+			ms = grepJsn(ptr+"/"+strconv.Itoa(i), v, ms)
+		}
+	default:
+		if grepValues && grepRegex.MatchString(jsnPrimToStr(x)) {
+			ms = append(ms, &grepMatch{Path: ptr, Value: x})
+		}
+	}
+
+	return ms
+}
+
+// jsnPrimToStr returns the text of a primitive json; a string as is, and others as they're written in json.
+func jsnPrimToStr(j interface{}) string {
+	switch x := j.(type) {
+	case string:
+		return x
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprint(x)
+	}
 }
 
-// Usage: dirb ls [-d path]
+func checkGrep() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(1)
+	if err != nil {
+		fail = true
+		errorr(err)
+	} else {
+		var err error
+		grepRegex, err = regexp.Compile(remArgs[0])
+		if err != nil {
+			fail = true
+			errorf("invalid regular expression %q; %v", remArgs[0], err)
+		}
+	}
+
+	k, v := cmdFlags.boolean("k"), cmdFlags.boolean("v")
+	if !k && !v {
+		// Both, by default
+		k, v = true, true
+	}
+
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait()})
+	grepField, _ = cmdFlags["f"].(fieldRef)
+	grepKeys, grepValues = k, v
+	printMatches = cmdFlags.boolean("o")
+
+	return !fail
+}
+
+// Usage: dirb ls [--sort field[:desc],...] [--limit n] [--offset n] [-w [duration]] [-d path]
+// Prints the names of the instances; by the names, or in the order of the given fields (see sortKey).
 func cmdLs() {
 	if !checkLs() {
 		os.Exit(2)
 	}
+	recoverDir()
 
 	fail := false
 	ns, err := dirr.all()
@@ -1115,8 +993,8 @@ func cmdLs() {
 		multiErr(err)
 	}
 
-	for _, n := range ns {
-		fmt.Println(n)
+	if !printPage(dirr, ns, nil) {
+		fail = true
 	}
 
 	if fail {
@@ -1124,6 +1002,11 @@ func cmdLs() {
 	}
 }
 
+// The order, and the page of the listed instances; see printPage.
+var sortKeys []*sortKey
+var limit = -1
+var offset = 0
+
 func checkLs() bool {
 	fail := false
 
@@ -1134,45 +1017,1217 @@ func checkLs() bool {
 		errorr(err)
 	}
 
-	// Check flags
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait()})
+	sortKeys, _ = cmdFlags["sort"].([]*sortKey)
+	limit, offset = cmdFlags.integer("limit", -1), cmdFlags.integer("offset", 0)
 
-	d := "."
-	foundD := false
+	return !fail
+}
 
-	for i, f := range flags {
-		switch f.Name {
-		case "d", "directory":
-			if foundD {
-				// Already found
-				fail = true
-				errorr("multiple directory flags")
+// Usage: dirb unlock name [-a duration] [-d path]
+func cmdUnlck() {
+	if !checkUnlck() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	name := remArgs[0]
+
+	broken, err := dirr.breakStaleLck(name, maxAge)
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	if !broken {
+		info, err := dirr.lckInfo(name)
+		if err != nil {
+			fatalMultiErr(err)
+		}
+
+		fatalf("lock of %q is not stale; held by %s", name, info)
+	}
+}
+
+var maxAge time.Duration
+
+func checkUnlck() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(1)
+	if err != nil {
+		fail = true
+		errorr(err)
+	}
+
+	dirr = newDir(cmdFlags.dir())
+	maxAge = cmdFlags.duration("a")
+
+	return !fail
+}
+
+// Usage: dirb tx [-k n] [-t [bool]] [-w [duration]] [-s durability] [-d path]
+// Reads the operations from stdin; a json object per operation, e.g. {"op": "update", "name": "x", "json": {"a": 1}}.
+// Prints the generated names of the create operations, in order.
+func cmdTx() {
+	if !checkTx() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	t := dirr.begin()
+	names, err := applyTxOps(t, os.Stdin)
+	if err != nil {
+		errorr(err)
+		err = t.rollback()
+		if err != nil {
+			multiErr(err)
+		}
+		os.Exit(1)
+	}
+
+	err = t.commit()
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	for _, n := range names {
+		fmt.Println(n)
+	}
+}
+
+func applyTxOps(t *tx, r io.Reader) ([]string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	names := make([]string, 0)
+	for i := 1; ; i++ {
+		var op map[string]interface{}
+		err := dec.Decode(&op)
+		if err != nil {
+			if err == io.EOF {
+				return names, nil
 			} else {
-				foundD = true
-				rmFlag(i)
-				if f.HasVal {
-					d = f.Val
-				} else {
-					fail = true
-					errorr("no value assigned to a \"directory\" flag")
-				}
+				return nil, fmt.Errorf("operation %d: failed to decode; %w", i, err)
+			}
+		}
+
+		name, err := applyTxOp(t, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+}
+
+// applyTxOp applies op on t; returns the generated name of a create operation.
+func applyTxOp(t *tx, op map[string]interface{}) (string, error) {
+	ops, ok := op["op"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing or non-string \"op\" field")
+	}
+
+	name, hasName := op["name"].(string)
+	if _, ok := op["name"]; ok && !hasName {
+		return "", fmt.Errorf("non-string \"name\" field")
+	}
+
+	jo, hasJo := op["json"].(map[string]interface{})
+	if _, ok := op["json"]; ok && !hasJo {
+		return "", fmt.Errorf("non-object \"json\" field")
+	}
+
+	switch ops {
+	case "create", "new", "add":
+		if !hasJo {
+			return "", fmt.Errorf("missing \"json\" field")
+		}
+
+		if hasName {
+			err := errIfInvalidName(name)
+			if err != nil {
+				return "", err
+			}
+
+			return "", t.new(name, jo)
+		} else {
+			return t.dir().genName(func(name string) error {
+				return t.new(name, jo)
+			})
+		}
+	case "update", "up", "patch", "pch":
+		if !hasName || !hasJo {
+			return "", fmt.Errorf("missing \"name\" or \"json\" field")
+		}
+
+		mm := jsn.MergeModePatch
+		if v, ok := op["merge"]; ok {
+			s, ok := v.(string)
+			if !ok {
+				return "", fmt.Errorf("non-string \"merge\" field")
+			}
+
+			var err error
+			mm, err = jsn.ParseMergeMode(s)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		return "", t.upMode(name, jo, mm)
+	case "overwrite", "ow", "replace", "over":
+		if !hasName || !hasJo {
+			return "", fmt.Errorf("missing \"name\" or \"json\" field")
+		}
+
+		return "", t.over(name, jo)
+	case "json-patch":
+		jp, ok := op["patch"]
+		if !hasName || !ok {
+			return "", fmt.Errorf("missing \"name\" or \"patch\" field")
+		}
+
+		p, err := jsn.ParsePatch(jp)
+		if err != nil {
+			return "", err
+		}
+
+		return "", t.patch(name, p)
+	case "remove", "rm", "delete":
+		if !hasName {
+			return "", fmt.Errorf("missing \"name\" field")
+		}
+
+		return "", t.rm(name)
+	default:
+		return "", fmt.Errorf("unknown operation %q", ops)
+	}
+}
+
+func checkTx() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(0)
+	if err != nil {
+		fail = true
+		errorr(err)
+	}
+
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait(), Durability: cmdFlags.durability(), History: cmdFlags.integer("k", 0), Trash: cmdFlags.boolean("t")})
+
+	return !fail
+}
+
+// recoverDir loads the manifest of the directory (see dir.loadManifest), and rolls forward the transactions left behind by crashed processes; see bin.Dir.Recover.
+func recoverDir() {
+	err := dirr.loadManifest()
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	err = dirr.recover()
+	if err != nil {
+		multiWarning(err)
+	}
+}
+
+type fsckReport struct {
+	Kind     string `json:"kind"`
+	Path     string `json:"path"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Usage: dirb fsck [--repair [bool]] [-a duration] [-d path]
+// Prints a json object per problem.
+func cmdFsck() {
+	if !checkFsck() {
+		os.Exit(2)
+	}
+	// No recovery first; it's part of the repair.
+	err := dirr.loadManifest()
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	fail := false
+	ps, err := dirr.fsck(maxAge)
+	if err != nil {
+		fail = true
+		multiErr(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for _, p := range ps {
+		rep := &fsckReport{Kind: p.Kind, Path: p.Path, Detail: p.Detail}
+		if repair && p.Repairable() {
+			err := p.Repair()
+			if err != nil {
+				rep.Error = err.Error()
+			} else {
+				rep.Repaired = true
 			}
-		default:
+		}
+
+		if !rep.Repaired {
 			fail = true
-			errorf("unexpected flag %q", f.Name)
 		}
+
+		err := enc.Encode(rep)
+		if err != nil {
+			fatalf("failed to write the report; %v", err)
+		}
+	}
+
+	if fail {
+		os.Exit(1)
+	}
+}
+
+var repair bool
+
+func checkFsck() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(0)
+	if err != nil {
+		fail = true
+		errorr(err)
 	}
 
-	dirr = newDir(d)
+	dirr = newDir(cmdFlags.dir())
+	maxAge = cmdFlags.duration("a")
+	repair = cmdFlags.boolean("repair")
 
 	return !fail
 }
 
-func cmdJoin() {
-	fatal("not yet implemented")
+type revReport struct {
+	Rev  int       `json:"rev"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
 }
 
-func rmFlag(i int) {
-	flags = append(flags[:i], flags[i+1:]...)
+// Usage: dirb history ls name [-d path]
+// Prints a json object per revision, oldest first.
+func cmdHistLs() {
+	if !checkHistLs() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	name := remArgs[0]
+
+	rs, err := dirr.revs(name)
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, r := range rs {
+		err := enc.Encode(&revReport{r.N, r.Time, r.Size})
+		if err != nil {
+			fatalf("failed to write the revisions; %v", err)
+		}
+	}
+}
+
+func checkHistLs() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(1)
+	if err != nil {
+		fail = true
+		errorr(err)
+	}
+
+	dirr = newDir(cmdFlags.dir())
+
+	return !fail
+}
+
+// Usage: dirb history show name rev [-d path] [-p [bool]]
+func cmdHistShow() {
+	if !checkHistShow() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	name := remArgs[0]
+	n, _ := strconv.Atoi(remArgs[1])
+
+	jo, err := dirr.getRevObj(name, n)
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	s, err := jsnObjToStrTabIndent(jo, pretty)
+	if err != nil {
+		fatalMultiErr(err)
+	} else {
+		if s[len(s)-1] == '\n' {
+			fmt.Print(s)
+		} else {
+			fmt.Println(s)
+		}
+	}
+}
+
+func checkHistShow() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(2)
+	if err != nil {
+		fail = true
+		errorr(err)
+	} else {
+		err := errIfInvalidRev(remArgs[1])
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+	}
+
+	dirr = newDir(cmdFlags.dir())
+	pretty = cmdFlags.boolean("p")
+
+	return !fail
+}
+
+// Usage: dirb history diff name rev [rev] [-d path]
+// Prints a json object per change, from the first revision to the second one (or the current instance, if omitted).
+func cmdHistDiff() {
+	if !checkHistDiff() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	name := remArgs[0]
+	n1, _ := strconv.Atoi(remArgs[1])
+
+	jo1, err := dirr.getRevObj(name, n1)
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	var jo2 map[string]interface{}
+	if len(remArgs) == 3 {
+		n2, _ := strconv.Atoi(remArgs[2])
+		jo2, err = dirr.getRevObj(name, n2)
+	} else {
+		jo2, err = dirr.getObj(name)
+	}
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for _, c := range jsn.Diff(jo1, jo2) {
+		err := enc.Encode(c)
+		if err != nil {
+			fatalf("failed to write the changes; %v", err)
+		}
+	}
+}
+
+func checkHistDiff() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotAtMostRemArgs(3)
+	if err != nil {
+		fail = true
+		errorr(err)
+	}
+	if len(remArgs) < 2 {
+		fail = true
+		errorr("missing arguments; expected a name and one or two revisions")
+	} else {
+		for _, a := range remArgs[1:] {
+			err := errIfInvalidRev(a)
+			if err != nil {
+				fail = true
+				errorr(err)
+			}
+		}
+	}
+
+	dirr = newDir(cmdFlags.dir())
+
+	return !fail
+}
+
+// Usage: dirb history restore name rev [-k n] [-w [duration]] [-s durability] [-d path]
+// Atomically overwrites (or re-creates) the instance with the given revision; the overwritten content is kept as a new revision (with -k).
+func cmdHistRestore() {
+	if !checkHistRestore() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	name := remArgs[0]
+	n, _ := strconv.Atoi(remArgs[1])
+
+	err := dirr.restoreRev(name, n)
+	if err != nil {
+		fatalMultiErr(err)
+	}
+}
+
+func checkHistRestore() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(2)
+	if err != nil {
+		fail = true
+		errorr(err)
+	} else {
+		err := errIfInvalidRev(remArgs[1])
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+	}
+
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait(), Durability: cmdFlags.durability(), History: cmdFlags.integer("k", 0)})
+
+	return !fail
+}
+
+func errIfInvalidRev(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid revision %q; should be a positive integer", s)
+	}
+
+	return nil
+}
+
+type trashReport struct {
+	Id      string    `json:"id"`
+	Name    string    `json:"name"`
+	Deleted time.Time `json:"deleted"`
+	Pid     int       `json:"pid"`
+	Host    string    `json:"host"`
+}
+
+// Usage: dirb trash ls [-d path]
+// Prints a json object per trashed instance, oldest first.
+func cmdTrashLs() {
+	if !checkTrashLs() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	fail := false
+	es, err := dirr.trashed()
+	if err != nil {
+		fail = true
+		multiErr(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for _, e := range es {
+		name := strings.TrimSuffix(e.Name, dirr.ext())
+		err := enc.Encode(&trashReport{e.Id, name, e.Deleted, e.Pid, e.Host})
+		if err != nil {
+			fatalf("failed to write the trashed instances; %v", err)
+		}
+	}
+
+	if fail {
+		os.Exit(1)
+	}
+}
+
+func checkTrashLs() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(0)
+	if err != nil {
+		fail = true
+		errorr(err)
+	}
+
+	dirr = newDir(cmdFlags.dir())
+
+	return !fail
+}
+
+// Usage: dirb trash restore id [-w [duration]] [-s durability] [-d path]
+// Fails if another instance has taken the name of the trashed one since.
+func cmdTrashRestore() {
+	if !checkTrashRestore() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	id := remArgs[0]
+
+	err := dirr.untrash(id)
+	if err != nil {
+		fatalMultiErr(err)
+	}
+}
+
+func checkTrashRestore() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(1)
+	if err != nil {
+		fail = true
+		errorr(err)
+	} else {
+		err := errIfInvalidTrashId(remArgs[0])
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+	}
+
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait(), Durability: cmdFlags.durability()})
+
+	return !fail
+}
+
+// Usage: dirb trash purge [id] [-a duration] [-d path]
+// Without an id, purges the trashed instances older than -a (all of them, by default).
+func cmdTrashPurge() {
+	if !checkTrashPurge() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	var err error
+	if len(remArgs) == 1 {
+		err = dirr.purge(remArgs[0])
+	} else {
+		err = dirr.purgeTrash(maxAge)
+	}
+	if err != nil {
+		fatalMultiErr(err)
+	}
+}
+
+func checkTrashPurge() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotAtMostRemArgs(1)
+	if err != nil {
+		fail = true
+		errorr(err)
+	} else if len(remArgs) == 1 {
+		err := errIfInvalidTrashId(remArgs[0])
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+	}
+
+	// Check flags
+
+	if cmdFlags.has("a") && len(remArgs) == 1 {
+		fail = true
+		errorr("a \"max-age\" flag along with an id")
+	}
+
+	dirr = newDir(cmdFlags.dir())
+	maxAge = cmdFlags.duration("a")
+
+	return !fail
+}
+
+var trashIdRegex = regexp.MustCompile(`^[0-9a-f]+$`)
+
+func errIfInvalidTrashId(id string) error {
+	if !trashIdRegex.MatchString(id) {
+		return fmt.Errorf("invalid trash id %q", id)
+	}
+
+	return nil
+}
+
+// Usage: dirb join l r [-j path] [-l [bool]] [-n [bool]] [-w [duration]] [-d path]
+// Joins the instances of the directory with the ones of the -j directory (the same directory, by default), whose field l equals field r; inner join, or (with -l) left join.
+// Prints a json object per joined pair, holding the fields of both (the right one's win on a clash), or (with -n) the names of the pair, separated by a tab.
+func cmdJoin() {
+	if !checkJoin() {
+		os.Exit(2)
+	}
+	recoverDir()
+	if joinDirr != nil {
+		err := joinDirr.loadManifest()
+		if err != nil {
+			fatalMultiErr(err)
+		}
+	}
+
+	lref, _ := parseFieldRef(remArgs[0])
+	rref, _ := parseFieldRef(remArgs[1])
+
+	ljons, lunpin, ok := pinAll(dirr)
+	defer lunpin()
+
+	rjons := ljons
+	if joinDirr != nil {
+		var rok bool
+		var runpin func()
+		rjons, runpin, rok = pinAll(joinDirr)
+		defer runpin()
+		ok = ok && rok
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for _, ljon := range ljons {
+		matched := false
+		lks := lref.values(ljon.jo)
+		for _, rjon := range rjons {
+			if !anyJsnEq(lks, rref.values(rjon.jo)) {
+				continue
+			}
+
+			matched = true
+			printJoined(enc, ljon, rjon)
+		}
+
+		if !matched && leftJoin {
+			printJoined(enc, ljon, nil)
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// anyJsnEq reports whether any of j1s equals any of j2s.
+func anyJsnEq(j1s, j2s []interface{}) bool {
+	for _, j1 := range j1s {
+		for _, j2 := range j2s {
+			if jsn.Equal(j1, j2) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func printJoined(enc *json.Encoder, ljon *jsnObjName, rjon *jsnObjName) {
+	if printNames {
+		if rjon == nil {
+			fmt.Printf("%s\t\n", ljon.name)
+		} else {
+			fmt.Printf("%s\t%s\n", ljon.name, rjon.name)
+		}
+
+		return
+	}
+
+	jo := make(map[string]interface{}, len(ljon.jo))
+	for k, v := range ljon.jo {
+		jo[k] = v
+	}
+	if rjon != nil {
+		for k, v := range rjon.jo {
+			jo[k] = v
+		}
+	}
+
+	err := enc.Encode(jo)
+	if err != nil {
+		fatalf("failed to write the joined instances; %v", err)
+	}
+}
+
+var joinDirr *dir
+var leftJoin, printNames bool
+
+func checkJoin() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(2)
+	if err != nil {
+		fail = true
+		errorr(err)
+	} else {
+		for _, a := range remArgs {
+			_, err := parseFieldRef(a)
+			if err != nil {
+				fail = true
+				errorr(err)
+			}
+		}
+	}
+
+	d := cmdFlags.dir()
+	o := &bin.Opts{Wait: cmdFlags.wait()}
+	dirr = newDirOpts(d, o)
+	joinDirr = nil
+	if j, ok := cmdFlags["j"].(string); ok && filepath.Clean(j) != filepath.Clean(d) {
+		joinDirr = newDirOpts(j, o)
+	}
+	leftJoin = cmdFlags.boolean("l")
+	printNames = cmdFlags.boolean("n")
+
+	return !fail
+}
+
+// Usage: dirb index create name field [--text [bool]] [-w [duration]] [-s durability] [-d path]
+// The field is a field reference of the query language, e.g. `authors[*]`; see queryExpr.
+// With --text, creates a text index of the words of the strings in the field, for the search command; e.g. `dirb index create words root --text`.
+func cmdIndexNew() {
+	if !checkIndexNew() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	ref, _ := parseFieldRef(remArgs[1])
+
+	var err error
+	if textIndex {
+		err = dirr.createTextIndex(remArgs[0], ref)
+	} else {
+		err = dirr.createIndex(remArgs[0], ref)
+	}
+	if err != nil {
+		fatalMultiErr(err)
+	}
+}
+
+func checkIndexNew() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(2)
+	if err != nil {
+		fail = true
+		errorr(err)
+	} else {
+		err := errIfInvalidName(remArgs[0])
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+
+		_, err = parseFieldRef(remArgs[1])
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+	}
+
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait(), Durability: cmdFlags.durability()})
+	textIndex = cmdFlags.boolean("text")
+
+	return !fail
+}
+
+var textIndex bool
+
+type indexReport struct {
+	Name    string `json:"name"`
+	Field   string `json:"field"`
+	Text    bool   `json:"text,omitempty"`
+	Entries int    `json:"entries"`
+}
+
+// Usage: dirb index ls [-d path]
+// Prints a json object per index.
+func cmdIndexLs() {
+	if !checkIndexLs() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	fail := false
+	ixs, err := dirr.indexes()
+	if err != nil {
+		fail = true
+		multiErr(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for _, ix := range ixs {
+		err := enc.Encode(&indexReport{ix.Name, fieldRef(ix.Path).String(), ix.Text, len(ix.Entries)})
+		if err != nil {
+			fatalf("failed to write the indexes; %v", err)
+		}
+	}
+
+	if fail {
+		os.Exit(1)
+	}
+}
+
+func checkIndexLs() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(0)
+	if err != nil {
+		fail = true
+		errorr(err)
+	}
+
+	dirr = newDir(cmdFlags.dir())
+
+	return !fail
+}
+
+// Usage: dirb index rm name [-w [duration]] [-s durability] [-d path]
+func cmdIndexRm() {
+	if !checkIndexRm() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	err := dirr.rmIndex(remArgs[0])
+	if err != nil {
+		fatalMultiErr(err)
+	}
+}
+
+func checkIndexRm() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(1)
+	if err != nil {
+		fail = true
+		errorr(err)
+	} else {
+		err := errIfInvalidName(remArgs[0])
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+	}
+
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait(), Durability: cmdFlags.durability()})
+
+	return !fail
+}
+
+// Usage: dirb index rebuild [name] [-w [duration]] [-s durability] [-d path]
+// Without a name, rebuilds all the indexes.
+func cmdIndexRebuild() {
+	if !checkIndexRebuild() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	var ns []string
+	if len(remArgs) == 1 {
+		ns = remArgs
+	} else {
+		ixs, err := dirr.indexes()
+		if err != nil {
+			fatalMultiErr(err)
+		}
+
+		for _, ix := range ixs {
+			ns = append(ns, ix.Name)
+		}
+
+		// Along with the missing declared ones
+		m, err := dirr.manifest()
+		if err != nil {
+			fatalMultiErr(err)
+		}
+		dns := make([]string, 0, len(m.Indexes)+len(m.Unique))
+		for _, d := range m.Indexes {
+			dns = append(dns, d.Name)
+		}
+		for _, u := range m.Unique {
+			dns = append(dns, u.Name)
+		}
+		for _, dn := range dns {
+			found := false
+			for _, n := range ns {
+				if n == dn {
+					found = true
+					break
+				}
+			}
+			if !found {
+				ns = append(ns, dn)
+			}
+		}
+	}
+
+	fail := false
+	for _, n := range ns {
+		err := dirr.rebuildIndex(n)
+		if err != nil {
+			fail = true
+			multiErr(err)
+		}
+	}
+
+	if fail {
+		os.Exit(1)
+	}
+}
+
+func checkIndexRebuild() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotAtMostRemArgs(1)
+	if err != nil {
+		fail = true
+		errorr(err)
+	} else if len(remArgs) == 1 {
+		err := errIfInvalidName(remArgs[0])
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+	}
+
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait(), Durability: cmdFlags.durability()})
+
+	return !fail
+}
+
+// Usage: dirb search words... [-i index] [--scores [bool]] [-w [duration]] [-d path]
+// Searches the text indexes (or the -i one only) for the instances holding any of the words; see jsn.Index.Search. Multiple arguments are joined by spaces.
+// Prints the names of the found instances, best first; or (with --scores) a json object per instance, e.g. {"name": "x", "score": 1.5}.
+func cmdSearch() {
+	if !checkSearch() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	fail := false
+	ixs, err := dirr.indexes()
+	if err != nil {
+		fail = true
+		multiErr(err)
+	}
+
+	found := false
+	scores := make(map[string]float64)
+	for _, ix := range ixs {
+		if !ix.Text || (searchIndex != "" && ix.Name != searchIndex) {
+			continue
+		}
+
+		found = true
+		for _, h := range ix.Search(strings.Join(remArgs, " ")) {
+			scores[h.Name] += h.Score
+		}
+	}
+	if !found && !fail {
+		if searchIndex != "" {
+			fatalf("no text index %q", searchIndex)
+		}
+		fatal("no text index; create one by \"dirb index create name field --text\"")
+	}
+
+	hs := make([]*jsn.Hit, 0, len(scores))
+	for n, s := range scores {
+		if in, ok := dirr.instName(n); ok {
+			hs = append(hs, &jsn.Hit{Name: in, Score: s})
+		}
+	}
+	jsn.SortHits(hs)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for _, h := range hs {
+		if !printScores {
+			fmt.Println(h.Name)
+			continue
+		}
+
+		err := enc.Encode(h)
+		if err != nil {
+			fatalf("failed to write the found instances; %v", err)
+		}
+	}
+
+	if fail {
+		os.Exit(1)
+	}
+}
+
+var searchIndex string
+var printScores bool
+
+func checkSearch() bool {
+	fail := false
+
+	// Check args
+	if len(remArgs) == 0 {
+		fail = true
+		errorr("no words")
+	}
+
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait()})
+	searchIndex = cmdFlags.str("i", "")
+	printScores = cmdFlags.boolean("scores")
+
+	return !fail
+}
+
+// Usage: dirb unique add name field [-w [duration]] [-s durability] [-d path]
+// Declares the constraint in the manifest of the directory, and creates its index (of the same name).
+func cmdUniqueAdd() {
+	if !checkUniqueAdd() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	ref, _ := parseFieldRef(remArgs[1])
+	err := dirr.addUnique(remArgs[0], ref)
+	if err != nil {
+		fatalMultiErr(err)
+	}
+}
+
+func checkUniqueAdd() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(2)
+	if err != nil {
+		fail = true
+		errorr(err)
+	} else {
+		err := errIfInvalidName(remArgs[0])
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+
+		_, err = parseFieldRef(remArgs[1])
+		if err != nil {
+			fail = true
+			errorr(err)
+		}
+	}
+
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait(), Durability: cmdFlags.durability()})
+
+	return !fail
+}
+
+type uniqueReport struct {
+	Name  string `json:"name"`
+	Field string `json:"field"`
+}
+
+// Usage: dirb unique ls [-d path]
+// Prints a json object per unique constraint.
+func cmdUniqueLs() {
+	if !checkIndexLs() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	m, err := dirr.manifest()
+	if err != nil {
+		fatalMultiErr(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for _, u := range m.Unique {
+		err := enc.Encode(&uniqueReport{u.Name, fieldRef(u.Path).String()})
+		if err != nil {
+			fatalf("failed to write the unique constraints; %v", err)
+		}
+	}
+}
+
+// Usage: dirb unique rm name [-w [duration]] [-s durability] [-d path]
+// Removes the constraint along with its index.
+func cmdUniqueRm() {
+	if !checkIndexRm() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	err := dirr.rmUnique(remArgs[0])
+	if err != nil {
+		fatalMultiErr(err)
+	}
+}
+
+// Usage: dirb validate [-w [duration]] [-d path]
+// Prints a json object per violation of the schema of the instances (e.g. {"name": "x", "ptr": "/pages", "schemaPtr": "/properties/pages/type", "msg": "expected integer, not string"}); see the init command's --schema flag.
+func cmdValidate() {
+	if !checkValidate() {
+		os.Exit(2)
+	}
+	recoverDir()
+
+	s, err := dirr.schema()
+	if err != nil {
+		fatalMultiErr(err)
+	}
+	if s == nil {
+		// Nothing to violate
+		return
+	}
+
+	jons, unpin, ok := pinAll(dirr)
+	defer unpin()
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for _, jon := range jons {
+		for _, v := range s.Validate(jon.jo) {
+			ok = false
+			err := enc.Encode(&schemaViolation{jon.name, v})
+			if err != nil {
+				fatalf("failed to write the violations; %v", err)
+			}
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+type schemaViolation struct {
+	Name string `json:"name"`
+	*jsn.Violation
+}
+
+func checkValidate() bool {
+	fail := false
+
+	// Check args
+	err := errIfNotExactRemArgs(0)
+	if err != nil {
+		fail = true
+		errorr(err)
+	}
+
+	dirr = newDirOpts(cmdFlags.dir(), &bin.Opts{Wait: cmdFlags.wait()})
+
+	return !fail
 }
 
 func jsnObjToStrTabIndent(jo map[string]interface{}, tabIndent bool) (string, error) {
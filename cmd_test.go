@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/agcom/dirb/jsn"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newTestInstances creates the instances of jsons (by name) in a new directory, and returns its path.
+func newTestInstances(t *testing.T, jsons map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	d := newDirOpts(dir, nil)
+	for n, s := range jsons {
+		jo, err := jsn.StrToJsnObj(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.new(n, jo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// runTestCmd runs args as the command line arguments, and returns the output.
+func runTestCmd(t *testing.T, args ...string) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	parseTestArgs(t, args...)
+	stdout := os.Stdout
+	os.Stdout = f
+	defer func() {
+		os.Stdout = stdout
+	}()
+	cmd()
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+// sortLines sorts the lines of s; the instances are listed in the order of the directory entries.
+func sortLines(s string) string {
+	ls := strings.SplitAfter(s, "\n")
+	sort.Strings(ls)
+	return strings.Join(ls, "")
+}
+
+func TestGrep(t *testing.T) {
+	dir := newTestInstances(t, map[string]string{
+		"a": `{"title": "The Hobbit", "tags": ["draft", "x"], "n": null}`,
+		"b": `{"author": {"name": "tolkien"}, "tags": ["drafts"]}`,
+		"c": `{"k": 1.50}`,
+	})
+	defer func() {
+		dirr = nil
+	}()
+
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"tolkien"}, "b\n"},
+		{[]string{"hobbit"}, ""},
+		{[]string{"(?i)hobbit"}, "a\n"},
+		{[]string{"draft"}, "a\nb\n"},
+		// Keys, or values only
+		{[]string{"name", "-k"}, "b\n"},
+		{[]string{"name", "-v"}, ""},
+		{[]string{"^1.50$", "-v"}, "c\n"},
+		{[]string{"^draft$", "-f", "tags"}, "a\n"},
+		{[]string{"tolkien", "-f", "tags"}, ""},
+		{[]string{"^draft$", "-f", "tags", "-o"}, `{"name":"a","path":"/tags/0","value":"draft"}` + "\n"},
+		{[]string{"^null$", "-v", "-o"}, `{"name":"a","path":"/n","value":null}` + "\n"},
+		{[]string{"^n", "-k", "-o"}, `{"name":"a","path":"/n","key":"n","value":null}` + "\n" + `{"name":"b","path":"/author/name","key":"name","value":"tolkien"}` + "\n"},
+	} {
+		args := append([]string{"grep"}, c.args...)
+		args = append(args, "-d", dir)
+		if got := sortLines(runTestCmd(t, args...)); got != c.want {
+			t.Errorf("%q: got %q; want %q", c.args, got, c.want)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	books := newTestInstances(t, map[string]string{
+		"b1": `{"title": "x", "author": "p"}`,
+		"b2": `{"title": "y", "author": "q"}`,
+		"b3": `{"title": "z", "authors": ["p", "r"]}`,
+	})
+	authors := newTestInstances(t, map[string]string{
+		"p": `{"id": "p", "name": "P"}`,
+		"q": `{"id": "q", "name": "Q", "title": "dr"}`,
+	})
+	defer func() {
+		dirr, joinDirr = nil, nil
+	}()
+
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"author", "id", "-n"}, "b1\tp\nb2\tq\n"},
+		// The right one's fields win.
+		{[]string{"author", "id"}, `{"author":"p","id":"p","name":"P","title":"x"}` + "\n" + `{"author":"q","id":"q","name":"Q","title":"dr"}` + "\n"},
+		{[]string{"author", "id", "-n", "-l"}, "b1\tp\nb2\tq\nb3\t\n"},
+		{[]string{"authors[*]", "id", "-n", "-l"}, "b1\t\nb2\t\nb3\tp\n"},
+		{[]string{"author", "id", "-l"}, `{"author":"p","id":"p","name":"P","title":"x"}` + "\n" + `{"author":"q","id":"q","name":"Q","title":"dr"}` + "\n" + `{"authors":["p","r"],"title":"z"}` + "\n"},
+	} {
+		args := append([]string{"join"}, c.args...)
+		args = append(args, "-d", books, "-j", authors)
+		if got := sortLines(runTestCmd(t, args...)); got != c.want {
+			t.Errorf("%q: got %q; want %q", c.args, got, c.want)
+		}
+	}
+
+	// Of the same directory, by default
+	if got, want := sortLines(runTestCmd(t, "join", "author", "authors[*]", "-n", "-d", books)), "b1\tb3\n"; got != want {
+		t.Errorf("self join: got %q; want %q", got, want)
+	}
+}
@@ -1,37 +1,44 @@
 package main
 
 import (
+	stdErrors "errors"
 	"fmt"
 	"github.com/agcom/dirb/bin"
 	"github.com/agcom/dirb/jsn"
 	"go.uber.org/multierr"
-	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
 type dir jsn.Dir
 
 func newDir(d string) *dir {
-	dir := dir(*jsn.NewDir(d))
+	return newDirOpts(d, nil)
+}
+
+func newDirOpts(d string, o *bin.Opts) *dir {
+	dir := dir(*jsn.NewDirOpts(d, o))
 	return &dir
 }
 
 func (d *dir) new(name string, j interface{}) error {
-	name = name + ".json"
+	name = d.file(name)
 	return d.jsnDir().New(name, j)
 }
 
 func (d *dir) get(name string) (interface{}, error) {
-	name = name + ".json"
+	name = d.file(name)
 	return d.jsnDir().Get(name)
 }
 
 func (d *dir) over(name string, j interface{}) error {
-	name = name + ".json"
+	name = d.file(name)
 	return d.jsnDir().Over(name, j)
 }
 
 func (d *dir) rm(name string) error {
-	name = name + ".json"
+	name = d.file(name)
 	return d.jsnDir().Rm(name)
 }
 
@@ -39,31 +46,362 @@ func (d *dir) all() ([]string, error) {
 	ns, err := d.jsnDir().All()
 	ons := make([]string, 0, len(ns))
 	for _, n := range ns {
-		if filepath.Ext(n) == ".json" {
-			ons = append(ons, n[:len(n)-len(".json")])
+		if on, ok := d.instName(n); ok {
+			ons = append(ons, on)
 		} else {
-			err = multierr.Append(err, fmt.Errorf("missing \".json\" extension in %q", n))
+			err = multierr.Append(err, fmt.Errorf("missing %q extension in %q", d.ext(), n))
 		}
 	}
 
 	return ons, err
 }
 
+// defExt is the default file extension of the instances; see jsn.Manifest.Ext.
+const defExt = ".json"
+
+var extRegex = regexp.MustCompile(`^(\.[A-Za-z0-9_-]+)+$`)
+
+func errIfInvalidExt(ext string) error {
+	if !extRegex.MatchString(ext) {
+		return fmt.Errorf("invalid extension %q; should be a '.' followed by letters, digits, '-', and '_' (e.g. \".json\")", ext)
+	}
+
+	return nil
+}
+
+// manifests holds the loaded manifests of the directories, by their paths; see loadManifest.
+var manifests = make(map[string]*jsn.Manifest)
+
+// loadManifest reads the manifest of d, for the other methods of d to honor it; e.g. its extension (see ext), its default durability (unless a "sync" flag is given), and its default number of kept revisions (unless a "keep" flag is given).
+// Fails if d is of an incompatible format version; see jsn.ManifestVersion.
+func (d *dir) loadManifest() error {
+	m, err := d.manifest()
+	if err != nil {
+		return err
+	}
+
+	if m.Ext != "" {
+		err := errIfInvalidExt(m.Ext)
+		if err != nil {
+			return fmt.Errorf("invalid manifest %q; %w", jsn.ManifestPath(d.binDir().Dir()), err)
+		}
+	}
+
+	if m.Names != "" {
+		err := errIfInvalidNames(m.Names)
+		if err != nil {
+			return fmt.Errorf("invalid manifest %q; %w", jsn.ManifestPath(d.binDir().Dir()), err)
+		}
+	}
+
+	o := &bin.Opts{}
+	if do := d.binDir().Opts(); do != nil {
+		*o = *do
+	}
+
+	if m.Durability != "" && !hasFlag("s", "sync") {
+		o.Durability, err = bin.ParseDurability(m.Durability)
+		if err != nil {
+			return fmt.Errorf("invalid manifest %q; %w", jsn.ManifestPath(d.binDir().Dir()), err)
+		}
+	}
+
+	if m.History != 0 && !hasFlag("k", "keep") {
+		o.History = m.History
+	}
+
+	*d = *newDirOpts(d.binDir().Dir(), o)
+
+	manifests[d.binDir().Dir()] = m
+	return nil
+}
+
+// loadedManifest returns the loaded manifest of d (see loadManifest); the zero one if it's not loaded.
+func (d *dir) loadedManifest() *jsn.Manifest {
+	if m, ok := manifests[d.binDir().Dir()]; ok {
+		return m
+	}
+
+	return &jsn.Manifest{}
+}
+
+// ext returns the file extension of the instances of d.
+func (d *dir) ext() string {
+	if ext := d.loadedManifest().Ext; ext != "" {
+		return ext
+	}
+
+	return defExt
+}
+
+// file returns the file name of instance name.
+func (d *dir) file(name string) string {
+	return name + d.ext()
+}
+
+// instName returns the name of the instance of file fn; false if fn lacks the extension.
+func (d *dir) instName(fn string) (string, bool) {
+	ext := d.ext()
+	if len(fn) <= len(ext) || !strings.HasSuffix(fn, ext) {
+		return "", false
+	}
+
+	return fn[:len(fn)-len(ext)], true
+}
+
 func (d *dir) jsnDir() *jsn.Dir {
 	jd := jsn.Dir(*d)
 	return &jd
 }
 
 func (d *dir) getObj(name string) (map[string]interface{}, error) {
-	name = name + ".json"
+	name = d.file(name)
 	return d.jsnDir().GetObj(name)
 }
 
+func (d *dir) getObjBare(name string) (map[string]interface{}, error) {
+	name = d.file(name)
+	return d.jsnDir().GetObjBare(name)
+}
+
+func (d *dir) rlck(name string) (*bin.RLckTkn, error) {
+	name = d.file(name)
+	return d.jsnDir().RLck(name)
+}
+
 func (d *dir) up(name string, j interface{}) error {
-	name = name + ".json"
+	name = d.file(name)
 	return d.jsnDir().Up(name, j)
 }
 
 func (d *dir) binDir() *bin.Dir {
 	return d.jsnDir().BinDir()
 }
+
+func (d *dir) lckPath(name string) string {
+	name = d.file(name)
+	return bin.DefLckPath(d.jsnDir().Path(name))
+}
+
+func (d *dir) lckInfo(name string) (*bin.LckInfo, error) {
+	return bin.ReadLck(d.lckPath(name))
+}
+
+func (d *dir) breakStaleLck(name string, lease time.Duration) (bool, error) {
+	return bin.BreakStaleLck(d.lckPath(name), lease)
+}
+
+func (d *dir) revs(name string) ([]*bin.Rev, error) {
+	name = d.file(name)
+	return d.jsnDir().Revs(name)
+}
+
+func (d *dir) getRevObj(name string, n int) (map[string]interface{}, error) {
+	name = d.file(name)
+	j, err := d.jsnDir().GetRev(name, n)
+	if err != nil {
+		return nil, err
+	}
+
+	jo, ok := j.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("revision %d of %q is not a json object", n, name)
+	}
+
+	return jo, nil
+}
+
+func (d *dir) restoreRev(name string, n int) error {
+	name = d.file(name)
+	return d.jsnDir().RestoreRev(name, n)
+}
+
+func (d *dir) trashed() ([]*bin.TrashEntry, error) {
+	return d.jsnDir().Trashed()
+}
+
+func (d *dir) untrash(id string) error {
+	return d.jsnDir().Untrash(id)
+}
+
+func (d *dir) purge(id string) error {
+	return d.jsnDir().Purge(id)
+}
+
+func (d *dir) purgeTrash(maxAge time.Duration) error {
+	return d.jsnDir().PurgeTrash(maxAge)
+}
+
+func (d *dir) recover() error {
+	return d.jsnDir().Recover()
+}
+
+type tx jsn.Tx
+
+func (d *dir) begin() *tx {
+	return (*tx)(d.jsnDir().Begin())
+}
+
+func (t *tx) jsnTx() *jsn.Tx {
+	return (*jsn.Tx)(t)
+}
+
+func (t *tx) dir() *dir {
+	d := dir(jsn.Dir(*t.jsnTx().BinTx().Dir()))
+	return &d
+}
+
+func (t *tx) new(name string, j interface{}) error {
+	name = t.dir().file(name)
+	return t.jsnTx().New(name, j)
+}
+
+func (t *tx) over(name string, j interface{}) error {
+	name = t.dir().file(name)
+	return t.jsnTx().Over(name, j)
+}
+
+func (t *tx) up(name string, j interface{}) error {
+	name = t.dir().file(name)
+	return t.jsnTx().Up(name, j)
+}
+
+func (t *tx) upMode(name string, j interface{}, m jsn.MergeMode) error {
+	name = t.dir().file(name)
+	return t.jsnTx().UpMode(name, j, m)
+}
+
+func (t *tx) patch(name string, p jsn.Patch) error {
+	name = t.dir().file(name)
+	return t.jsnTx().Patch(name, p)
+}
+
+func (t *tx) rm(name string) error {
+	name = t.dir().file(name)
+	return t.jsnTx().Rm(name)
+}
+
+func (t *tx) commit() error {
+	return t.jsnTx().Commit()
+}
+
+func (t *tx) rollback() error {
+	return t.jsnTx().Rollback()
+}
+
+// problemInvalidName is the kind of the problem of an instance with an invalid name; see errIfInvalidName.
+const problemInvalidName = "invalid-name"
+
+// fsck checks the bookkeeping files and all the instances of the directory.
+func (d *dir) fsck(lease time.Duration) ([]*bin.Problem, error) {
+	ps, rErr := d.jsnDir().Fsck(lease)
+
+	ns, err := d.jsnDir().All()
+	for _, err := range multierr.Errors(err) {
+		var errIrregular *bin.ErrIrregular
+		if !stdErrors.As(err, &errIrregular) {
+			// Irregular files are already reported by the bin check.
+			rErr = multierr.Append(rErr, err)
+		}
+	}
+
+	for _, n := range ns {
+		if in, ok := d.instName(n); !ok || errIfInvalidName(in) != nil {
+			n := n
+			ps = append(ps, bin.NewProblem(problemInvalidName, d.jsnDir().Path(n), "", func() error {
+				return d.binDir().Quarantine(n)
+			}))
+			continue
+		}
+
+		p, err := d.jsnDir().FsckObj(n)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		} else if p != nil {
+			ps = append(ps, p)
+		}
+	}
+
+	return ps, rErr
+}
+
+var nameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// errIfInvalidName returns an error if name is not a valid instance name; one made of the generated names' alphabet (see genName).
+func errIfInvalidName(name string) error {
+	if !nameRegex.MatchString(name) {
+		return fmt.Errorf("invalid name %q; should only contain letters, digits, '-', and '_'", name)
+	}
+
+	return nil
+}
+
+func (d *dir) getObjETag(name string) (map[string]interface{}, string, error) {
+	name = d.file(name)
+	return d.jsnDir().GetObjETag(name)
+}
+
+func (d *dir) overIfMatch(name string, j interface{}, etag string) error {
+	name = d.file(name)
+	return d.jsnDir().OverIfMatch(name, j, etag)
+}
+
+func (d *dir) upIfMatch(name string, j interface{}, etag string) error {
+	name = d.file(name)
+	return d.jsnDir().UpIfMatch(name, j, etag)
+}
+
+func (d *dir) upModeIfMatch(name string, j interface{}, etag string, m jsn.MergeMode) error {
+	name = d.file(name)
+	return d.jsnDir().UpModeIfMatch(name, j, etag, m)
+}
+
+func (d *dir) patchIfMatch(name string, p jsn.Patch, etag string) error {
+	name = d.file(name)
+	return d.jsnDir().PatchIfMatch(name, p, etag)
+}
+
+func (d *dir) rmIfMatch(name string, etag string) error {
+	name = d.file(name)
+	return d.jsnDir().RmIfMatch(name, etag)
+}
+
+func (d *dir) indexes() ([]*jsn.Index, error) {
+	return d.jsnDir().Indexes()
+}
+
+func (d *dir) createIndex(name string, ref fieldRef) error {
+	return d.jsnDir().CreateIndex(name, jsn.Path(ref))
+}
+
+func (d *dir) createTextIndex(name string, ref fieldRef) error {
+	return d.jsnDir().CreateTextIndex(name, jsn.Path(ref))
+}
+
+func (d *dir) rebuildIndex(name string) error {
+	return d.jsnDir().RebuildIndex(name)
+}
+
+func (d *dir) rmIndex(name string) error {
+	return d.jsnDir().RmIndex(name)
+}
+
+func (d *dir) manifest() (*jsn.Manifest, error) {
+	return d.jsnDir().Manifest()
+}
+
+func (d *dir) addUnique(name string, ref fieldRef) error {
+	return d.jsnDir().AddUnique(name, jsn.Path(ref))
+}
+
+func (d *dir) rmUnique(name string) error {
+	return d.jsnDir().RmUnique(name)
+}
+
+func (d *dir) schema() (*jsn.Schema, error) {
+	return d.jsnDir().Schema()
+}
+
+func (d *dir) updateManifest(f func(m *jsn.Manifest) error) error {
+	return d.jsnDir().UpdateManifest(f)
+}
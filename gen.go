@@ -6,13 +6,93 @@ import (
 	"fmt"
 	"github.com/agcom/dirb/bin"
 	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func newJsnGenName(d *dir, j interface{}) (string, error) {
-	return newJsnGenNameCustom(d, j, 7, 21, 10000)
+	return d.genName(func(name string) error {
+		return d.new(name, j)
+	})
 }
 
-func newJsnGenNameCustom(d *dir, j interface{}, minNameLen, maxNameLen, triesPerLen int) (string, error) {
+// The strategies of generating the names of the created instances; see jsn.Manifest.Names.
+const (
+	// Random names of 7 (or more, on collisions) characters; the default.
+	namesRandom = "random"
+	// Random (version 4) UUIDs.
+	namesUUID = "uuid"
+	// Names which sort (lexically) by their creation time.
+	namesTime = "time"
+)
+
+func errIfInvalidNames(s string) error {
+	switch s {
+	case namesRandom, namesUUID, namesTime:
+		return nil
+	default:
+		return fmt.Errorf("unknown name generation strategy %q; should be %q, %q, or %q", s, namesRandom, namesUUID, namesTime)
+	}
+}
+
+// genName tries creating with generated names (by the strategy of d), until one doesn't already exist.
+func (d *dir) genName(create func(name string) error) (string, error) {
+	switch d.loadedManifest().Names {
+	case namesUUID:
+		return genNameBy(create, genUUID, 100)
+	case namesTime:
+		return genNameBy(create, genTimeName, 10000)
+	default:
+		return genName(create)
+	}
+}
+
+// genName tries creating with random names, until one doesn't already exist.
+func genName(create func(name string) error) (string, error) {
+	return genNameCustom(create, 7, 21, 10000)
+}
+
+// genNameBy tries creating with the names of gen, until one doesn't already exist.
+func genNameBy(create func(name string) error, gen func() string, tries int) (string, error) {
+	for i := 0; i < tries; i++ {
+		name := gen()
+
+		err := create(name)
+		if err != nil {
+			if _, ok := err.(*bin.ErrExists); ok {
+				continue
+			} else {
+				return "", err
+			}
+		}
+
+		return name, nil
+	}
+
+	return "", fmt.Errorf("failed to find a unique name after %v tries", tries)
+}
+
+func genUUID() string {
+	b := make([]byte, 16)
+	_, err := cryptoRand.Read(b)
+	if err != nil {
+		panic(fmt.Errorf("cryptographic random number generator failed; %w", err))
+	}
+
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // Variant 10
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// genTimeName returns the current time (in nanoseconds, base 36, zero-padded to sort lexically), followed by 4 random characters.
+func genTimeName() string {
+	ts := strconv.FormatInt(time.Now().UnixNano(), 36)
+	return strings.Repeat("0", 13-len(ts)) + ts + genNameLen(4)
+}
+
+func genNameCustom(create func(name string) error, minNameLen, maxNameLen, triesPerLen int) (string, error) {
 	if minNameLen > maxNameLen {
 		panic(fmt.Sprintf("the minimum name length %d is more than the maximum name length %d", minNameLen, maxNameLen))
 	} else if triesPerLen <= 0 {
@@ -24,7 +104,7 @@ func newJsnGenNameCustom(d *dir, j interface{}, minNameLen, maxNameLen, triesPer
 		for i := 0; i < triesPerLen; i++ {
 			name = genNameLen(l)
 
-			err := d.new(name, j)
+			err := create(name)
 			if err != nil {
 				if _, ok := err.(*bin.ErrExists); ok {
 					continue
@@ -48,7 +128,7 @@ func genNameLen(l int) string {
 	rnd := make([]byte, int(math.Ceil(float64(l)*6.0/8.0)))
 	_, err := cryptoRand.Read(rnd)
 	if err != nil {
-		panic(fmt.Errorf("cryptocurrency random number generator failed; %w", err))
+		panic(fmt.Errorf("cryptographic random number generator failed; %w", err))
 	}
 
 	return base64.RawURLEncoding.EncodeToString(rnd)[0:l]
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/agcom/dirb/bin"
+	"github.com/agcom/dirb/jsn"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestGenUUID(t *testing.T) {
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		u := genUUID()
+		if !re.MatchString(u) {
+			t.Errorf("got %q; want a version 4 UUID", u)
+		}
+		if seen[u] {
+			t.Errorf("got %q twice", u)
+		}
+		seen[u] = true
+	}
+}
+
+func TestGenTimeName(t *testing.T) {
+	prev := ""
+	for i := 0; i < 100; i++ {
+		n := genTimeName()
+		if len(n) != 17 {
+			t.Errorf("got %q; want 17 characters", n)
+		}
+		// The random suffix may not sort.
+		if n[:13] < prev {
+			t.Errorf("got %q after %q; want it to sort after", n, prev)
+		}
+		prev = n[:13]
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	defer func() {
+		dirr = nil
+		manifests = make(map[string]*jsn.Manifest)
+	}()
+	runTestCmd(t, "init", "--ext", ".js", "--names", "uuid", "-s", "none", "-k", "2", "-d", dir)
+
+	// The defaults of the manifest
+	parseTestArgs(t, "new", "-d", dir)
+	d := newDirOpts(dir, nil)
+	err := d.loadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := d.binDir().Opts(); o.Durability != bin.DurabilityNone || o.History != 2 {
+		t.Errorf("got the durability %v, and history %d; want none, and 2", o.Durability, o.History)
+	}
+
+	name, err := newJsnGenName(d, map[string]interface{}{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f-]{36}$`).MatchString(name) {
+		t.Errorf("got the name %q; want a UUID", name)
+	}
+	_, err = os.Stat(filepath.Join(dir, name+".js"))
+	if err != nil {
+		t.Errorf("the created instance isn't of the extension of the manifest; %v", err)
+	}
+
+	// The flags win.
+	parseTestArgs(t, "new", "-s", "file", "-k=0", "-d", dir)
+	d = newDirOpts(dir, &bin.Opts{Durability: bin.DurabilityFile})
+	err = d.loadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := d.binDir().Opts(); o.Durability != bin.DurabilityFile || o.History != 0 {
+		t.Errorf("got the durability %v, and history %d; want file, and 0", o.Durability, o.History)
+	}
+
+	// Refuses the newer formats
+	err = d.updateManifest(func(m *jsn.Manifest) error {
+		m.Version = jsn.ManifestVersion + 1
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newDirOpts(dir, nil).loadManifest()
+	if err == nil {
+		t.Errorf("loaded a manifest of a newer format version")
+	}
+}
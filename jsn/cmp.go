@@ -0,0 +1,128 @@
+package jsn
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+)
+
+// Cmp orders two jsons; returns -1, 0, or +1 if j1 is less than, equal to, or greater than j2.
+// Numbers are compared by their exact (arbitrary precision) values, strings lexically (byte-wise), and false is less than true; across types, null < boolean < number < string.
+// Objects and arrays have no order; false is returned for them.
+func Cmp(j1, j2 interface{}) (int, bool) {
+	r1, ok := ordRank(j1)
+	if !ok {
+		return 0, false
+	}
+
+	r2, ok := ordRank(j2)
+	if !ok {
+		return 0, false
+	}
+
+	if r1 != r2 {
+		if r1 < r2 {
+			return -1, true
+		} else {
+			return 1, true
+		}
+	}
+
+	switch x := j1.(type) {
+	case bool:
+		y := j2.(bool)
+		if x == y {
+			return 0, true
+		} else if !x {
+			return -1, true
+		} else {
+			return 1, true
+		}
+	case string:
+		return strings.Compare(x, j2.(string)), true
+	case nil:
+		return 0, true
+	default:
+		n1, ok1 := numRat(j1)
+		n2, ok2 := numRat(j2)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+
+		return n1.Cmp(n2), true
+	}
+}
+
+// Equal reports whether two jsons are equal; primitives by Cmp (e.g. 1 and 1.0 are), objects and arrays by their elements.
+func Equal(j1, j2 interface{}) bool {
+	switch x := j1.(type) {
+	case map[string]interface{}:
+		y, ok := j2.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+
+		for k, v1 := range x {
+			v2, ok := y[k]
+			if !ok || !Equal(v1, v2) {
+				return false
+			}
+		}
+
+		return true
+	case []interface{}:
+		y, ok := j2.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+
+		for i := range x {
+			if !Equal(x[i], y[i]) {
+				return false
+			}
+		}
+
+		return true
+	default:
+		c, ok := Cmp(j1, j2)
+		return ok && c == 0
+	}
+}
+
+// Ordered reports whether j has an order (see Cmp); i.e. it's a primitive.
+func Ordered(j interface{}) bool {
+	_, ok := ordRank(j)
+	return ok
+}
+
+// ordRank returns the rank of the type of j in the cross-type order of Cmp; false for objects and arrays.
+func ordRank(j interface{}) (int, bool) {
+	switch j.(type) {
+	case nil:
+		return 0, true
+	case bool:
+		return 1, true
+	case json.Number, float64:
+		return 2, true
+	case string:
+		return 3, true
+	default:
+		return 0, false
+	}
+}
+
+func numRat(j interface{}) (*big.Rat, bool) {
+	switch x := j.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(string(x))
+	case float64:
+		r := new(big.Rat)
+		if r.SetFloat64(x) == nil {
+			return nil, false
+		}
+
+		return r, true
+	default:
+		return nil, false
+	}
+}
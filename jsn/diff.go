@@ -0,0 +1,64 @@
+package jsn
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Change is a difference between two jsons, at Path (a json pointer; see RFC 6901).
+// Op is either "add" (only New is set), "remove" (only Old is set), or "replace".
+type Change struct {
+	Op   string      `json:"op"`
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Diff returns the changes which turn j1 into j2; objects are compared field by field (in the order of the keys), and anything else as a whole.
+func Diff(j1, j2 interface{}) []*Change {
+	return diffRec("", j1, j2, make([]*Change, 0))
+}
+
+func diffRec(path string, j1, j2 interface{}, cs []*Change) []*Change {
+	jo1, ok1 := j1.(map[string]interface{})
+	jo2, ok2 := j2.(map[string]interface{})
+	if !ok1 || !ok2 {
+		if !reflect.DeepEqual(j1, j2) {
+			cs = append(cs, &Change{"replace", path, j1, j2})
+		}
+
+		return cs
+	}
+
+	ks := make([]string, 0, len(jo1)+len(jo2))
+	for k := range jo1 {
+		ks = append(ks, k)
+	}
+	for k := range jo2 {
+		if _, ok := jo1[k]; !ok {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+
+	for _, k := range ks {
+		kPath := path + "/" + EscapePtrToken(k)
+		v1, ok1 := jo1[k]
+		v2, ok2 := jo2[k]
+		if !ok1 {
+			cs = append(cs, &Change{"add", kPath, nil, v2})
+		} else if !ok2 {
+			cs = append(cs, &Change{"remove", kPath, v1, nil})
+		} else {
+			cs = diffRec(kPath, v1, v2, cs)
+		}
+	}
+
+	return cs
+}
+
+// EscapePtrToken escapes s to be used as a reference token of a json pointer (see RFC 6901); '~' and '/' become "~0" and "~1".
+func EscapePtrToken(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
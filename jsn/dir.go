@@ -2,34 +2,48 @@ package jsn
 
 import (
 	"github.com/agcom/dirb/bin"
+	"go.uber.org/multierr"
 	"path/filepath"
 )
 
 type Dir bin.Dir
 
 func NewDir(d string) *Dir {
-	dir := Dir(*bin.NewDir(d))
+	return NewDirOpts(d, nil)
+}
+
+func NewDirOpts(d string, o *bin.Opts) *Dir {
+	dir := Dir(*bin.NewDirOpts(d, o))
 	return &dir
 }
 
 func (d *Dir) New(name string, j interface{}) error {
 	path := d.Path(name)
-	return New(path, j)
+	return NewOpts(path, j, d.BinDir().Opts())
 }
 
 func (d *Dir) Get(name string) (interface{}, error) {
 	path := d.Path(name)
-	return Get(path)
+	return GetOpts(path, d.BinDir().Opts())
+}
+
+func (d *Dir) GetBare(name string) (interface{}, error) {
+	path := d.Path(name)
+	return GetBare(path)
+}
+
+func (d *Dir) RLck(name string) (*bin.RLckTkn, error) {
+	return d.BinDir().RLck(name)
 }
 
 func (d *Dir) Over(name string, j interface{}) error {
 	path := d.Path(name)
-	return Over(path, j)
+	return OverOpts(path, j, d.BinDir().Opts())
 }
 
 func (d *Dir) Rm(name string) error {
 	path := d.Path(name)
-	return Rm(path)
+	return RmOpts(path, d.BinDir().Opts())
 }
 
 func (d *Dir) All() ([]string, error) {
@@ -43,14 +57,51 @@ func (d *Dir) BinDir() *bin.Dir {
 
 func (d *Dir) GetObj(name string) (map[string]interface{}, error) {
 	path := d.Path(name)
-	return GetObj(path)
+	return GetObjOpts(path, d.BinDir().Opts())
+}
+
+func (d *Dir) GetObjBare(name string) (map[string]interface{}, error) {
+	path := d.Path(name)
+	return GetObjBare(path)
 }
 
 func (d *Dir) Up(name string, j interface{}) error {
 	path := d.Path(name)
-	return Up(path, j)
+	return UpOpts(path, j, d.BinDir().Opts())
 }
 
 func (d *Dir) Path(name string) string {
 	return filepath.Join(d.BinDir().Dir(), name)
 }
+
+// Recover is like bin.Dir.Recover, but also recovers the manifest and the indexes; see Manifest and Index.
+func (d *Dir) Recover() error {
+	err := d.BinDir().Recover()
+	err = multierr.Append(err, recoverManifest(d.BinDir().Dir(), d.BinDir().Opts()))
+	return multierr.Append(err, recoverIndexes(d.BinDir().Dir(), d.BinDir().Opts()))
+}
+
+func (d *Dir) GetObjETag(name string) (map[string]interface{}, string, error) {
+	path := d.Path(name)
+	return GetObjETag(path, d.BinDir().Opts())
+}
+
+func (d *Dir) OverIfMatch(name string, j interface{}, etag string) error {
+	path := d.Path(name)
+	return OverIfMatch(path, j, etag, d.BinDir().Opts())
+}
+
+func (d *Dir) UpIfMatch(name string, j interface{}, etag string) error {
+	path := d.Path(name)
+	return UpIfMatch(path, j, etag, d.BinDir().Opts())
+}
+
+func (d *Dir) UpModeIfMatch(name string, j interface{}, etag string, m MergeMode) error {
+	path := d.Path(name)
+	return UpModeIfMatch(path, j, etag, m, d.BinDir().Opts())
+}
+
+func (d *Dir) RmIfMatch(name string, etag string) error {
+	path := d.Path(name)
+	return RmIfMatch(path, etag, d.BinDir().Opts())
+}
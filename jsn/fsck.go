@@ -0,0 +1,73 @@
+package jsn
+
+import (
+	"errors"
+	"fmt"
+	"github.com/agcom/dirb/bin"
+	"go.uber.org/multierr"
+	"time"
+)
+
+// Kinds of the problems found by Dir.FsckObj.
+const (
+	ProblemInvalidJsn = "invalid-json"
+	ProblemNotObj     = "not-object"
+)
+
+// FsckObj checks whether the named binary is a json object; returns nil if it is, or if it doesn't exist.
+// Takes no lock, so it changes nothing (e.g. it doesn't break the stale locks; see bin.Dir.Fsck); the writes replace the binaries atomically anyway, so it never sees a half written one.
+// Repairing the problem quarantines the binary, if it's still broken.
+func (d *Dir) FsckObj(name string) (*bin.Problem, error) {
+	bd := d.BinDir()
+	path := d.Path(name)
+	kind, detail, err := fsckObj(path)
+	if err != nil {
+		var errNotExist *bin.ErrNotExist
+		if errors.As(err, &errNotExist) {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+	if kind == "" {
+		return nil, nil
+	}
+
+	return bin.NewProblem(kind, path, detail, func() error {
+		return writeIndexedLck(path, bd.Opts(), func() error {
+			return bd.QuarantineIf(name, func() (bool, error) {
+				kind, _, err := fsckObj(path)
+				return kind != "", err
+			})
+		})
+	}), nil
+}
+
+// fsckObj checks whether the binary at path is a json object; returns the kind of its problem, and the details, if it's not.
+func fsckObj(path string) (rKind string, rDetail string, rErr error) {
+	r, err := bin.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		err := r.Close()
+		if err != nil {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close binary %q; %w", path, err))
+		}
+	}()
+
+	j, err := ReaderToJsn(r)
+	if err != nil {
+		return ProblemInvalidJsn, err.Error(), nil
+	}
+
+	if _, ok := j.(map[string]interface{}); !ok {
+		return ProblemNotObj, fmt.Sprintf("%s is not a json object", jsnStr(j)), nil
+	}
+
+	return "", "", nil
+}
+
+func (d *Dir) Fsck(lease time.Duration) ([]*bin.Problem, error) {
+	return d.BinDir().Fsck(lease)
+}
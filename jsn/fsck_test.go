@@ -0,0 +1,102 @@
+package jsn
+
+import (
+	"encoding/json"
+	"github.com/agcom/dirb/bin"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFsckObj(t *testing.T) {
+	d := NewDir(t.TempDir())
+	bd := d.BinDir()
+	for n, s := range map[string]string{"a.json": `{"a":1}`, "b.json": `{"b":`, "c.json": `[1]`, "d.json": `{} {}`} {
+		err := bd.New(n, strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A stale lock, which the check should leave for fsck to report.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	err := cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(&bin.LckInfo{Pid: cmd.Process.Pid, Host: host, Acquired: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lckPath := bin.DefLckPath(d.Path("b.json"))
+	err = os.WriteFile(lckPath, b, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n, want := range map[string]string{"a.json": "", "b.json": ProblemInvalidJsn, "c.json": ProblemNotObj, "d.json": ProblemInvalidJsn, "x.json": ""} {
+		p, err := d.FsckObj(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := ""
+		if p != nil {
+			got = p.Kind
+		}
+		if got != want {
+			t.Errorf("%s: got the problem %q; want %q", n, got, want)
+		}
+	}
+
+	_, err = os.Stat(lckPath)
+	if err != nil {
+		t.Errorf("the check broke a stale lock; %v", err)
+	}
+
+	// Quarantine
+	p, err := d.FsckObj("b.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(d.Path("b.json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("the broken json is left behind; %v", err)
+	}
+	b, err = os.ReadFile(filepath.Join(bd.MetaPath("quarantine"), "b.json"))
+	if err != nil || string(b) != `{"b":` {
+		t.Errorf("got %q (%v) in the quarantine; want the broken json", b, err)
+	}
+
+	// Fixed before the repair
+	p, err = d.FsckObj("c.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Over("c.json", map[string]interface{}{"c": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Repair()
+	if err == nil {
+		t.Errorf("quarantined a fixed json")
+	}
+	j, err := d.Get("c.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(j, map[string]interface{}{"c": json.Number("1")}) {
+		t.Errorf("got %s; want the fixed json", jsnStr(j))
+	}
+}
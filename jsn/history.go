@@ -0,0 +1,44 @@
+package jsn
+
+import (
+	"fmt"
+	"github.com/agcom/dirb/bin"
+	"go.uber.org/multierr"
+)
+
+// GetRev reads the nth revision of the json at path; see bin.Rev.
+func GetRev(path string, n int) (rJ interface{}, rErr error) {
+	r, err := bin.OpenRev(path, n)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := r.Close()
+		if err != nil {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close revision %d of %q; %w", n, path, err))
+		}
+	}()
+
+	j, err := ReaderToJsn(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode revision %d of %q into a json; %w", n, path, err)
+	}
+
+	return j, nil
+}
+
+func (d *Dir) Revs(name string) ([]*bin.Rev, error) {
+	return d.BinDir().Revs(name)
+}
+
+func (d *Dir) GetRev(name string, n int) (interface{}, error) {
+	path := d.Path(name)
+	return GetRev(path, n)
+}
+
+// RestoreRev is like bin.Dir.RestoreRev, but also updates the indexes of the restored json; see Index.
+func (d *Dir) RestoreRev(name string, n int) error {
+	return writeIndexedLck(d.Path(name), d.BinDir().Opts(), func() error {
+		return d.BinDir().RestoreRev(name, n)
+	})
+}
@@ -0,0 +1,85 @@
+package jsn
+
+import (
+	"encoding/json"
+	"github.com/agcom/dirb/bin"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	for _, c := range []struct {
+		j1, j2 string
+		want   []*Change
+	}{
+		{`{"a": 1}`, `{"a": 1}`, []*Change{}},
+		{`{"a": 1, "b": 2}`, `{"b": 3, "c": 4}`, []*Change{
+			{"remove", "/a", json.Number("1"), nil},
+			{"replace", "/b", json.Number("2"), json.Number("3")},
+			{"add", "/c", nil, json.Number("4")},
+		}},
+		{`{"a": {"b": [1], "c": 1}}`, `{"a": {"b": [1, 2], "c": 1}}`, []*Change{
+			{"replace", "/a/b", []interface{}{json.Number("1")}, []interface{}{json.Number("1"), json.Number("2")}},
+		}},
+		{`{"a/b": {"~": 1}}`, `{"a/b": {"~": null}}`, []*Change{
+			{"replace", "/a~1b/~0", json.Number("1"), nil},
+		}},
+		{`{"a": 1}`, `[1]`, []*Change{
+			{"replace", "", map[string]interface{}{"a": json.Number("1")}, []interface{}{json.Number("1")}},
+		}},
+	} {
+		j1, err := StrToJsn(c.j1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j2, err := StrToJsn(c.j2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := Diff(j1, j2); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s, %s: got %s; want %s", c.j1, c.j2, jsnStr(got), jsnStr(c.want))
+		}
+	}
+}
+
+func TestRestoreRev(t *testing.T) {
+	d := NewDirOpts(newTestDir(t, 1).BinDir().Dir(), &bin.Opts{History: -1})
+	err := d.Over("0.json", map[string]interface{}{"n": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := d.GetRev("0.json", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(j, map[string]interface{}{"n": json.Number("0")}) {
+		t.Errorf("got %s of revision 1", jsnStr(j))
+	}
+
+	err = d.RestoreRev("0.json", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix := testIndex(t, d, "n")
+	if got := testEq(ix, 0); !reflect.DeepEqual(got, []string{"0.json"}) {
+		t.Errorf("got %q of 0 in the index after the restore; want 0.json", got)
+	}
+	if got := testEq(ix, 5); len(got) != 0 {
+		t.Errorf("got %q of 5 in the index after the restore; want none", got)
+	}
+
+	// Re-created
+	err = d.Rm("0.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.RestoreRev("0.json", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testEq(testIndex(t, d, "n"), 5); !reflect.DeepEqual(got, []string{"0.json"}) {
+		t.Errorf("got %q of 5 in the index after re-creating; want 0.json", got)
+	}
+}
@@ -0,0 +1,950 @@
+package jsn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/agcom/dirb/bin"
+	"go.uber.org/multierr"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Index is a secondary index of the jsons of a directory; maps the (primitive) values at its path, in the order of Cmp, to the names of the jsons holding them.
+// The indexes of a directory are files in its (hidden) index directory (see IndexDir); they're kept up to date by the writes of this package, under the lock of the written json (see writeIndexed).
+// A write appends the entries of its json to the log of the index (see Index.log), instead of rewriting the whole index.
+// A text index maps the words of the strings at its path instead; see Index.Search.
+type Index struct {
+	Name    string        `json:"-"`
+	Path    Path          `json:"path"`
+	Text    bool          `json:"text,omitempty"`
+	Entries []*IndexEntry `json:"entries"`
+	// Lens holds the number of the words of each json; of a text index only.
+	Lens map[string]int `json:"lens,omitempty"`
+}
+
+type IndexEntry struct {
+	Val  interface{} `json:"val"`
+	Name string      `json:"name"`
+	// Freq is how many times the word occurs in the json; of a text index only.
+	Freq int `json:"freq,omitempty"`
+}
+
+// Bound is an end of a range of values; see Index.Range.
+type Bound struct {
+	Val interface{}
+	// Incl tells whether Val itself is within the range.
+	Incl bool
+}
+
+const indexDirName = "index"
+
+// A json whose indexes are not yet updated (e.g. its writer is halfway through) is marked by a lock file (see bin.Lck) of its name in the pending directory; see markPending.
+const pendingDirName = ".pending"
+
+// indexLckTimeout is how long the index operations wait for the lock of an index, or of a json being indexed; they're held for a few file operations only.
+const indexLckTimeout = 30 * time.Second
+
+// IndexDir returns the path of the (hidden) directory which holds the indexes of the jsons of dir.
+func IndexDir(dir string) string {
+	return filepath.Join(dir, bin.MetaDirName, indexDirName)
+}
+
+// Range returns the names of the jsons having a value within the range from lo to hi, in the order of the values; a nil bound means no bound.
+// The values of the bounds should be primitives (see Ordered).
+func (ix *Index) Range(lo, hi *Bound) []string {
+	es := ix.Entries
+	i := 0
+	if lo != nil {
+		i = sort.Search(len(es), func(i int) bool {
+			c, _ := Cmp(es[i].Val, lo.Val)
+			return c > 0 || (c == 0 && lo.Incl)
+		})
+	}
+
+	j := len(es)
+	if hi != nil {
+		j = sort.Search(len(es), func(i int) bool {
+			c, _ := Cmp(es[i].Val, hi.Val)
+			return c > 0 || (c == 0 && !hi.Incl)
+		})
+	}
+
+	ns := make([]string, 0)
+	seen := make(map[string]bool)
+	for ; i < j; i++ {
+		n := es[i].Name
+		if !seen[n] {
+			seen[n] = true
+			ns = append(ns, n)
+		}
+	}
+
+	return ns
+}
+
+// indexable is the content of a json to be indexed; ok is false if it has nothing to be indexed (e.g. it's removed).
+type indexable struct {
+	j  interface{}
+	ok bool
+}
+
+// indexLogRec is a record of the log of an index (see Index.log); it replaces the entries of json Name.
+type indexLogRec struct {
+	Name    string        `json:"name"`
+	Entries []*IndexEntry `json:"entries"`
+	// Len is the number of the words of the json; of a text index only.
+	Len int `json:"len,omitempty"`
+}
+
+// set replaces the entries of the given jsons (by their names) with the ones of their contents.
+func (ix *Index) set(js map[string]*indexable) {
+	ix.apply(ix.recs(js))
+}
+
+// recs returns the records of the entries of the given contents of the jsons (by their names), in the order of their names.
+func (ix *Index) recs(js map[string]*indexable) []*indexLogRec {
+	rs := make([]*indexLogRec, 0, len(js))
+	for n, j := range js {
+		r := &indexLogRec{Name: n, Entries: []*IndexEntry{}}
+		rs = append(rs, r)
+		if !j.ok {
+			continue
+		}
+
+		if ix.Text {
+			r.Entries, r.Len = ix.textEntries(n, j.j)
+			continue
+		}
+
+		for _, v := range ix.Path.Values(j.j) {
+			if Ordered(v) {
+				r.Entries = append(r.Entries, &IndexEntry{Val: v, Name: n})
+			}
+		}
+	}
+
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Name < rs[j].Name
+	})
+
+	return rs
+}
+
+// apply replaces the entries of the jsons of records rs (of distinct names) with theirs.
+func (ix *Index) apply(rs []*indexLogRec) {
+	ns := make(map[string]bool, len(rs))
+	for _, r := range rs {
+		ns[r.Name] = true
+	}
+
+	es := make([]*IndexEntry, 0, len(ix.Entries))
+	for _, e := range ix.Entries {
+		if !ns[e.Name] {
+			es = append(es, e)
+		}
+	}
+
+	for _, r := range rs {
+		if ix.Text {
+			delete(ix.Lens, r.Name)
+			if r.Len > 0 {
+				if ix.Lens == nil {
+					ix.Lens = make(map[string]int)
+				}
+				ix.Lens[r.Name] = r.Len
+			}
+		}
+
+		for _, e := range r.Entries {
+			e.Name = r.Name
+			es = append(es, e)
+		}
+	}
+
+	sort.SliceStable(es, func(i, j int) bool {
+		c, _ := Cmp(es[i].Val, es[j].Val)
+		return c < 0 || (c == 0 && es[i].Name < es[j].Name)
+	})
+
+	// Drop the duplicates, e.g. of a json holding a value twice in an array.
+	ues := es[:0]
+	for _, e := range es {
+		if l := len(ues); l > 0 && ues[l-1].Name == e.Name {
+			if c, _ := Cmp(ues[l-1].Val, e.Val); c == 0 {
+				continue
+			}
+		}
+		ues = append(ues, e)
+	}
+
+	ix.Entries = ues
+}
+
+// indexLogPath returns the path of the log of the index at ixPath; see Index.log.
+func indexLogPath(ixPath string) string {
+	dir, name := filepath.Split(ixPath)
+	return filepath.Join(dir, "."+name+".log")
+}
+
+// log appends the records of the given contents of the jsons (by their names) to the log of ix (a json per line), which is replayed over ix by its readers (see readIndex); the caller should hold the lock of ix.
+// Does nothing if ix doesn't exist (e.g. it's just removed). The log is merged into ix once it outgrows it; so a write costs as much as its entries, in the long run.
+func (ix *Index) log(dir string, js map[string]*indexable, o *bin.Opts) error {
+	ixPath := filepath.Join(IndexDir(dir), ix.Name)
+	ixInfo, err := os.Stat(ixPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else {
+			return fmt.Errorf("failed to stat %q; %w", ixPath, err)
+		}
+	}
+
+	var b bytes.Buffer
+	for i, r := range ix.recs(js) {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+
+		rb, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("failed to encode a record of index %q; %w", ixPath, err)
+		}
+		b.Write(rb)
+	}
+
+	logPath := indexLogPath(ixPath)
+	err = bin.AppendLinesBareOpts(logPath, b.Bytes(), o)
+	if err != nil {
+		return err
+	}
+
+	logInfo, err := os.Stat(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %q; %w", logPath, err)
+	}
+	if logInfo.Size() <= ixInfo.Size() {
+		return nil
+	}
+
+	// Merge
+	return updateIndexBare(ixPath, o, func(*Index) error {
+		return nil
+	})
+}
+
+// indexOpts returns the options of the operations on the indexes (and the other metadata files, e.g. the manifest); as o, but without keeping their history or trash, nor journaling their writes (see bin.Opts.NoJournal), and always waiting for the locks (for at most indexLckTimeout, if o doesn't say otherwise).
+func indexOpts(o *bin.Opts) *bin.Opts {
+	ixO := &bin.Opts{Wait: &bin.WaitOpts{Timeout: indexLckTimeout}, Durability: bin.DurabilityFileDir, NoJournal: true}
+	if o != nil {
+		ixO.Durability = o.Durability
+		ixO.FS = o.FS
+		if o.Wait != nil {
+			ixO.Wait = o.Wait
+		}
+	}
+
+	return ixO
+}
+
+// indexNames returns the names of the indexes of dir.
+func indexNames(dir string) ([]string, error) {
+	ixDir := IndexDir(dir)
+	es, err := os.ReadDir(ixDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		} else {
+			return nil, fmt.Errorf("failed to read directory entries of %q; %w", ixDir, err)
+		}
+	}
+
+	ns := make([]string, 0, len(es))
+	for _, e := range es {
+		if !strings.HasPrefix(e.Name(), ".") && e.Type().IsRegular() {
+			ns = append(ns, e.Name())
+		}
+	}
+
+	return ns, nil
+}
+
+func readIndex(ixPath string) (rIx *Index, rErr error) {
+	f, err := bin.Open(ixPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close index %q; %w", ixPath, err))
+		}
+	}()
+
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	ix := &Index{}
+	err = dec.Decode(ix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode index %q; %w", ixPath, err)
+	}
+	ix.Name = filepath.Base(ixPath)
+
+	// The records of the same json replace each other; see Index.log.
+	rs := make(map[string]*indexLogRec)
+	logPath := indexLogPath(ixPath)
+	err = bin.ReadLines(logPath, func(b []byte) error {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		r := &indexLogRec{}
+		if dec.Decode(r) != nil {
+			// Torn
+			return nil
+		}
+
+		rs[r.Name] = r
+		return nil
+	})
+	if err != nil {
+		var errNotExist *bin.ErrNotExist
+		if !errors.As(err, &errNotExist) {
+			return nil, fmt.Errorf("failed to read the log of index %q; %w", ixPath, err)
+		}
+	}
+
+	rl := make([]*indexLogRec, 0, len(rs))
+	for _, r := range rs {
+		rl = append(rl, r)
+	}
+	ix.apply(rl)
+
+	return ix, nil
+}
+
+// Indexes returns the indexes of dir; each is read under a shared lock (see bin.RLckTkn).
+func Indexes(dir string, o *bin.Opts) ([]*Index, error) {
+	ns, err := indexNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	o = indexOpts(o)
+	ixs := make([]*Index, 0, len(ns))
+	var rErr error
+	for _, n := range ns {
+		ix, err := readIndexRLck(filepath.Join(IndexDir(dir), n), o)
+		if err != nil {
+			var errNotExist *bin.ErrNotExist
+			if !errors.As(err, &errNotExist) {
+				// Not just removed
+				rErr = multierr.Append(rErr, err)
+			}
+		} else {
+			ixs = append(ixs, ix)
+		}
+	}
+
+	return ixs, rErr
+}
+
+func readIndexRLck(ixPath string, o *bin.Opts) (rIx *Index, rErr error) {
+	tkn, err := bin.RLckOpts(ixPath, o)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := tkn.Unlck()
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	return readIndex(ixPath)
+}
+
+// updateIndex applies f on the index at ixPath, while holding its lock; does nothing if the index doesn't exist (e.g. it's just removed).
+func updateIndex(ixPath string, o *bin.Opts, f func(ix *Index) error) error {
+	return withIndexLck(ixPath, o, func() error {
+		return updateIndexBare(ixPath, o, f)
+	})
+}
+
+// withIndexLck runs f while holding the lock of the index at ixPath.
+func withIndexLck(ixPath string, o *bin.Opts, f func() error) (rErr error) {
+	lckPath := bin.DefLckPath(ixPath)
+	lckFile, err := bin.WLck(lckPath, o)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := bin.Unlck(lckPath, lckFile)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	return f()
+}
+
+// updateIndexBare is like updateIndex, but for when the caller already holds the lock of the index; rewrites the whole index, merging its log into it (see Index.log).
+func updateIndexBare(ixPath string, o *bin.Opts, f func(ix *Index) error) error {
+	ix, err := readIndex(ixPath)
+	if err != nil {
+		var errNotExist *bin.ErrNotExist
+		if errors.As(err, &errNotExist) {
+			return nil
+		} else {
+			return err
+		}
+	}
+
+	err = f(ix)
+	if err != nil {
+		return err
+	}
+
+	err = bin.OverBareOpts(ixPath, jsnToReader(ix), o)
+	if err != nil {
+		return err
+	}
+
+	// If it's left behind, it's just replayed again.
+	return rmIndexLog(ixPath)
+}
+
+// rmIndexLog removes the log of the index at ixPath, if any.
+func rmIndexLog(ixPath string) error {
+	logPath := indexLogPath(ixPath)
+	err := os.Remove(logPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove the log %q of index %q; %w", logPath, ixPath, err)
+	}
+
+	return nil
+}
+
+// rmIndex removes the index at ixPath, along with its log.
+func rmIndex(ixPath string, o *bin.Opts) error {
+	return withIndexLck(ixPath, o, func() error {
+		err := bin.RmBareOpts(ixPath, o)
+		if err != nil {
+			return err
+		}
+
+		return rmIndexLog(ixPath)
+	})
+}
+
+// readIndexable reads the json at path for indexing; it has nothing to be indexed if it doesn't exist, or it's not a json.
+func readIndexable(path string) (rJ *indexable, rErr error) {
+	r, err := bin.Open(path)
+	if err != nil {
+		var errNotExist *bin.ErrNotExist
+		if errors.As(err, &errNotExist) {
+			return &indexable{}, nil
+		} else {
+			return nil, err
+		}
+	}
+	defer func() {
+		err := r.Close()
+		if err != nil {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close binary %q; %w", path, err))
+		}
+	}()
+
+	j, err := ReaderToJsn(r)
+	if err != nil {
+		return &indexable{}, nil
+	}
+
+	return &indexable{j, true}, nil
+}
+
+// reindex updates the entries of the json at path in all the indexes of its directory; the caller should hold the lock of path, and the ones of the held indexes (by their names).
+func reindex(path string, o *bin.Opts, held map[string]bool) error {
+	dir, name := filepath.Split(path)
+	ns, err := indexNames(dir)
+	if err != nil || len(ns) == 0 {
+		return err
+	}
+
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	j, err := readIndexable(path)
+	if err != nil {
+		return err
+	}
+
+	o = indexOpts(o)
+	js := map[string]*indexable{name: j}
+	var rErr error
+	for _, n := range ns {
+		ixPath := filepath.Join(IndexDir(dir), n)
+		ix := m.indexHeader(n)
+		if ix == nil {
+			// Not declared; its path is only known by reading it.
+			update := updateIndex
+			if held[n] {
+				update = updateIndexBare
+			}
+			rErr = multierr.Append(rErr, update(ixPath, o, func(ix *Index) error {
+				ix.set(js)
+				return nil
+			}))
+		} else if held[n] {
+			rErr = multierr.Append(rErr, ix.log(dir, js, o))
+		} else {
+			rErr = multierr.Append(rErr, withIndexLck(ixPath, o, func() error {
+				return ix.log(dir, js, o)
+			}))
+		}
+	}
+
+	if rErr != nil {
+		return fmt.Errorf("failed to update the indexes of %q; %w", path, rErr)
+	}
+
+	return nil
+}
+
+// reindexLck is like reindex, but acquires the lock of path itself.
+func reindexLck(path string, o *bin.Opts) (rErr error) {
+	o = indexOpts(o)
+	lckPath := bin.DefLckPath(path)
+	lckFile, err := bin.WLck(lckPath, o)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := bin.Unlck(lckPath, lckFile)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	return reindex(path, o, nil)
+}
+
+// markPending marks the json at path as pending (see pendingDirName), if its directory has any index; returns the function which removes the mark.
+// If the json is already marked by another live process, it's left as is; the other one reindexes it anyway.
+func markPending(path string) (func() error, error) {
+	dir, name := filepath.Split(path)
+	ns, err := indexNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(ns) == 0 {
+		return func() error { return nil }, nil
+	}
+
+	pDir := filepath.Join(IndexDir(dir), pendingDirName)
+	err = os.MkdirAll(pDir, 0775)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create directory %q (or one of its parents); %w", pDir, err)
+	}
+
+	mPath := filepath.Join(pDir, name)
+	mFile, err := bin.Lck(mPath)
+	if err != nil {
+		var errLcked *bin.ErrLcked
+		if errors.As(err, &errLcked) {
+			return func() error { return nil }, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return func() error {
+		return bin.Unlck(mPath, mFile)
+	}, nil
+}
+
+// writeIndexed runs w, a (bare) write of j (the new content of the json at path), and updates the indexes of its directory after; the caller should hold the lock of path.
+// The json is marked pending in between (see markPending); so if the process dies halfway through, Recover updates the indexes instead.
+// Fails, before writing, with an ErrSchema if j doesn't conform to the schema of the directory, or with an ErrUnique if it violates a unique constraint of it; see Manifest.
+func writeIndexed(path string, j *indexable, o *bin.Opts, w func() error) (rErr error) {
+	dir, name := filepath.Split(path)
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	err = m.validate(path, j)
+	if err != nil {
+		return err
+	}
+
+	unmark, err := markPending(path)
+	if err != nil {
+		return err
+	}
+
+	uixs, unlck, err := lckUnique(dir, m, o)
+	if err != nil {
+		return multierr.Append(err, unmark())
+	}
+	defer func() {
+		rErr = multierr.Append(rErr, unlck())
+	}()
+
+	err = checkUnique(dir, uixs, map[string]*indexable{name: j})
+	if err != nil {
+		return multierr.Append(err, unmark())
+	}
+
+	// Reindex even if the write fails; it may have failed after changing the json (e.g. on syncing the directory).
+	err = w()
+	ixErr := reindex(path, o, indexNameSet(uixs))
+	if ixErr != nil {
+		// Left pending
+		return multierr.Append(err, ixErr)
+	}
+
+	return multierr.Append(err, unmark())
+}
+
+// writeIndexedLck is like writeIndexed, but for a write which acquires the lock of path itself; reindexes after it, under the lock.
+func writeIndexedLck(path string, o *bin.Opts, w func() error) error {
+	unmark, err := markPending(path)
+	if err != nil {
+		return err
+	}
+
+	err = w()
+	ixErr := reindexLck(path, o)
+	if ixErr != nil {
+		// Left pending
+		return multierr.Append(err, ixErr)
+	}
+
+	return multierr.Append(err, unmark())
+}
+
+// CreateIndex creates index name of the jsons of dir, on the values at path p, and declares it in the manifest of dir; fails with a bin.ErrExists if it already exists.
+func CreateIndex(dir string, name string, p Path, o *bin.Opts) error {
+	return createDeclIndex(dir, &IndexDecl{name, p, false}, o)
+}
+
+// CreateTextIndex is like CreateIndex, but creates a text index; see Index.Search.
+func CreateTextIndex(dir string, name string, p Path, o *bin.Opts) error {
+	return createDeclIndex(dir, &IndexDecl{name, p, true}, o)
+}
+
+func createDeclIndex(dir string, d *IndexDecl, o *bin.Opts) error {
+	err := UpdateManifest(dir, o, func(m *Manifest) error {
+		if m.index(d.Name) != nil || m.unique(d.Name) != nil {
+			return bin.NewErrExists(filepath.Join(IndexDir(dir), d.Name))
+		}
+
+		m.Indexes = append(m.Indexes, d)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = createIndex(dir, d.Name, d.index(), o)
+	if err != nil {
+		return multierr.Append(err, rmIndexDecl(dir, d.Name, o))
+	}
+
+	return nil
+}
+
+// index returns an empty index of d.
+func (d *IndexDecl) index() *Index {
+	if d.Text {
+		return &Index{Path: d.Path, Text: true, Entries: []*IndexEntry{}, Lens: map[string]int{}}
+	}
+
+	return &Index{Path: d.Path, Entries: []*IndexEntry{}}
+}
+
+func rmIndexDecl(dir string, name string, o *bin.Opts) error {
+	return UpdateManifest(dir, o, func(m *Manifest) error {
+		ds := make([]*IndexDecl, 0, len(m.Indexes))
+		for _, d := range m.Indexes {
+			if d.Name != name {
+				ds = append(ds, d)
+			}
+		}
+
+		m.Indexes = ds
+		return nil
+	})
+}
+
+func createIndex(dir string, name string, ix *Index, o *bin.Opts) error {
+	ixDir := IndexDir(dir)
+	err := os.MkdirAll(ixDir, 0775)
+	if err != nil {
+		return fmt.Errorf("failed to create directory %q (or one of its parents); %w", ixDir, err)
+	}
+
+	// Created empty first, so the writes from now on keep it up to date; see fillIndex.
+	ixPath := filepath.Join(ixDir, name)
+	ixO := indexOpts(o)
+	err = withIndexLck(ixPath, ixO, func() error {
+		err := bin.NewBareOpts(ixPath, jsnToReader(ix), ixO)
+		if err != nil {
+			return err
+		}
+
+		// Of a removed index of the same name
+		return rmIndexLog(ixPath)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = fillIndex(dir, ixPath, o)
+	if err != nil {
+		// A half-filled index misses some jsons; don't leave it behind.
+		return multierr.Append(err, rmIndex(ixPath, ixO))
+	}
+
+	return nil
+}
+
+// RebuildIndex re-indexes all the jsons of dir into index name; e.g. for when it's missing the writes of a crashed process (which is not yet recovered).
+// A declared index (see Manifest) is created if it's missing; then, the one of a unique constraint fails with an ErrUnique if the jsons violate the constraint.
+func RebuildIndex(dir string, name string, o *bin.Opts) error {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	d, u := m.index(name), m.unique(name)
+	if u != nil {
+		d = &IndexDecl{u.Name, u.Path, false}
+	}
+
+	ixPath := filepath.Join(IndexDir(dir), name)
+	err = bin.ErrIfNotExist(ixPath)
+	if err != nil {
+		var errNotExist *bin.ErrNotExist
+		if d == nil || !errors.As(err, &errNotExist) {
+			return err
+		}
+		err = createIndex(dir, name, d.index(), o)
+	} else {
+		err = fillIndex(dir, ixPath, o)
+	}
+	if err != nil || u == nil {
+		return err
+	}
+
+	return checkUniqueIndex(dir, name, o)
+}
+
+// RmIndex removes index name of the jsons of dir, along with its declaration; the index of a unique constraint is removed along with it only (see RmUnique).
+func RmIndex(dir string, name string, o *bin.Opts) error {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	if m.unique(name) != nil {
+		return fmt.Errorf("index %q enforces unique constraint %q; remove the constraint instead", name, name)
+	}
+
+	err = rmIndex(filepath.Join(IndexDir(dir), name), indexOpts(o))
+	if err != nil {
+		var errNotExist *bin.ErrNotExist
+		if m.index(name) == nil || !errors.As(err, &errNotExist) {
+			return err
+		}
+	}
+
+	if m.index(name) == nil {
+		return nil
+	}
+
+	return rmIndexDecl(dir, name, o)
+}
+
+// fillIndex indexes all the jsons of dir into the index at ixPath, while pinning them (see bin.RLckTkn); so any other write is either seen by it, or updates the index after it.
+func fillIndex(dir string, ixPath string, o *bin.Opts) (rErr error) {
+	o = indexOpts(o)
+
+	// The writes in progress may have missed the index; wait for them to finish.
+	err := awaitWriters(dir, o)
+	if err != nil {
+		return err
+	}
+
+	ns, err := bin.NewDir(dir).All()
+	for _, err := range multierr.Errors(err) {
+		var errIrregular *bin.ErrIrregular
+		if !errors.As(err, &errIrregular) {
+			rErr = multierr.Append(rErr, err)
+		}
+	}
+	if rErr != nil {
+		return rErr
+	}
+
+	tkns := make([]*bin.RLckTkn, 0, len(ns))
+	defer func() {
+		for _, tkn := range tkns {
+			err := tkn.Unlck()
+			if err != nil {
+				rErr = multierr.Append(rErr, err)
+			}
+		}
+	}()
+
+	js := make(map[string]*indexable, len(ns))
+	for _, n := range ns {
+		path := filepath.Join(dir, n)
+		tkn, err := bin.RLckOpts(path, o)
+		if err != nil {
+			var errNotExist *bin.ErrNotExist
+			if errors.As(err, &errNotExist) {
+				// Just removed
+				continue
+			}
+			return err
+		}
+		tkns = append(tkns, tkn)
+
+		js[n], err = readIndexable(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return updateIndex(ixPath, o, func(ix *Index) error {
+		// Drop the entries of the removed jsons; keep the ones of the jsons created since the listing, as their writers have updated the index.
+		for _, e := range ix.Entries {
+			if _, ok := js[e.Name]; ok {
+				continue
+			}
+
+			path := filepath.Join(dir, e.Name)
+			err := bin.ErrIfNotExist(path)
+			if err != nil {
+				var errNotExist *bin.ErrNotExist
+				if !errors.As(err, &errNotExist) {
+					return err
+				}
+				js[e.Name] = &indexable{}
+			}
+		}
+
+		ix.set(js)
+		return nil
+	})
+}
+
+var lckFileRegex = regexp.MustCompile(`^\.(.+)\.lck\.tmp$`)
+
+// awaitWriters waits for the writers in progress in dir to release their locks; see bin.DefLckPath.
+func awaitWriters(dir string, o *bin.Opts) error {
+	es, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory entries of %q; %w", dir, err)
+	}
+
+	var rErr error
+	for _, e := range es {
+		if !lckFileRegex.MatchString(e.Name()) {
+			continue
+		}
+
+		lckPath := filepath.Join(dir, e.Name())
+		lckFile, err := bin.LckWait(lckPath, o.Wait)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+			continue
+		}
+
+		rErr = multierr.Append(rErr, bin.Unlck(lckPath, lckFile))
+	}
+
+	return rErr
+}
+
+// recoverIndexes recovers the writes of the indexes of dir (see bin.Recover), and updates them for the pending jsons of the dead processes; see markPending.
+func recoverIndexes(dir string, o *bin.Opts) error {
+	ixDir := IndexDir(dir)
+	_, err := os.Stat(ixDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else {
+			return fmt.Errorf("failed to stat %q; %w", ixDir, err)
+		}
+	}
+
+	rErr := bin.RecoverOpts(ixDir, indexOpts(o))
+
+	pDir := filepath.Join(ixDir, pendingDirName)
+	es, err := os.ReadDir(pDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to read directory entries of %q; %w", pDir, err))
+		}
+		return rErr
+	}
+
+	for _, e := range es {
+		n := e.Name()
+		if strings.HasPrefix(n, ".") {
+			// Bookkeeping
+			continue
+		}
+
+		mPath := filepath.Join(pDir, n)
+		info, err := bin.ReadLck(mPath)
+		if err != nil {
+			var errNotExist *bin.ErrNotExist
+			if !errors.As(err, &errNotExist) {
+				rErr = multierr.Append(rErr, err)
+			}
+			continue
+		}
+		if !info.Stale(0) {
+			// Its owner is at work.
+			continue
+		}
+
+		err = reindexLck(filepath.Join(dir, n), o)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+			continue
+		}
+
+		_, err = bin.BreakStaleLck(mPath, 0)
+		rErr = multierr.Append(rErr, err)
+	}
+
+	return rErr
+}
+
+func (d *Dir) Indexes() ([]*Index, error) {
+	return Indexes(d.BinDir().Dir(), d.BinDir().Opts())
+}
+
+func (d *Dir) CreateIndex(name string, p Path) error {
+	return CreateIndex(d.BinDir().Dir(), name, p, d.BinDir().Opts())
+}
+
+func (d *Dir) CreateTextIndex(name string, p Path) error {
+	return CreateTextIndex(d.BinDir().Dir(), name, p, d.BinDir().Opts())
+}
+
+func (d *Dir) RebuildIndex(name string) error {
+	return RebuildIndex(d.BinDir().Dir(), name, d.BinDir().Opts())
+}
+
+func (d *Dir) RmIndex(name string) error {
+	return RmIndex(d.BinDir().Dir(), name, d.BinDir().Opts())
+}
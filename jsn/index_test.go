@@ -0,0 +1,160 @@
+package jsn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestDir returns a directory of n jsons, named "0.json", "1.json", and so on, each holding its number at "n"; indexed by index "n" on it.
+func newTestDir(t *testing.T, n int) *Dir {
+	t.Helper()
+	d := NewDir(t.TempDir())
+	for i := 0; i < n; i++ {
+		err := d.New(fmt.Sprint(i, ".json"), map[string]interface{}{"n": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := d.CreateIndex("n", Path{{Key: "n"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// testIndex returns index name of d.
+func testIndex(t *testing.T, d *Dir, name string) *Index {
+	t.Helper()
+	ixs, err := d.Indexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ix := range ixs {
+		if ix.Name == name {
+			return ix
+		}
+	}
+
+	t.Fatalf("no index %q", name)
+	return nil
+}
+
+// testEq returns the names of the jsons of index ix holding (numerically) v.
+func testEq(ix *Index, v int) []string {
+	b := &Bound{json.Number(fmt.Sprint(v)), true}
+	return ix.Range(b, b)
+}
+
+func TestIndexLog(t *testing.T) {
+	const n = 100
+	d := newTestDir(t, n)
+	ixPath := filepath.Join(IndexDir(d.BinDir().Dir()), "n")
+
+	rewrites := 0
+	prev, err := os.Stat(ixPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2*n; i++ {
+		err := d.Over("0.json", map[string]interface{}{"n": n + i})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(ixPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(prev, info) {
+			rewrites++
+		}
+		prev = info
+	}
+
+	// The log is merged once it outgrows the index; not on every write.
+	if rewrites == 0 || rewrites > 10 {
+		t.Errorf("rewrote the index %d times on %d writes", rewrites, 2*n)
+	}
+
+	ix := testIndex(t, d, "n")
+	if got := testEq(ix, 3*n-1); !reflect.DeepEqual(got, []string{"0.json"}) {
+		t.Errorf("got %q; want the last value of \"0.json\"", got)
+	}
+	for _, v := range []int{0, n, 3*n - 2} {
+		if got := testEq(ix, v); len(got) != 0 {
+			t.Errorf("got %q of an overwritten value %d", got, v)
+		}
+	}
+	if len(ix.Entries) != n {
+		t.Errorf("got %d entries; want %d", len(ix.Entries), n)
+	}
+}
+
+func TestIndexTornLog(t *testing.T) {
+	d := newTestDir(t, 10)
+	ixPath := filepath.Join(IndexDir(d.BinDir().Dir()), "n")
+
+	// As if a writer crashed halfway through an append.
+	f, err := os.OpenFile(indexLogPath(ixPath), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString(`{"name":"1.json","entries":[{"val":5`)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testEq(testIndex(t, d, "n"), 1); !reflect.DeepEqual(got, []string{"1.json"}) {
+		t.Errorf("got %q; want the torn record skipped", got)
+	}
+
+	err = d.Over("2.json", map[string]interface{}{"n": 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix := testIndex(t, d, "n")
+	if got := testEq(ix, 20); !reflect.DeepEqual(got, []string{"2.json"}) {
+		t.Errorf("got %q; want the record appended after the torn one", got)
+	}
+	if got := testEq(ix, 1); !reflect.DeepEqual(got, []string{"1.json"}) {
+		t.Errorf("got %q; want the torn record skipped", got)
+	}
+}
+
+func TestRmIndexLog(t *testing.T) {
+	d := newTestDir(t, 10)
+	ixPath := filepath.Join(IndexDir(d.BinDir().Dir()), "n")
+
+	err := d.Over("1.json", map[string]interface{}{"n": 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.RmIndex("n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(indexLogPath(ixPath))
+	if !os.IsNotExist(err) {
+		t.Errorf("the log of a removed index is left behind; %v", err)
+	}
+
+	// On another path, so a replayed log would show.
+	err = d.CreateIndex("n", Path{{Key: "m"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if es := testIndex(t, d, "n").Entries; len(es) != 0 {
+		t.Errorf("got %d entries in an index of a missing field", len(es))
+	}
+}
@@ -11,22 +11,45 @@ import (
 )
 
 func New(path string, j interface{}) error {
-	r, w := io.Pipe()
+	return NewOpts(path, j, nil)
+}
 
-	enc := json.NewEncoder(w)
-	enc.SetEscapeHTML(false)
-	go func() {
-		err := enc.Encode(j)
+func NewOpts(path string, j interface{}, o *bin.Opts) error {
+	// Early existence check (not vital)
+	err := bin.ErrIfExists(path)
+	if err != nil {
+		return err
+	}
+
+	return writeLck(path, "", o, func() (*indexable, error) {
+		return &indexable{j, true}, nil
+	}, func(j *indexable) error {
+		return bin.NewBareOpts(path, jsnToReader(j.j), o)
+	})
+}
+
+func Get(path string) (interface{}, error) {
+	return GetOpts(path, nil)
+}
+
+// GetOpts reads the json at path under a shared lock; see bin.RLckTkn.
+func GetOpts(path string, o *bin.Opts) (rJ interface{}, rErr error) {
+	tkn, err := bin.RLckOpts(path, o)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := tkn.Unlck()
 		if err != nil {
-			err = fmt.Errorf("failed to encode %v into a json; %w", j, err)
+			rErr = multierr.Append(rErr, err)
 		}
-		_ = w.CloseWithError(err)
 	}()
 
-	return bin.New(path, r)
+	return GetBare(path)
 }
 
-func Get(path string) (rJ interface{}, rErr error) {
+// GetBare reads the json at path without any locking; for when the caller already holds a (shared or exclusive) lock.
+func GetBare(path string) (rJ interface{}, rErr error) {
 	r, err := bin.Open(path)
 	if err != nil {
 		return nil, err
@@ -46,20 +69,107 @@ func Get(path string) (rJ interface{}, rErr error) {
 	return j, nil
 }
 
+// GetETag is like GetOpts, but also returns the version token of the json; see bin.ETagOf.
+func GetETag(path string, o *bin.Opts) (interface{}, string, error) {
+	b, etag, err := bin.ReadAllETag(path, o)
+	if err != nil {
+		return nil, "", err
+	}
+
+	j, err := ByteSliceToJsn(b)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode %q into a json; %w", path, err)
+	}
+
+	return j, etag, nil
+}
+
 func Over(path string, j interface{}) error {
-	return bin.Over(path, jsnToReader(j))
+	return OverOpts(path, j, nil)
+}
+
+func OverOpts(path string, j interface{}, o *bin.Opts) error {
+	return OverIfMatch(path, j, "", o)
+}
+
+// OverIfMatch is like OverOpts, but fails with a bin.ErrMismatch if the version token of the json is not etag (unless it's empty).
+func OverIfMatch(path string, j interface{}, etag string, o *bin.Opts) error {
+	// Early existence check (not vital)
+	err := bin.ErrIfNotExist(path)
+	if err != nil {
+		return err
+	}
+
+	return writeLck(path, etag, o, func() (*indexable, error) {
+		return &indexable{j, true}, nil
+	}, func(j *indexable) error {
+		return bin.OverBareOpts(path, jsnToReader(j.j), o)
+	})
 }
 
 func Rm(path string) error {
-	return bin.Rm(path)
+	return RmOpts(path, nil)
+}
+
+func RmOpts(path string, o *bin.Opts) error {
+	return RmIfMatch(path, "", o)
+}
+
+// RmIfMatch is like RmOpts, but fails with a bin.ErrMismatch if the version token of the json is not etag (unless it's empty).
+func RmIfMatch(path string, etag string, o *bin.Opts) error {
+	// Early existence check (not vital)
+	err := bin.ErrIfNotExist(path)
+	if err != nil {
+		return err
+	}
+
+	return writeLck(path, etag, o, func() (*indexable, error) {
+		return &indexable{}, nil
+	}, func(*indexable) error {
+		return bin.RmBareOpts(path, o)
+	})
+}
+
+// Up applies j on the json at path as a merge patch; see MergePatch.
+func Up(path string, j interface{}) error {
+	return UpOpts(path, j, nil)
+}
+
+func UpOpts(path string, j interface{}, o *bin.Opts) error {
+	return UpIfMatch(path, j, "", o)
+}
+
+// UpIfMatch is like UpOpts, but fails with a bin.ErrMismatch if the version token of the json is not etag (unless it's empty).
+func UpIfMatch(path string, j interface{}, etag string, o *bin.Opts) error {
+	return UpModeIfMatch(path, j, etag, MergeModePatch, o)
 }
 
-func Up(path string, j interface{}) (rErr error) {
+// UpModeIfMatch is like UpIfMatch, but merges j into the json by mode m.
+func UpModeIfMatch(path string, j interface{}, etag string, m MergeMode, o *bin.Opts) error {
 	// Early existence check (not vital)
 	err := bin.ErrIfNotExist(path)
+	if err != nil {
+		return err
+	}
 
+	return writeLck(path, etag, o, func() (*indexable, error) {
+		jOld, err := GetBare(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return &indexable{m.Merge(jOld, j), true}, nil
+	}, func(j *indexable) error {
+		return bin.OverBareOpts(path, jsnToReader(j.j), o)
+	})
+}
+
+// writeLck writes the json at path while holding its lock; next returns its new content, and w (bare) writes it (see writeIndexed).
+// Fails with a bin.ErrMismatch if the version token of the json is not etag (unless it's empty).
+// Keeps the indexes of the directory up to date; see Index.
+func writeLck(path string, etag string, o *bin.Opts, next func() (*indexable, error), w func(j *indexable) error) (rErr error) {
 	lckPath := bin.DefLckPath(path)
-	lckFile, err := bin.Lck(lckPath)
+	lckFile, err := bin.WLck(lckPath, o)
 	if err != nil {
 		return err
 	}
@@ -70,13 +180,19 @@ func Up(path string, j interface{}) (rErr error) {
 		}
 	}()
 
-	jOld, err := Get(path)
+	err = bin.ErrIfNotMatch(path, etag)
+	if err != nil {
+		return err
+	}
+
+	j, err := next()
 	if err != nil {
 		return err
 	}
-	jNew := mergeJsnRec(jOld, j)
 
-	return bin.OverBare(path, jsnToReader(jNew))
+	return writeIndexed(path, j, o, func() error {
+		return w(j)
+	})
 }
 
 func jsnToReader(j interface{}) io.Reader {
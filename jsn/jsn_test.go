@@ -0,0 +1,79 @@
+package jsn
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/agcom/dirb/bin"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	d := newTestDir(t, 2)
+	_, etag, err := d.GetObjETag("0.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(d.Path("0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != bin.ETagOf(b) {
+		t.Errorf("got the version token %q; want the one of the file, %q", etag, bin.ETagOf(b))
+	}
+
+	stale := bin.ETagOf([]byte("x"))
+	for op, w := range map[string]func() error{
+		"update":     func() error { return d.UpIfMatch("0.json", map[string]interface{}{"n": 5}, stale) },
+		"deep merge": func() error { return d.UpModeIfMatch("0.json", map[string]interface{}{"n": 5}, stale, MergeModeDeep) },
+		"overwrite":  func() error { return d.OverIfMatch("0.json", map[string]interface{}{"n": 5}, stale) },
+		"rm":         func() error { return d.RmIfMatch("0.json", stale) },
+	} {
+		var errMismatch *bin.ErrMismatch
+		if err := w(); !errors.As(err, &errMismatch) {
+			t.Errorf("%s: got %v of a stale version token; want a bin.ErrMismatch", op, err)
+		}
+	}
+
+	// Neither the json, nor its index changed.
+	j, err := d.Get("0.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(j, map[string]interface{}{"n": json.Number("0")}) {
+		t.Errorf("got %s after the failed preconditions", jsnStr(j))
+	}
+	if got := testEq(testIndex(t, d, "n"), 0); !reflect.DeepEqual(got, []string{"0.json"}) {
+		t.Errorf("got %q of 0 in the index; want 0.json", got)
+	}
+
+	err = d.UpIfMatch("0.json", map[string]interface{}{"n": 5}, etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testEq(testIndex(t, d, "n"), 5); !reflect.DeepEqual(got, []string{"0.json"}) {
+		t.Errorf("got %q of 5 in the index; want 0.json", got)
+	}
+
+	_, etag2, err := d.GetObjETag("0.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag2 == etag {
+		t.Errorf("the version token didn't change by the update")
+	}
+
+	var errMismatch *bin.ErrMismatch
+	err = d.RmIfMatch("0.json", etag)
+	if !errors.As(err, &errMismatch) {
+		t.Errorf("got %v of a replaced version token; want a bin.ErrMismatch", err)
+	}
+	err = d.RmIfMatch("0.json", etag2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testEq(testIndex(t, d, "n"), 5); len(got) != 0 {
+		t.Errorf("got %q of 5 in the index; want none", got)
+	}
+}
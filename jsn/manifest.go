@@ -0,0 +1,260 @@
+package jsn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/agcom/dirb/bin"
+	"go.uber.org/multierr"
+	"os"
+	"path/filepath"
+)
+
+// Manifest describes a directory of jsons; it's a json file in its (hidden) metadata directory (see ManifestPath).
+// A directory without one is described by the zero Manifest; so are the missing fields of one.
+type Manifest struct {
+	// Version is the format version of the directory; see ManifestVersion.
+	Version int `json:"version"`
+	// Ext is the file extension of the jsons, e.g. ".json".
+	Ext string `json:"ext,omitempty"`
+	// Names is the strategy of generating the names of the created jsons, e.g. "random".
+	Names string `json:"names,omitempty"`
+	// Durability is the default durability of the writes, e.g. "file+dir"; see bin.ParseDurability.
+	Durability string `json:"durability,omitempty"`
+	// History is the default number of the revisions kept by the writes; see bin.Opts.History.
+	History int `json:"history,omitempty"`
+	// Indexes declares the indexes of the directory; see IndexDecl.
+	Indexes []*IndexDecl `json:"indexes,omitempty"`
+	// Unique declares the unique constraints of the directory; see Unique.
+	Unique []*Unique `json:"unique,omitempty"`
+	// Schema is the json schema of the jsons of the directory, if any; see Schema.
+	Schema interface{} `json:"schema,omitempty"`
+}
+
+// Unique is a unique constraint; no two jsons may hold an equal (by Cmp) primitive value at Path.
+// It's enforced through the index of its name (see Index), once it exists; see AddUnique.
+type Unique struct {
+	Name string `json:"name"`
+	Path Path   `json:"path"`
+}
+
+// IndexDecl declares an index (see Index); it's recreated by RebuildIndex if it's missing.
+// The indexes of the unique constraints are declared by them instead; see Unique.
+type IndexDecl struct {
+	Name string `json:"name"`
+	Path Path   `json:"path"`
+	Text bool   `json:"text,omitempty"`
+}
+
+const manifestFileName = "manifest.json"
+
+// ManifestVersion is the (latest) format version of the directories, which this package supports; it's bumped on the incompatible changes of the format.
+// The manifests written before the versioning (of version 0) are of version 1.
+const ManifestVersion = 1
+
+// ManifestPath returns the path of the manifest of dir.
+func ManifestPath(dir string) string {
+	return filepath.Join(dir, bin.MetaDirName, manifestFileName)
+}
+
+// ReadManifest reads the manifest of dir; an empty one if it doesn't exist.
+func ReadManifest(dir string) (rM *Manifest, rErr error) {
+	mPath := ManifestPath(dir)
+	f, err := bin.Open(mPath)
+	if err != nil {
+		var errNotExist *bin.ErrNotExist
+		if errors.As(err, &errNotExist) {
+			return &Manifest{Version: ManifestVersion}, nil
+		} else {
+			return nil, err
+		}
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close manifest %q; %w", mPath, err))
+		}
+	}()
+
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	m := &Manifest{}
+	err = dec.Decode(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %q; %w", mPath, err)
+	}
+
+	if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("incompatible directory %q; its format version is %d, but only up to %d is supported", dir, m.Version, ManifestVersion)
+	} else if m.Version < 1 {
+		m.Version = 1
+	}
+
+	return m, nil
+}
+
+// index returns the declaration of index name of m, or nil if there's none.
+func (m *Manifest) index(name string) *IndexDecl {
+	for _, d := range m.Indexes {
+		if d.Name == name {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// unique returns the unique constraint name of m, or nil if there's none.
+func (m *Manifest) unique(name string) *Unique {
+	for _, u := range m.Unique {
+		if u.Name == name {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// indexHeader returns an empty Index of the declaration of index name in m (by an IndexDecl, or a Unique); nil if there's none.
+func (m *Manifest) indexHeader(name string) *Index {
+	if d := m.index(name); d != nil {
+		ix := d.index()
+		ix.Name = name
+		return ix
+	} else if u := m.unique(name); u != nil {
+		return &Index{Name: name, Path: u.Path, Entries: []*IndexEntry{}}
+	}
+
+	return nil
+}
+
+// schema compiles the schema of m; nil if there's none.
+func (m *Manifest) schema() (*Schema, error) {
+	if m.Schema == nil {
+		return nil, nil
+	}
+
+	s, err := NewSchema(m.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile the schema of the manifest; %w", err)
+	}
+
+	return s, nil
+}
+
+// validate returns an ErrSchema if the new content j of the json at path doesn't conform to the schema of m.
+func (m *Manifest) validate(path string, j *indexable) error {
+	s, err := m.schema()
+	if err != nil || s == nil || !j.ok {
+		return err
+	}
+
+	vs := s.Validate(j.j)
+	if len(vs) != 0 {
+		return NewErrSchema(path, vs)
+	}
+
+	return nil
+}
+
+// ReadSchema returns the schema of the jsons of dir (see Manifest.Schema); nil if there's none.
+func ReadSchema(dir string) (*Schema, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.schema()
+}
+
+// SetSchema sets (or removes, if j is nil) the schema of the jsons of dir; fails if j is not a valid schema (see NewSchema).
+// The existing jsons are not validated against it.
+func SetSchema(dir string, j interface{}, o *bin.Opts) error {
+	if j != nil {
+		_, err := NewSchema(j)
+		if err != nil {
+			return err
+		}
+	}
+
+	return UpdateManifest(dir, o, func(m *Manifest) error {
+		m.Schema = j
+		return nil
+	})
+}
+
+// UpdateManifest applies f on the manifest of dir, while holding its lock; creates it if it doesn't exist.
+func UpdateManifest(dir string, o *bin.Opts, f func(m *Manifest) error) (rErr error) {
+	mPath := ManifestPath(dir)
+	mDir := filepath.Dir(mPath)
+	err := os.MkdirAll(mDir, 0775)
+	if err != nil {
+		return fmt.Errorf("failed to create directory %q (or one of its parents); %w", mDir, err)
+	}
+
+	o = indexOpts(o)
+	lckPath := bin.DefLckPath(mPath)
+	lckFile, err := bin.WLck(lckPath, o)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := bin.Unlck(lckPath, lckFile)
+		if err != nil {
+			rErr = multierr.Append(rErr, err)
+		}
+	}()
+
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	err = f(m)
+	if err != nil {
+		return err
+	}
+
+	err = bin.ErrIfExists(mPath)
+	if err != nil {
+		var errExists *bin.ErrExists
+		if errors.As(err, &errExists) {
+			return bin.OverBareOpts(mPath, jsnToReader(m), o)
+		} else {
+			return err
+		}
+	}
+
+	return bin.NewBareOpts(mPath, jsnToReader(m), o)
+}
+
+// recoverManifest recovers the writes of the manifest of dir; see bin.Recover.
+func recoverManifest(dir string, o *bin.Opts) error {
+	mDir := filepath.Dir(ManifestPath(dir))
+	_, err := os.Stat(mDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else {
+			return fmt.Errorf("failed to stat %q; %w", mDir, err)
+		}
+	}
+
+	return bin.RecoverOpts(mDir, indexOpts(o))
+}
+
+func (d *Dir) Manifest() (*Manifest, error) {
+	return ReadManifest(d.BinDir().Dir())
+}
+
+func (d *Dir) UpdateManifest(f func(m *Manifest) error) error {
+	return UpdateManifest(d.BinDir().Dir(), d.BinDir().Opts(), f)
+}
+
+func (d *Dir) Schema() (*Schema, error) {
+	return ReadSchema(d.BinDir().Dir())
+}
+
+func (d *Dir) SetSchema(j interface{}) error {
+	return SetSchema(d.BinDir().Dir(), j, d.BinDir().Opts())
+}
@@ -0,0 +1,90 @@
+package jsn
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	m, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, &Manifest{Version: ManifestVersion}) {
+		t.Errorf("got %+v of a directory without a manifest; want the zero one, of the latest version", m)
+	}
+
+	for _, c := range []struct {
+		s       string
+		version int
+		err     bool
+	}{
+		// Written before the versioning
+		{`{"ext": ".json"}`, 1, false},
+		{`{"version": 1, "ext": ".json"}`, 1, false},
+		{`{"version": 2, "ext": ".json"}`, 0, true},
+		{`{"version": `, 0, true},
+	} {
+		mPath := ManifestPath(dir)
+		err := os.MkdirAll(filepath.Dir(mPath), 0775)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(mPath, []byte(c.s), 0664)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := ReadManifest(dir)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: got %+v; want an error", c.s, m)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.s, err)
+		} else if m.Version != c.version || m.Ext != ".json" {
+			t.Errorf("%s: got %+v; want version %d", c.s, m, c.version)
+		}
+	}
+}
+
+func TestUpdateManifest(t *testing.T) {
+	d := NewDir(t.TempDir())
+	err := d.UpdateManifest(func(m *Manifest) error {
+		m.Names, m.Durability, m.History = "uuid", "none", 3
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.UpdateManifest(func(m *Manifest) error {
+		m.History = -1
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := d.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Manifest{Version: ManifestVersion, Names: "uuid", Durability: "none", History: -1}); !reflect.DeepEqual(m, want) {
+		t.Errorf("got %+v; want %+v", m, want)
+	}
+
+	// Missing fields are omitted.
+	b, err := os.ReadFile(ManifestPath(d.BinDir().Dir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); strings.Contains(s, "ext") || strings.Contains(s, "indexes") {
+		t.Errorf("got %s; want no ext, nor indexes", s)
+	}
+}
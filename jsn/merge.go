@@ -0,0 +1,71 @@
+package jsn
+
+import "fmt"
+
+// MergeMode is how an update merges a json into another; see Up.
+type MergeMode int
+
+const (
+	// MergeModePatch applies the json as a merge patch; see MergePatch. The default.
+	MergeModePatch MergeMode = iota
+	// MergeModeDeep merges the json objects recursively, keeping the nulls (i.e. never removes a key); the merge of the older versions.
+	MergeModeDeep
+)
+
+func ParseMergeMode(s string) (MergeMode, error) {
+	switch s {
+	case "patch":
+		return MergeModePatch, nil
+	case "deep":
+		return MergeModeDeep, nil
+	default:
+		return 0, fmt.Errorf("unknown merge mode %q; should be \"patch\", or \"deep\"", s)
+	}
+}
+
+func (m MergeMode) String() string {
+	switch m {
+	case MergeModePatch:
+		return "patch"
+	case MergeModeDeep:
+		return "deep"
+	default:
+		return fmt.Sprintf("MergeMode(%d)", int(m))
+	}
+}
+
+// Merge returns the merge of j2 into j1, by mode m; doesn't change j1, nor j2.
+func (m MergeMode) Merge(j1, j2 interface{}) interface{} {
+	if m == MergeModeDeep {
+		return mergeJsnRec(j1, j2)
+	}
+
+	return MergePatch(j1, j2)
+}
+
+// MergePatch applies merge patch p on json j, as of RFC 7386; doesn't change j, nor p.
+// An object patch is applied key by key (a null value removes the key, and any other value is merge-patched into the one of the key), and any other patch replaces j as a whole.
+func MergePatch(j, p interface{}) interface{} {
+	po, ok := p.(map[string]interface{})
+	if !ok {
+		return p
+	}
+
+	jo, ok := j.(map[string]interface{})
+	r := make(map[string]interface{}, len(jo)+len(po))
+	if ok {
+		for k, v := range jo {
+			r[k] = v
+		}
+	}
+
+	for k, pv := range po {
+		if pv == nil {
+			delete(r, k)
+		} else {
+			r[k] = MergePatch(r[k], pv)
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,76 @@
+package jsn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		m    MergeMode
+		j    string
+		p    string
+		want string
+	}{
+		// RFC 7386, appendix A
+		{MergeModePatch, `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{MergeModePatch, `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{MergeModePatch, `{"a":"b"}`, `{"a":null}`, `{}`},
+		{MergeModePatch, `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{MergeModePatch, `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{MergeModePatch, `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{MergeModePatch, `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{MergeModePatch, `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{MergeModePatch, `["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{MergeModePatch, `{"a":"b"}`, `["c"]`, `["c"]`},
+		{MergeModePatch, `{"a":"foo"}`, `null`, `null`},
+		{MergeModePatch, `{"a":"foo"}`, `"bar"`, `"bar"`},
+		{MergeModePatch, `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{MergeModePatch, `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{MergeModePatch, `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+
+		// A nested null removes a key.
+		{MergeModePatch, `{"a":{"b":1,"c":{"d":2,"e":3}}}`, `{"a":{"c":{"d":null}}}`, `{"a":{"b":1,"c":{"e":3}}}`},
+		// A non-object replaces an object, and the reverse.
+		{MergeModePatch, `{"a":{"b":1},"c":2}`, `{"a":5}`, `{"a":5,"c":2}`},
+		{MergeModePatch, `{"a":5,"c":2}`, `{"a":{"b":1,"d":null}}`, `{"a":{"b":1},"c":2}`},
+		// Into a missing, or a non-object key
+		{MergeModePatch, `{"x":1}`, `{"a":{"b":{"c":1,"d":null}}}`, `{"x":1,"a":{"b":{"c":1}}}`},
+		{MergeModePatch, `{"a":"s"}`, `{"a":{"b":{"c":1}}}`, `{"a":{"b":{"c":1}}}`},
+		{MergeModePatch, `{"a":[1]}`, `{"a":{"b":1}}`, `{"a":{"b":1}}`},
+
+		// The merge of the older versions; keeps the nulls.
+		{MergeModeDeep, `{"a":{"b":1}}`, `{"a":{"c":2}}`, `{"a":{"b":1,"c":2}}`},
+		{MergeModeDeep, `{"a":1,"b":2}`, `{"a":null}`, `{"a":null,"b":2}`},
+		{MergeModeDeep, `{"a":{"b":1,"c":2}}`, `{"a":{"b":null}}`, `{"a":{"b":null,"c":2}}`},
+		{MergeModeDeep, `{"a":{"b":1}}`, `{"a":5}`, `{"a":5}`},
+		{MergeModeDeep, `{"a":5}`, `{"a":{"b":null}}`, `{"a":{"b":null}}`},
+		{MergeModeDeep, `{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{MergeModeDeep, `{"a":"b"}`, `["c"]`, `["c"]`},
+	}
+
+	parse := func(s string) interface{} {
+		j, err := StrToJsn(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+
+	for _, tt := range tests {
+		j, p, want := parse(tt.j), parse(tt.p), parse(tt.want)
+
+		got := tt.m.Merge(j, p)
+		if !Equal(got, want) {
+			t.Errorf("%s merge of %s into %s: got %s; want %s", tt.m, tt.p, tt.j, jsnStr(got), tt.want)
+		}
+
+		// Neither is changed.
+		if !reflect.DeepEqual(j, parse(tt.j)) {
+			t.Errorf("%s merge of %s into %s: changed the json into %s", tt.m, tt.p, tt.j, jsnStr(j))
+		}
+		if !reflect.DeepEqual(p, parse(tt.p)) {
+			t.Errorf("%s merge of %s into %s: changed the patch into %s", tt.m, tt.p, tt.j, jsnStr(p))
+		}
+	}
+}
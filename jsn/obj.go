@@ -1,13 +1,43 @@
 package jsn
 
-import "fmt"
+import (
+	"fmt"
+	"github.com/agcom/dirb/bin"
+)
 
 func GetObj(path string) (map[string]interface{}, error) {
-	j, err := Get(path)
+	return GetObjOpts(path, nil)
+}
+
+func GetObjOpts(path string, o *bin.Opts) (map[string]interface{}, error) {
+	j, err := GetOpts(path, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsnToObj(j)
+}
+
+func GetObjBare(path string) (map[string]interface{}, error) {
+	j, err := GetBare(path)
 	if err != nil {
 		return nil, err
 	}
 
+	return jsnToObj(j)
+}
+
+func GetObjETag(path string, o *bin.Opts) (map[string]interface{}, string, error) {
+	j, etag, err := GetETag(path, o)
+	if err != nil {
+		return nil, "", err
+	}
+
+	jo, err := jsnToObj(j)
+	return jo, etag, err
+}
+
+func jsnToObj(j interface{}) (map[string]interface{}, error) {
 	jo, ok := j.(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("%v is not a json object", j)
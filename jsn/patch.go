@@ -0,0 +1,373 @@
+package jsn
+
+import (
+	"fmt"
+	"github.com/agcom/dirb/bin"
+)
+
+// Patch is a json patch, as of RFC 6902; a sequence of operations, which are applied in order, all or none of them.
+type Patch []*PatchOp
+
+// PatchOp is an operation of a json patch; Op is either "add", "remove", "replace", "move", "copy", or "test".
+// Path and From are json pointers (see RFC 6901); From is of move and copy only, and Value is of add, replace, and test only.
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// ErrTest is a failed test operation of a json patch; the value at Ptr is not Val.
+type ErrTest struct {
+	Ptr string
+	Val interface{}
+}
+
+func (e *ErrTest) Error() string {
+	return fmt.Sprintf("test failed; the value at %q is not %s", e.Ptr, jsnStr(e.Val))
+}
+
+func NewErrTest(ptr string, val interface{}) *ErrTest {
+	return &ErrTest{ptr, val}
+}
+
+// ParsePatch parses json j (e.g. read by ReaderToJsn) as a json patch; an array of operation objects.
+// The members of the operations other than op, path, from, and value are ignored.
+func ParsePatch(j interface{}) (Patch, error) {
+	ja, ok := j.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid json patch; %s is not an array", jsnStr(j))
+	}
+
+	p := make(Patch, 0, len(ja))
+	for i, jop := range ja {
+		op, err := parsePatchOp(jop)
+		if err != nil {
+			return nil, fmt.Errorf("invalid operation %d of the json patch; %w", i, err)
+		}
+		p = append(p, op)
+	}
+
+	return p, nil
+}
+
+func parsePatchOp(j interface{}) (*PatchOp, error) {
+	jo, ok := j.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s is not an object", jsnStr(j))
+	}
+
+	op := &PatchOp{}
+	op.Op, ok = jo["op"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or non-string \"op\" member")
+	}
+
+	op.Path, ok = jo["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or non-string \"path\" member")
+	}
+	_, err := ParsePtr(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		// Null is a value, but a missing one is not.
+		op.Value, ok = jo["value"]
+		if !ok {
+			return nil, fmt.Errorf("missing \"value\" member")
+		}
+	case "move", "copy":
+		op.From, ok = jo["from"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing or non-string \"from\" member")
+		}
+		_, err := ParsePtr(op.From)
+		if err != nil {
+			return nil, err
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unknown op %q; should be \"add\", \"remove\", \"replace\", \"move\", \"copy\", or \"test\"", op.Op)
+	}
+
+	return op, nil
+}
+
+// Apply returns the result of applying p on json j; doesn't change j, nor p.
+// Fails, on the first failed operation, with an ErrTest if it's a failed test.
+func (p Patch) Apply(j interface{}) (interface{}, error) {
+	j = copyJsn(j)
+	for i, op := range p {
+		var err error
+		j, err = op.apply(j)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply operation %d (%s %q) of the json patch; %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return j, nil
+}
+
+// apply applies op on json j, in place (as far as it can); returns the result.
+func (op *PatchOp) apply(j interface{}) (interface{}, error) {
+	ts, err := ParsePtr(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		return patchAdd(j, ts, copyJsn(op.Value))
+	case "remove":
+		_, j, err := patchRm(j, ts)
+		return j, err
+	case "replace":
+		return patchAt(j, ts, func(interface{}) (interface{}, error) {
+			return copyJsn(op.Value), nil
+		})
+	case "move":
+		fts, err := ParsePtr(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if len(fts) < len(ts) && isPtrPrefix(fts, ts) {
+			return nil, fmt.Errorf("can't move %q into one of its children", op.From)
+		}
+
+		v, j, err := patchRm(j, fts)
+		if err != nil {
+			return nil, err
+		}
+
+		return patchAdd(j, ts, v)
+	case "copy":
+		fts, err := ParsePtr(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		v, ok := Resolve(j, fts)
+		if !ok {
+			return nil, fmt.Errorf("no value at %q", op.From)
+		}
+
+		return patchAdd(j, ts, copyJsn(v))
+	case "test":
+		v, ok := Resolve(j, ts)
+		if !ok || !Equal(v, op.Value) {
+			return nil, NewErrTest(op.Path, op.Value)
+		}
+
+		return j, nil
+	default:
+		return nil, fmt.Errorf("unknown op %q", op.Op)
+	}
+}
+
+// patchAt replaces the (existing) value at the given reference tokens in json j by the result of f on it; returns the result.
+func patchAt(j interface{}, ts []string, f func(v interface{}) (interface{}, error)) (interface{}, error) {
+	if len(ts) == 0 {
+		return f(j)
+	}
+
+	switch x := j.(type) {
+	case map[string]interface{}:
+		v, ok := x[ts[0]]
+		if !ok {
+			return nil, fmt.Errorf("no member %q", ts[0])
+		}
+
+		v, err := patchAt(v, ts[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		x[ts[0]] = v
+
+		return x, nil
+	case []interface{}:
+		i, err := ArrIndex(ts[0], len(x), false)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := patchAt(x[i], ts[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		x[i] = v
+
+		return x, nil
+	default:
+		return nil, fmt.Errorf("%s is neither an object, nor an array", jsnStr(j))
+	}
+}
+
+// patchAdd adds v at the given reference tokens in json j; a member is added (or replaced), and an element is inserted (or appended, by "-").
+func patchAdd(j interface{}, ts []string, v interface{}) (interface{}, error) {
+	if len(ts) == 0 {
+		return v, nil
+	}
+
+	t := ts[len(ts)-1]
+	return patchAt(j, ts[:len(ts)-1], func(c interface{}) (interface{}, error) {
+		switch x := c.(type) {
+		case map[string]interface{}:
+			x[t] = v
+			return x, nil
+		case []interface{}:
+			i, err := ArrIndex(t, len(x), true)
+			if err != nil {
+				return nil, err
+			}
+
+			x = append(x, nil)
+			copy(x[i+1:], x[i:])
+			x[i] = v
+
+			return x, nil
+		default:
+			return nil, fmt.Errorf("%s is neither an object, nor an array", jsnStr(c))
+		}
+	})
+}
+
+// patchRm removes the (existing) value at the given reference tokens in json j; returns it, and the result.
+func patchRm(j interface{}, ts []string) (interface{}, interface{}, error) {
+	if len(ts) == 0 {
+		return nil, nil, fmt.Errorf("can't remove the whole json")
+	}
+
+	t := ts[len(ts)-1]
+	var v interface{}
+	j, err := patchAt(j, ts[:len(ts)-1], func(c interface{}) (interface{}, error) {
+		switch x := c.(type) {
+		case map[string]interface{}:
+			var ok bool
+			v, ok = x[t]
+			if !ok {
+				return nil, fmt.Errorf("no member %q", t)
+			}
+			delete(x, t)
+
+			return x, nil
+		case []interface{}:
+			i, err := ArrIndex(t, len(x), false)
+			if err != nil {
+				return nil, err
+			}
+			v = x[i]
+
+			return append(x[:i], x[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%s is neither an object, nor an array", jsnStr(c))
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return v, j, nil
+}
+
+// isPtrPrefix reports whether reference tokens ts1 are a prefix of ts2.
+func isPtrPrefix(ts1, ts2 []string) bool {
+	if len(ts1) > len(ts2) {
+		return false
+	}
+
+	for i, t := range ts1 {
+		if ts2[i] != t {
+			return false
+		}
+	}
+
+	return true
+}
+
+// copyJsn returns a deep copy of json j.
+func copyJsn(j interface{}) interface{} {
+	switch x := j.(type) {
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			r[k] = copyJsn(v)
+		}
+		return r
+	case []interface{}:
+		r := make([]interface{}, len(x))
+		for i, v := range x {
+			r[i] = copyJsn(v)
+		}
+		return r
+	default:
+		return j
+	}
+}
+
+// PatchOpts applies json patch p on the json at path, atomically; see Patch.
+// Fails if the result is not a json object.
+func PatchOpts(path string, p Patch, o *bin.Opts) error {
+	return PatchIfMatch(path, p, "", o)
+}
+
+// PatchIfMatch is like PatchOpts, but fails with a bin.ErrMismatch if the version token of the json is not etag (unless it's empty).
+func PatchIfMatch(path string, p Patch, etag string, o *bin.Opts) error {
+	// Early existence check (not vital)
+	err := bin.ErrIfNotExist(path)
+	if err != nil {
+		return err
+	}
+
+	return writeLck(path, etag, o, func() (*indexable, error) {
+		jOld, err := GetBare(path)
+		if err != nil {
+			return nil, err
+		}
+
+		j, err := p.Apply(jOld)
+		if err != nil {
+			return nil, fmt.Errorf("failed to patch %q; %w", path, err)
+		}
+
+		jo, err := jsnToObj(j)
+		if err != nil {
+			return nil, fmt.Errorf("failed to patch %q; %w", path, err)
+		}
+
+		return &indexable{jo, true}, nil
+	}, func(j *indexable) error {
+		return bin.OverBareOpts(path, jsnToReader(j.j), o)
+	})
+}
+
+func (d *Dir) Patch(name string, p Patch) error {
+	return d.PatchIfMatch(name, p, "")
+}
+
+func (d *Dir) PatchIfMatch(name string, p Patch, etag string) error {
+	path := d.Path(name)
+	return PatchIfMatch(path, p, etag, d.BinDir().Opts())
+}
+
+// Patch stages the result of applying json patch p on json name; see Patch.Apply.
+func (t *Tx) Patch(name string, p Patch) error {
+	jOld, err := t.Get(name)
+	if err != nil {
+		return err
+	}
+
+	j, err := p.Apply(jOld)
+	if err != nil {
+		return fmt.Errorf("failed to patch %q; %w", name, err)
+	}
+
+	jo, err := jsnToObj(j)
+	if err != nil {
+		return fmt.Errorf("failed to patch %q; %w", name, err)
+	}
+
+	return t.Over(name, jo)
+}
@@ -0,0 +1,204 @@
+package jsn
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		j string
+		p string
+		// The result; empty if it fails.
+		want string
+	}{
+		// RFC 6902, appendix A
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ``},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ``},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, ``},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+
+		// The end of an array
+		{`{"a":[]}`, `[{"op":"add","path":"/a/-","value":1},{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`},
+		{`{"a":[1]}`, `[{"op":"copy","from":"/a/0","path":"/a/-"}]`, `{"a":[1,1]}`},
+		{`{"a":[1]}`, `[{"op":"remove","path":"/a/-"}]`, ``},
+		{`{"a":[1]}`, `[{"op":"replace","path":"/a/-","value":2}]`, ``},
+		// Out of range; one past the last is an add only.
+		{`{"a":[1,2]}`, `[{"op":"add","path":"/a/2","value":3}]`, `{"a":[1,2,3]}`},
+		{`{"a":[1,2]}`, `[{"op":"add","path":"/a/3","value":3}]`, ``},
+		{`{"a":[1,2]}`, `[{"op":"remove","path":"/a/2"}]`, ``},
+		{`{"a":[1,2]}`, `[{"op":"replace","path":"/a/2","value":3}]`, ``},
+		{`{"a":[1,2]}`, `[{"op":"test","path":"/a/2","value":null}]`, ``},
+		{`{"a":[1,2]}`, `[{"op":"move","from":"/a/5","path":"/b"}]`, ``},
+		{`{"a":[1,2]}`, `[{"op":"add","path":"/a/01","value":3}]`, ``},
+		// Into its own child
+		{`{"a":{"b":{}}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, ``},
+		{`{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":{"b":1}}`},
+		{`{"ab":1}`, `[{"op":"move","from":"/a","path":"/ab/c"}]`, ``},
+		{`{"a":1,"ab":{}}`, `[{"op":"move","from":"/a","path":"/ab/c"}]`, `{"ab":{"c":1}}`},
+		// The whole json
+		{`{"a":1}`, `[{"op":"replace","path":"","value":{"b":2}}]`, `{"b":2}`},
+		{`{"a":1}`, `[{"op":"remove","path":""}]`, ``},
+		// A failed test fails them all; even the earlier ones.
+		{`{"a":1}`, `[{"op":"add","path":"/b","value":2},{"op":"test","path":"/a","value":2}]`, ``},
+		{`{"a":1}`, `[{"op":"add","path":"/b","value":2},{"op":"test","path":"/a","value":1.0}]`, `{"a":1,"b":2}`},
+		// A copy is not an alias.
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+	}
+
+	parse := func(s string) interface{} {
+		j, err := StrToJsn(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+
+	for _, tt := range tests {
+		j, jp := parse(tt.j), parse(tt.p)
+		p, err := ParsePatch(jp)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.p, err)
+		}
+
+		got, err := p.Apply(j)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s on %s: got %s; want a failure", tt.p, tt.j, jsnStr(got))
+			}
+		} else if err != nil {
+			t.Errorf("%s on %s: %v", tt.p, tt.j, err)
+		} else if want := parse(tt.want); !Equal(got, want) {
+			t.Errorf("%s on %s: got %s; want %s", tt.p, tt.j, jsnStr(got), tt.want)
+		}
+
+		// Neither is changed.
+		if !reflect.DeepEqual(j, parse(tt.j)) {
+			t.Errorf("%s on %s: changed the json into %s", tt.p, tt.j, jsnStr(j))
+		}
+		if !reflect.DeepEqual(jp, parse(tt.p)) {
+			t.Errorf("%s on %s: changed the patch", tt.p, tt.j)
+		}
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	for _, s := range []string{
+		`{"op":"add","path":"/a","value":1}`,
+		`[1]`,
+		`[{"path":"/a","value":1}]`,
+		`[{"op":"add","value":1}]`,
+		`[{"op":"add","path":"/a"}]`,
+		`[{"op":"move","path":"/a"}]`,
+		`[{"op":"copy","path":"/a","from":1}]`,
+		`[{"op":"add","path":"a","value":1}]`,
+		`[{"op":"move","path":"/a","from":"a"}]`,
+		`[{"op":"merge","path":"/a","value":1}]`,
+	} {
+		j, err := StrToJsn(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ParsePatch(j)
+		if err == nil {
+			t.Errorf("%s: parsed an invalid patch", s)
+		}
+	}
+
+	// A null value is a value.
+	j, err := StrToJsn(`[{"op":"add","path":"/a","value":null}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParsePatch(j)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPatchIfMatch(t *testing.T) {
+	d := NewDir(t.TempDir())
+	err := d.New("a.json", map[string]interface{}{"a": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func(s string) Patch {
+		j, err := StrToJsn(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParsePatch(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// A failed test leaves the json as is.
+	_, etag, err := d.GetObjETag("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.PatchIfMatch("a.json", parse(`[{"op":"add","path":"/b","value":1},{"op":"test","path":"/a","value":"y"}]`), etag)
+	var errTest *ErrTest
+	if !errors.As(err, &errTest) {
+		t.Errorf("got %v; want an ErrTest", err)
+	}
+	j, etag2, err := d.GetObjETag("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag2 != etag || !reflect.DeepEqual(j, map[string]interface{}{"a": "x"}) {
+		t.Errorf("changed into %s by a failed patch", jsnStr(j))
+	}
+
+	// Neither does a non-object result.
+	err = d.Patch("a.json", parse(`[{"op":"replace","path":"","value":[1]}]`))
+	if err == nil {
+		t.Errorf("patched a json into an array")
+	}
+
+	err = d.PatchIfMatch("a.json", parse(`[{"op":"test","path":"/a","value":"x"},{"op":"add","path":"/b","value":1}]`), etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.PatchIfMatch("a.json", parse(`[{"op":"remove","path":"/b"}]`), etag)
+	if err == nil {
+		t.Errorf("patched a json of a stale version token")
+	}
+
+	tx := d.Begin()
+	err = tx.Patch("a.json", parse(`[{"op":"test","path":"/b","value":2}]`))
+	if !errors.As(err, &errTest) {
+		t.Errorf("got %v; want an ErrTest", err)
+	}
+	err = tx.Patch("a.json", parse(`[{"op":"move","from":"/b","path":"/c"}]`))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = d.GetObj("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := StrToJsn(`{"a":"x","c":1}`); !Equal(j, want) {
+		t.Errorf("got %s; want %s", jsnStr(j), jsnStr(want))
+	}
+}
@@ -0,0 +1,114 @@
+package jsn
+
+import (
+	"strconv"
+)
+
+// Path refers to the values nested in a json, e.g. the authors of a book; an empty one refers to the json itself.
+type Path []PathElem
+
+// PathElem is a step of a Path; into the value of key Key of an object, or into element Key of an array if Key is an index of it (as a json pointer does; see RFC 6901).
+// If Index, Key is an array index, and the step is only into an array; if Each, the step is into each element of an array.
+type PathElem struct {
+	Key   string `json:"key,omitempty"`
+	Index bool   `json:"index,omitempty"`
+	Each  bool   `json:"each,omitempty"`
+}
+
+// PtrPath returns the Path of json pointer p; see ParsePtr.
+func PtrPath(p string) (Path, error) {
+	ts, err := ParsePtr(p)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make(Path, 0, len(ts))
+	for _, t := range ts {
+		r = append(r, PathElem{Key: t})
+	}
+
+	return r, nil
+}
+
+// step returns the value which e steps into in j (or, if Each, each of them); none if it's missing, or if j is of the wrong type.
+func (e PathElem) step(j interface{}) []interface{} {
+	switch x := j.(type) {
+	case map[string]interface{}:
+		if e.Each || e.Index {
+			return nil
+		}
+		if v, ok := x[e.Key]; ok {
+			return []interface{}{v}
+		}
+	case []interface{}:
+		if e.Each {
+			return x
+		}
+		if i, err := ArrIndex(e.Key, len(x), false); err == nil {
+			return []interface{}{x[i]}
+		}
+	}
+
+	return nil
+}
+
+// Values returns the values at p in j; none if a step is missing, or is into a json of the wrong type.
+func (p Path) Values(j interface{}) []interface{} {
+	vs := []interface{}{j}
+	for _, e := range p {
+		nvs := make([]interface{}, 0, len(vs))
+		for _, v := range vs {
+			nvs = append(nvs, e.step(v)...)
+		}
+		vs = nvs
+	}
+
+	return vs
+}
+
+// Located is a value found at a Path, along with its (RFC 6901) json pointer.
+type Located struct {
+	Ptr string
+	Val interface{}
+}
+
+// Locate is like Values, but also returns the json pointers of the values.
+func (p Path) Locate(j interface{}) []*Located {
+	ls := []*Located{{"", j}}
+	for _, e := range p {
+		nls := make([]*Located, 0, len(ls))
+		for _, l := range ls {
+			switch x := l.Val.(type) {
+			case map[string]interface{}:
+				if v, ok := x[e.Key]; ok && !e.Each && !e.Index {
+					nls = append(nls, &Located{l.Ptr + "/" + EscapePtrToken(e.Key), v})
+				}
+			case []interface{}:
+				if e.Each {
+					for i, v := range x {
+						nls = append(nls, &Located{l.Ptr + "/" + strconv.Itoa(i), v})
+					}
+				} else if i, err := ArrIndex(e.Key, len(x), false); err == nil {
+					nls = append(nls, &Located{l.Ptr + "/" + strconv.Itoa(i), x[i]})
+				}
+			}
+		}
+		ls = nls
+	}
+
+	return ls
+}
+
+func (p Path) Equal(q Path) bool {
+	if len(p) != len(q) {
+		return false
+	}
+
+	for i := range p {
+		if p[i] != q[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,76 @@
+package jsn
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParsePtr splits json pointer p into its (unescaped) reference tokens; see RFC 6901.
+func ParsePtr(p string) ([]string, error) {
+	if p == "" {
+		return []string{}, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("invalid json pointer %q; should be empty or start with a '/'", p)
+	}
+
+	ts := strings.Split(p[1:], "/")
+	for i, t := range ts {
+		for j := 0; j < len(t); j++ {
+			if t[j] == '~' && (j+1 == len(t) || (t[j+1] != '0' && t[j+1] != '1')) {
+				return nil, fmt.Errorf("invalid json pointer %q; '~' should be followed by '0' or '1'", p)
+			}
+		}
+		ts[i] = UnescapePtrToken(t)
+	}
+
+	return ts, nil
+}
+
+// UnescapePtrToken is the inverse of EscapePtrToken.
+func UnescapePtrToken(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+}
+
+// ArrIndex parses reference token t as an index of an array of length l; see RFC 6901.
+// The index l itself (past the last element) is accepted only if end is true; so is "-", which stands for it.
+func ArrIndex(t string, l int, end bool) (int, error) {
+	if t == "-" && end {
+		return l, nil
+	}
+
+	if t == "" || (len(t) > 1 && t[0] == '0') || strings.TrimLeft(t, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index %q", t)
+	}
+	i, err := strconv.Atoi(t)
+	if err != nil || i > l || (i == l && !end) {
+		return 0, fmt.Errorf("array index %q is out of bounds (of length %d)", t, l)
+	}
+
+	return i, nil
+}
+
+// Resolve returns the value at the given (unescaped) reference tokens in j; false if there's none.
+func Resolve(j interface{}, ts []string) (interface{}, bool) {
+	for _, t := range ts {
+		switch x := j.(type) {
+		case map[string]interface{}:
+			v, ok := x[t]
+			if !ok {
+				return nil, false
+			}
+			j = v
+		case []interface{}:
+			i, err := ArrIndex(t, len(x), false)
+			if err != nil {
+				return nil, false
+			}
+			j = x[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return j, true
+}
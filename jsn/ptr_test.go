@@ -0,0 +1,220 @@
+package jsn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePtr(t *testing.T) {
+	for p, want := range map[string][]string{
+		"":        {},
+		"/":       {""},
+		"//":      {"", ""},
+		"/a/b":    {"a", "b"},
+		"/a~1b":   {"a/b"},
+		"/m~0n":   {"m~n"},
+		"/0":      {"0"},
+		"/ ":      {" "},
+		"/a%20b":  {"a%20b"},
+		"/c%d":    {"c%d"},
+		"/~0~1":   {"~/"},
+		"/~1~0":   {"/~"},
+		"/~01":    {"~1"},
+		"/~10":    {"/0"},
+		"/~0~01":  {"~~1"},
+		"/a/~0/b": {"a", "~", "b"},
+	} {
+		got, err := ParsePtr(p)
+		if err != nil {
+			t.Errorf("%q: %v", p, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %q; want %q", p, got, want)
+		}
+	}
+
+	for _, p := range []string{"a", "a/b", "/~", "/~2", "/a~", "/~a", "/~~0"} {
+		ts, err := ParsePtr(p)
+		if err == nil {
+			t.Errorf("%q: parsed an invalid json pointer into %q", p, ts)
+		}
+	}
+}
+
+func TestEscapePtrToken(t *testing.T) {
+	for s, want := range map[string]string{
+		"":     "",
+		"a":    "a",
+		"a/b":  "a~1b",
+		"m~n":  "m~0n",
+		"~1":   "~01",
+		"/0":   "~10",
+		"~/":   "~0~1",
+		"~~//": "~0~0~1~1",
+	} {
+		if got := EscapePtrToken(s); got != want {
+			t.Errorf("%q: got %q; want %q", s, got, want)
+		}
+		if got := UnescapePtrToken(want); got != s {
+			t.Errorf("%q: got %q unescaped; want %q", want, got, s)
+		}
+	}
+}
+
+func TestArrIndex(t *testing.T) {
+	for _, c := range []struct {
+		t   string
+		l   int
+		end bool
+		// -1 if invalid
+		want int
+	}{
+		{"0", 3, false, 0},
+		{"2", 3, false, 2},
+		{"3", 3, false, -1},
+		{"3", 3, true, 3},
+		{"4", 3, true, -1},
+		{"-", 3, false, -1},
+		{"-", 3, true, 3},
+		{"0", 0, false, -1},
+		{"0", 0, true, 0},
+		{"-", 0, true, 0},
+		// Leading zeros
+		{"00", 3, false, -1},
+		{"01", 3, false, -1},
+		{"10", 11, false, 10},
+		{"", 3, false, -1},
+		{"-1", 3, false, -1},
+		{"+1", 3, false, -1},
+		{"1e0", 3, false, -1},
+		{" 1", 3, false, -1},
+		{"a", 3, false, -1},
+		{"99999999999999999999", 3, false, -1},
+	} {
+		i, err := ArrIndex(c.t, c.l, c.end)
+		if c.want < 0 {
+			if err == nil {
+				t.Errorf("%q of length %d (end %v): got %d; want a failure", c.t, c.l, c.end, i)
+			}
+		} else if err != nil || i != c.want {
+			t.Errorf("%q of length %d (end %v): got %d (%v); want %d", c.t, c.l, c.end, i, err, c.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	// RFC 6901, section 5
+	j, err := StrToJsn(`{"foo": ["bar", "baz"], "": 0, "a/b": 1, "c%d": 2, "e^f": 3, "g|h": 4, "i\\j": 5, "k\"l": 6, " ": 7, "m~n": 8}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]string{
+		"":        `{"foo": ["bar", "baz"], "": 0, "a/b": 1, "c%d": 2, "e^f": 3, "g|h": 4, "i\\j": 5, "k\"l": 6, " ": 7, "m~n": 8}`,
+		"/foo":    `["bar", "baz"]`,
+		"/foo/0":  `"bar"`,
+		"/":       `0`,
+		"/a~1b":   `1`,
+		"/c%d":    `2`,
+		"/e^f":    `3`,
+		"/g|h":    `4`,
+		"/i\\j":   `5`,
+		"/k\"l":   `6`,
+		"/ ":      `7`,
+		"/m~0n":   `8`,
+		"/foo/1":  `"baz"`,
+		"/foo/2":  ``,
+		"/foo/-":  ``,
+		"/foo/01": ``,
+		"/bar":    ``,
+		"/foo/0/": ``,
+		"/a~1b/c": ``,
+	} {
+		ts, err := ParsePtr(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok := Resolve(j, ts)
+		if want == "" {
+			if ok {
+				t.Errorf("%q: got %s; want none", p, jsnStr(got))
+			}
+			continue
+		}
+
+		w, err := StrToJsn(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || !Equal(got, w) {
+			t.Errorf("%q: got %s (%v); want %s", p, jsnStr(got), ok, want)
+		}
+	}
+}
+
+func TestPathLocate(t *testing.T) {
+	j, err := StrToJsn(`{"a": [{"b": 1}, {"b": 2}, {"c": 3}], "0": {"1": "x"}, "o": {"0": "key"}, "arr": ["elem"], "s/t": {"~": true}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		p    Path
+		want map[string]string
+	}{
+		{Path{}, map[string]string{"": ""}},
+		{Path{{Key: "a"}, {Each: true}, {Key: "b"}}, map[string]string{"/a/0/b": "1", "/a/1/b": "2"}},
+		{Path{{Key: "a"}, {Key: "1"}, {Key: "b"}}, map[string]string{"/a/1/b": "2"}},
+		{Path{{Key: "a"}, {Key: "1", Index: true}}, map[string]string{"/a/1": `{"b":2}`}},
+		{Path{{Key: "a"}, {Key: "3", Index: true}}, map[string]string{}},
+		{Path{{Key: "a"}, {Key: "01"}}, map[string]string{}},
+		// A numeric key of an object
+		{Path{{Key: "0"}, {Key: "1"}}, map[string]string{"/0/1": `"x"`}},
+		{Path{{Key: "o"}, {Key: "0"}}, map[string]string{"/o/0": `"key"`}},
+		{Path{{Key: "o"}, {Key: "0", Index: true}}, map[string]string{}},
+		{Path{{Key: "arr"}, {Key: "0"}}, map[string]string{"/arr/0": `"elem"`}},
+		{Path{{Key: "o"}, {Each: true}}, map[string]string{}},
+		{Path{{Key: "s/t"}, {Key: "~"}}, map[string]string{"/s~1t/~0": "true"}},
+	} {
+		ls := c.p.Locate(j)
+		got := make(map[string]string)
+		for _, l := range ls {
+			if len(c.p) == 0 {
+				got[l.Ptr] = ""
+			} else {
+				got[l.Ptr] = jsnStr(l.Val)
+			}
+
+			// The pointer is of the value.
+			ts, err := ParsePtr(l.Ptr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, ok := Resolve(j, ts); !ok || !Equal(v, l.Val) {
+				t.Errorf("%v: located %s at %q; which holds %s", c.p, jsnStr(l.Val), l.Ptr, jsnStr(v))
+			}
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got %q; want %q", c.p, got, c.want)
+		}
+
+		if vs := c.p.Values(j); len(vs) != len(ls) {
+			t.Errorf("%v: got %d values; but %d located", c.p, len(vs), len(ls))
+		}
+	}
+}
+
+func TestPtrPath(t *testing.T) {
+	p, err := PtrPath("/a~1b/0/~0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Path{{Key: "a/b"}, {Key: "0"}, {Key: "~"}}); !p.Equal(want) {
+		t.Errorf("got %v; want %v", p, want)
+	}
+
+	_, err = PtrPath("a")
+	if err == nil {
+		t.Errorf("got a path of an invalid json pointer")
+	}
+}
@@ -0,0 +1,716 @@
+package jsn
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled json schema; a subset of draft 2020-12 (see https://json-schema.org).
+//
+// Supported are the boolean schemas, and the keywords type, enum, const, properties, patternProperties, additionalProperties, required, propertyNames, minProperties, maxProperties, prefixItems, items, contains, minItems, maxItems, uniqueItems, minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf, allOf, anyOf, oneOf, not, if, then, else, $ref (local ones only, e.g. "#/$defs/x"), and $defs.
+// The annotations (e.g. title, and format) are ignored, and any other keyword is rejected.
+type Schema struct {
+	root *schemaNode
+}
+
+// Violation is a failed assertion of a schema; at Ptr in the json, by the keyword at SchemaPtr in the schema (both are json pointers; see RFC 6901).
+type Violation struct {
+	Ptr       string `json:"ptr"`
+	SchemaPtr string `json:"schemaPtr"`
+	Msg       string `json:"msg"`
+}
+
+func (v *Violation) String() string {
+	return fmt.Sprintf("at %q, %s (by %q)", v.Ptr, v.Msg, v.SchemaPtr)
+}
+
+// ErrSchema is a json which doesn't conform to the schema of its directory; see Manifest.Schema.
+type ErrSchema struct {
+	Path       string
+	Violations []*Violation
+}
+
+func (e *ErrSchema) Error() string {
+	ss := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		ss = append(ss, v.String())
+	}
+
+	return fmt.Sprintf("%q doesn't conform to the schema; %s", e.Path, strings.Join(ss, "; "))
+}
+
+func NewErrSchema(path string, vs []*Violation) *ErrSchema {
+	return &ErrSchema{path, vs}
+}
+
+type schemaNode struct {
+	// ptr is the json pointer of the node in the schema.
+	ptr string
+	// b is the value of a boolean schema.
+	b *bool
+
+	ref      *schemaNode
+	refPtr   string
+	types    []string
+	enum     []interface{}
+	hasEnum  bool
+	konst    interface{}
+	hasConst bool
+
+	props     map[string]*schemaNode
+	patProps  []*patProp
+	addProps  *schemaNode
+	required  []string
+	propNames *schemaNode
+	minProps  int
+	maxProps  int
+
+	prefixItems []*schemaNode
+	items       *schemaNode
+	contains    *schemaNode
+	minItems    int
+	maxItems    int
+	uniqueItems bool
+
+	minLen  int
+	maxLen  int
+	pattern *regexp.Regexp
+
+	min    *big.Rat
+	max    *big.Rat
+	exMin  *big.Rat
+	exMax  *big.Rat
+	multOf *big.Rat
+
+	allOf []*schemaNode
+	anyOf []*schemaNode
+	oneOf []*schemaNode
+	not   *schemaNode
+	ifS   *schemaNode
+	thenS *schemaNode
+	elseS *schemaNode
+}
+
+type patProp struct {
+	re *regexp.Regexp
+	n  *schemaNode
+}
+
+// Annotations, and the like; they don't assert anything.
+var schemaIgnoredKeywords = map[string]bool{
+	"$schema": true, "$id": true, "$anchor": true, "$comment": true, "$vocabulary": true,
+	"title": true, "description": true, "default": true, "examples": true, "deprecated": true, "readOnly": true, "writeOnly": true,
+	"format": true, "contentEncoding": true, "contentMediaType": true, "contentSchema": true,
+}
+
+var schemaTypes = map[string]bool{"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true}
+
+type schemaCompiler struct {
+	raw interface{}
+	// nodes holds the compiled nodes by their pointers; for the references.
+	nodes map[string]*schemaNode
+	refs  []*schemaNode
+}
+
+// NewSchema compiles json schema j; fails if it's not a valid one, or it uses an unsupported keyword (see Schema).
+func NewSchema(j interface{}) (*Schema, error) {
+	c := &schemaCompiler{raw: j, nodes: make(map[string]*schemaNode)}
+	root, err := c.compile(j, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve the references; compiling a referenced node may add more.
+	for i := 0; i < len(c.refs); i++ {
+		n := c.refs[i]
+		ts, err := ParsePtr(n.refPtr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema; at %q, unresolvable reference; %w", n.ptr+"/$ref", err)
+		}
+
+		// Normalized, for the lookup
+		ptr := ""
+		for _, t := range ts {
+			ptr += "/" + EscapePtrToken(t)
+		}
+
+		if rn, ok := c.nodes[ptr]; ok {
+			n.ref = rn
+			continue
+		}
+
+		raw, ok := Resolve(c.raw, ts)
+		if !ok {
+			return nil, fmt.Errorf("invalid schema; at %q, unresolvable reference %q", n.ptr+"/$ref", n.refPtr)
+		}
+		n.ref, err = c.compile(raw, ptr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = c.errIfLoops()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Schema{root}, nil
+}
+
+// errIfLoops fails if the subschemas applying in place (see inPlace) loop back to one of them, e.g. {"$ref": "#"}; the validation would never end.
+// The loops descending into the value (e.g. of a tree, through its children) are fine.
+func (c *schemaCompiler) errIfLoops() error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	states := make(map[*schemaNode]int)
+
+	var visit func(n *schemaNode) error
+	visit = func(n *schemaNode) error {
+		switch states[n] {
+		case visiting:
+			return fmt.Errorf("invalid schema; at %q, the subschemas (e.g. a $ref) loop back to it, without descending into the value", n.ptr)
+		case visited:
+			return nil
+		}
+
+		states[n] = visiting
+		for _, s := range n.inPlace() {
+			err := visit(s)
+			if err != nil {
+				return err
+			}
+		}
+		states[n] = visited
+
+		return nil
+	}
+
+	ptrs := make([]string, 0, len(c.nodes))
+	for ptr := range c.nodes {
+		ptrs = append(ptrs, ptr)
+	}
+	sort.Strings(ptrs)
+
+	for _, ptr := range ptrs {
+		err := visit(c.nodes[ptr])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inPlace returns the subschemas of n which apply to the same value as n (e.g. its reference, and allOf); unlike the ones of its members, or elements.
+func (n *schemaNode) inPlace() []*schemaNode {
+	ns := make([]*schemaNode, 0)
+	ns = append(ns, n.allOf...)
+	ns = append(ns, n.anyOf...)
+	ns = append(ns, n.oneOf...)
+	for _, s := range []*schemaNode{n.ref, n.not, n.ifS, n.thenS, n.elseS} {
+		if s != nil {
+			ns = append(ns, s)
+		}
+	}
+
+	return ns
+}
+
+func (c *schemaCompiler) compile(j interface{}, ptr string) (*schemaNode, error) {
+	n := &schemaNode{ptr: ptr, minProps: -1, maxProps: -1, minItems: -1, maxItems: -1, minLen: -1, maxLen: -1}
+	c.nodes[ptr] = n
+	fail := func(kw string, format string, args ...interface{}) error {
+		return fmt.Errorf("invalid schema; at %q, %s", ptr+"/"+EscapePtrToken(kw), fmt.Sprintf(format, args...))
+	}
+
+	if b, ok := j.(bool); ok {
+		n.b = &b
+		return n, nil
+	}
+
+	jo, ok := j.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid schema; at %q, expected an object or a boolean, not %s", ptr, jsnTypeName(j))
+	}
+
+	ks := make([]string, 0, len(jo))
+	for k := range jo {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+
+	var err error
+	sub := func(kw string, v interface{}) (*schemaNode, error) {
+		return c.compile(v, ptr+"/"+EscapePtrToken(kw))
+	}
+	subs := func(kw string, v interface{}) ([]*schemaNode, error) {
+		a, ok := v.([]interface{})
+		if !ok || len(a) == 0 {
+			return nil, fail(kw, "expected a non-empty array of schemas")
+		}
+
+		ns := make([]*schemaNode, 0, len(a))
+		for i, e := range a {
+			n, err := c.compile(e, ptr+"/"+EscapePtrToken(kw)+"/"+strconv.Itoa(i))
+			if err != nil {
+				return nil, err
+			}
+			ns = append(ns, n)
+		}
+
+		return ns, nil
+	}
+	subMap := func(kw string, v interface{}) (map[string]*schemaNode, error) {
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fail(kw, "expected an object of schemas")
+		}
+
+		ns := make(map[string]*schemaNode, len(o))
+		for k, e := range o {
+			n, err := c.compile(e, ptr+"/"+EscapePtrToken(kw)+"/"+EscapePtrToken(k))
+			if err != nil {
+				return nil, err
+			}
+			ns[k] = n
+		}
+
+		return ns, nil
+	}
+	count := func(kw string, v interface{}) (int, error) {
+		r, ok := numRat(v)
+		if !ok || !r.IsInt() || r.Sign() < 0 || !r.Num().IsInt64() {
+			return 0, fail(kw, "expected a non-negative integer")
+		}
+
+		return int(r.Num().Int64()), nil
+	}
+	num := func(kw string, v interface{}) (*big.Rat, error) {
+		r, ok := numRat(v)
+		if !ok {
+			return nil, fail(kw, "expected a number")
+		}
+
+		return r, nil
+	}
+	re := func(kw string, v interface{}) (*regexp.Regexp, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fail(kw, "expected a string")
+		}
+
+		r, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fail(kw, "invalid regular expression %q; %v", s, err)
+		}
+
+		return r, nil
+	}
+
+	for _, k := range ks {
+		v := jo[k]
+		switch k {
+		case "$ref":
+			s, ok := v.(string)
+			if !ok || !strings.HasPrefix(s, "#") {
+				return nil, fail(k, "unsupported reference %v; only the local ones (e.g. \"#/$defs/x\") are supported", v)
+			}
+			n.refPtr, err = url.PathUnescape(s[1:])
+			if err != nil {
+				return nil, fail(k, "invalid reference %q; %v", s, err)
+			}
+			c.refs = append(c.refs, n)
+		case "$defs":
+			_, err = subMap(k, v)
+		case "type":
+			switch x := v.(type) {
+			case string:
+				n.types = []string{x}
+			case []interface{}:
+				for _, t := range x {
+					if s, ok := t.(string); ok {
+						n.types = append(n.types, s)
+					} else {
+						return nil, fail(k, "expected a string, or an array of strings")
+					}
+				}
+			default:
+				return nil, fail(k, "expected a string, or an array of strings")
+			}
+			for _, t := range n.types {
+				if !schemaTypes[t] {
+					return nil, fail(k, "unknown type %q", t)
+				}
+			}
+		case "enum":
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil, fail(k, "expected an array")
+			}
+			n.enum, n.hasEnum = a, true
+		case "const":
+			n.konst, n.hasConst = v, true
+		case "properties":
+			n.props, err = subMap(k, v)
+		case "patternProperties":
+			var ns map[string]*schemaNode
+			ns, err = subMap(k, v)
+			if err != nil {
+				break
+			}
+			ps := make([]string, 0, len(ns))
+			for p := range ns {
+				ps = append(ps, p)
+			}
+			sort.Strings(ps)
+			for _, p := range ps {
+				r, err := re(k, p)
+				if err != nil {
+					return nil, err
+				}
+				n.patProps = append(n.patProps, &patProp{r, ns[p]})
+			}
+		case "additionalProperties":
+			n.addProps, err = sub(k, v)
+		case "required":
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil, fail(k, "expected an array of strings")
+			}
+			for _, e := range a {
+				s, ok := e.(string)
+				if !ok {
+					return nil, fail(k, "expected an array of strings")
+				}
+				n.required = append(n.required, s)
+			}
+		case "propertyNames":
+			n.propNames, err = sub(k, v)
+		case "minProperties":
+			n.minProps, err = count(k, v)
+		case "maxProperties":
+			n.maxProps, err = count(k, v)
+		case "prefixItems":
+			n.prefixItems, err = subs(k, v)
+		case "items":
+			n.items, err = sub(k, v)
+		case "contains":
+			n.contains, err = sub(k, v)
+		case "minItems":
+			n.minItems, err = count(k, v)
+		case "maxItems":
+			n.maxItems, err = count(k, v)
+		case "uniqueItems":
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fail(k, "expected a boolean")
+			}
+			n.uniqueItems = b
+		case "minLength":
+			n.minLen, err = count(k, v)
+		case "maxLength":
+			n.maxLen, err = count(k, v)
+		case "pattern":
+			n.pattern, err = re(k, v)
+		case "minimum":
+			n.min, err = num(k, v)
+		case "maximum":
+			n.max, err = num(k, v)
+		case "exclusiveMinimum":
+			n.exMin, err = num(k, v)
+		case "exclusiveMaximum":
+			n.exMax, err = num(k, v)
+		case "multipleOf":
+			n.multOf, err = num(k, v)
+			if err == nil && n.multOf.Sign() <= 0 {
+				return nil, fail(k, "expected a positive number")
+			}
+		case "allOf":
+			n.allOf, err = subs(k, v)
+		case "anyOf":
+			n.anyOf, err = subs(k, v)
+		case "oneOf":
+			n.oneOf, err = subs(k, v)
+		case "not":
+			n.not, err = sub(k, v)
+		case "if":
+			n.ifS, err = sub(k, v)
+		case "then":
+			n.thenS, err = sub(k, v)
+		case "else":
+			n.elseS, err = sub(k, v)
+		default:
+			if !schemaIgnoredKeywords[k] {
+				return nil, fail(k, "unsupported keyword %q", k)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// Validate returns the violations of the schema by j; none if j conforms to it.
+func (s *Schema) Validate(j interface{}) []*Violation {
+	return s.root.validate(j, "")
+}
+
+func (n *schemaNode) valid(j interface{}) bool {
+	return len(n.validate(j, "")) == 0
+}
+
+func (n *schemaNode) validate(j interface{}, ptr string) []*Violation {
+	vs := make([]*Violation, 0)
+	fail := func(kw string, format string, args ...interface{}) {
+		vs = append(vs, &Violation{ptr, n.ptr + "/" + kw, fmt.Sprintf(format, args...)})
+	}
+
+	if n.b != nil {
+		if !*n.b {
+			vs = append(vs, &Violation{ptr, n.ptr, "no value is allowed"})
+		}
+		return vs
+	}
+
+	if n.ref != nil {
+		vs = append(vs, n.ref.validate(j, ptr)...)
+	}
+
+	if n.types != nil && !jsnTypeIn(j, n.types) {
+		fail("type", "expected %s, not %s", strings.Join(n.types, " or "), jsnTypeName(j))
+	}
+
+	if n.hasEnum {
+		found := false
+		for _, e := range n.enum {
+			if Equal(j, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "expected one of %s", jsnStr(n.enum))
+		}
+	}
+
+	if n.hasConst && !Equal(j, n.konst) {
+		fail("const", "expected %s", jsnStr(n.konst))
+	}
+
+	switch x := j.(type) {
+	case string:
+		l := utf8.RuneCountInString(x)
+		if n.minLen != -1 && l < n.minLen {
+			fail("minLength", "expected at least %d characters, not %d", n.minLen, l)
+		}
+		if n.maxLen != -1 && l > n.maxLen {
+			fail("maxLength", "expected at most %d characters, not %d", n.maxLen, l)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(x) {
+			fail("pattern", "expected a match of %q", n.pattern.String())
+		}
+	case json.Number, float64:
+		r, ok := numRat(x)
+		if !ok {
+			fail("type", "expected a finite number")
+			break
+		}
+		if n.min != nil && r.Cmp(n.min) < 0 {
+			fail("minimum", "expected at least %s", n.min.RatString())
+		}
+		if n.max != nil && r.Cmp(n.max) > 0 {
+			fail("maximum", "expected at most %s", n.max.RatString())
+		}
+		if n.exMin != nil && r.Cmp(n.exMin) <= 0 {
+			fail("exclusiveMinimum", "expected more than %s", n.exMin.RatString())
+		}
+		if n.exMax != nil && r.Cmp(n.exMax) >= 0 {
+			fail("exclusiveMaximum", "expected less than %s", n.exMax.RatString())
+		}
+		if n.multOf != nil && !new(big.Rat).Quo(r, n.multOf).IsInt() {
+			fail("multipleOf", "expected a multiple of %s", n.multOf.RatString())
+		}
+	case []interface{}:
+		for i, e := range x {
+			ePtr := ptr + "/" + strconv.Itoa(i)
+			if i < len(n.prefixItems) {
+				vs = append(vs, n.prefixItems[i].validate(e, ePtr)...)
+			} else if n.items != nil {
+				vs = append(vs, n.items.validate(e, ePtr)...)
+			}
+		}
+		if n.contains != nil {
+			found := false
+			for _, e := range x {
+				if n.contains.valid(e) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				fail("contains", "expected an element matching the schema")
+			}
+		}
+		if n.minItems != -1 && len(x) < n.minItems {
+			fail("minItems", "expected at least %d elements, not %d", n.minItems, len(x))
+		}
+		if n.maxItems != -1 && len(x) > n.maxItems {
+			fail("maxItems", "expected at most %d elements, not %d", n.maxItems, len(x))
+		}
+		if n.uniqueItems {
+		Unique:
+			for i := range x {
+				for j := i + 1; j < len(x); j++ {
+					if Equal(x[i], x[j]) {
+						fail("uniqueItems", "expected unique elements; %d and %d are equal", i, j)
+						break Unique
+					}
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, k := range n.required {
+			if _, ok := x[k]; !ok {
+				fail("required", "expected property %q", k)
+			}
+		}
+
+		ks := make([]string, 0, len(x))
+		for k := range x {
+			ks = append(ks, k)
+		}
+		sort.Strings(ks)
+
+		for _, k := range ks {
+			v := x[k]
+			vPtr := ptr + "/" + EscapePtrToken(k)
+			matched := false
+			if pn, ok := n.props[k]; ok {
+				matched = true
+				vs = append(vs, pn.validate(v, vPtr)...)
+			}
+			for _, pp := range n.patProps {
+				if pp.re.MatchString(k) {
+					matched = true
+					vs = append(vs, pp.n.validate(v, vPtr)...)
+				}
+			}
+			if !matched && n.addProps != nil {
+				vs = append(vs, n.addProps.validate(v, vPtr)...)
+			}
+			if n.propNames != nil {
+				vs = append(vs, n.propNames.validate(k, vPtr)...)
+			}
+		}
+
+		if n.minProps != -1 && len(x) < n.minProps {
+			fail("minProperties", "expected at least %d properties, not %d", n.minProps, len(x))
+		}
+		if n.maxProps != -1 && len(x) > n.maxProps {
+			fail("maxProperties", "expected at most %d properties, not %d", n.maxProps, len(x))
+		}
+	}
+
+	for _, s := range n.allOf {
+		vs = append(vs, s.validate(j, ptr)...)
+	}
+
+	if n.anyOf != nil {
+		found := false
+		for _, s := range n.anyOf {
+			if s.valid(j) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("anyOf", "expected a match of any of the schemas")
+		}
+	}
+
+	if n.oneOf != nil {
+		c := 0
+		for _, s := range n.oneOf {
+			if s.valid(j) {
+				c++
+			}
+		}
+		if c != 1 {
+			fail("oneOf", "expected a match of exactly one of the schemas, not %d", c)
+		}
+	}
+
+	if n.not != nil && n.not.valid(j) {
+		fail("not", "expected no match of the schema")
+	}
+
+	if n.ifS != nil {
+		if n.ifS.valid(j) {
+			if n.thenS != nil {
+				vs = append(vs, n.thenS.validate(j, ptr)...)
+			}
+		} else if n.elseS != nil {
+			vs = append(vs, n.elseS.validate(j, ptr)...)
+		}
+	}
+
+	return vs
+}
+
+// jsnTypeName returns the name of the type of j, as of json schema.
+func jsnTypeName(j interface{}) string {
+	switch j.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", j)
+	}
+}
+
+func jsnTypeIn(j interface{}, ts []string) bool {
+	tn := jsnTypeName(j)
+	for _, t := range ts {
+		if t == tn {
+			return true
+		}
+
+		if t == "integer" && tn == "number" {
+			if r, ok := numRat(j); ok && r.IsInt() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// jsnStr encodes j for the messages.
+func jsnStr(j interface{}) string {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return fmt.Sprint(j)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,52 @@
+package jsn
+
+import (
+	"testing"
+)
+
+func TestSchemaRefLoops(t *testing.T) {
+	for _, s := range []string{
+		`{"$ref": "#"}`,
+		`{"$ref": "#/$defs/x", "$defs": {"x": {"$ref": "#/$defs/x"}}}`,
+		`{"$defs": {"x": {"$ref": "#/$defs/y"}, "y": {"$ref": "#/$defs/x"}}, "properties": {"a": {"$ref": "#/$defs/x"}}}`,
+		`{"allOf": [{"$ref": "#"}]}`,
+		`{"$defs": {"x": {"not": {"$ref": "#/$defs/x"}}}, "items": {"$ref": "#/$defs/x"}}`,
+	} {
+		j, err := StrToJsn(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = NewSchema(j)
+		if err == nil {
+			t.Errorf("%s: compiled a looping schema", s)
+		}
+	}
+}
+
+func TestSchemaRecursive(t *testing.T) {
+	// A tree; its loop descends into the children.
+	j, err := StrToJsn(`{"type": "object", "required": ["v"], "properties": {"v": {"type": "integer"}, "children": {"type": "array", "items": {"$ref": "#"}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSchema(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for in, want := range map[string]int{
+		`{"v": 1, "children": [{"v": 2}, {"v": 3, "children": [{"v": 4}]}]}`:   0,
+		`{"v": 1, "children": [{"v": 2}, {"v": 3, "children": [{"v": "x"}]}]}`: 1,
+		`{"v": 1, "children": [{}, {"v": 3, "children": [{"v": 4}, {}]}]}`:     2,
+	} {
+		j, err := StrToJsn(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if vs := s.Validate(j); len(vs) != want {
+			t.Errorf("%s: got %d violations (%v); want %d", in, len(vs), vs, want)
+		}
+	}
+}
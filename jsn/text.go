@@ -0,0 +1,129 @@
+package jsn
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Hit is a json matching a text search, along with its relevance score; see Index.Search.
+type Hit struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// Parameters of the Okapi BM25 ranking function; the usual ones.
+const bm25K1 = 1.2
+const bm25B = 0.75
+
+// Words splits s into its words; the case-folded runs of letters and digits.
+func Words(s string) []string {
+	ws := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range ws {
+		ws[i] = strings.ToLower(w)
+	}
+
+	return ws
+}
+
+// textEntries returns the entries of the named json j in the text index, and its number of words; the words of all the strings at (or nested in the values at) the path of the index.
+func (ix *Index) textEntries(name string, j interface{}) ([]*IndexEntry, int) {
+	freqs := make(map[string]int)
+	l := 0
+	var walk func(j interface{})
+	walk = func(j interface{}) {
+		switch x := j.(type) {
+		case string:
+			for _, w := range Words(x) {
+				freqs[w]++
+				l++
+			}
+		case map[string]interface{}:
+			for _, v := range x {
+				walk(v)
+			}
+		case []interface{}:
+			for _, v := range x {
+				walk(v)
+			}
+		}
+	}
+	for _, v := range ix.Path.Values(j) {
+		walk(v)
+	}
+
+	es := make([]*IndexEntry, 0, len(freqs))
+	for w, f := range freqs {
+		es = append(es, &IndexEntry{Val: w, Name: name, Freq: f})
+	}
+
+	return es, l
+}
+
+// Search returns the jsons holding any of the words of q (see Words) in the text index, best first; ranked by the Okapi BM25 function.
+func (ix *Index) Search(q string) []*Hit {
+	n := len(ix.Lens)
+	if n == 0 {
+		return []*Hit{}
+	}
+
+	total := 0
+	for _, l := range ix.Lens {
+		total += l
+	}
+	avgLen := float64(total) / float64(n)
+
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, w := range Words(q) {
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+
+		es := ix.entries(w)
+		idf := math.Log(1 + (float64(n)-float64(len(es))+0.5)/(float64(len(es))+0.5))
+		for _, e := range es {
+			f := float64(e.Freq)
+			norm := 1 - bm25B + bm25B*float64(ix.Lens[e.Name])/avgLen
+			scores[e.Name] += idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+		}
+	}
+
+	hs := make([]*Hit, 0, len(scores))
+	for name, s := range scores {
+		hs = append(hs, &Hit{name, s})
+	}
+	SortHits(hs)
+
+	return hs
+}
+
+// entries returns the entries of value v.
+func (ix *Index) entries(v interface{}) []*IndexEntry {
+	es := ix.Entries
+	i := sort.Search(len(es), func(i int) bool {
+		c, _ := Cmp(es[i].Val, v)
+		return c >= 0
+	})
+	j := sort.Search(len(es), func(i int) bool {
+		c, _ := Cmp(es[i].Val, v)
+		return c > 0
+	})
+
+	return es[i:j]
+}
+
+// SortHits sorts hs by their scores, best first; the ties by their names.
+func SortHits(hs []*Hit) {
+	sort.Slice(hs, func(i, j int) bool {
+		if hs[i].Score != hs[j].Score {
+			return hs[i].Score > hs[j].Score
+		}
+
+		return hs[i].Name < hs[j].Name
+	})
+}
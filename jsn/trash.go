@@ -0,0 +1,41 @@
+package jsn
+
+import (
+	"github.com/agcom/dirb/bin"
+	"time"
+)
+
+func (d *Dir) Trashed() ([]*bin.TrashEntry, error) {
+	return d.BinDir().Trashed()
+}
+
+// Untrash is like bin.Dir.Untrash, but also updates the indexes of the restored json; see Index.
+func (d *Dir) Untrash(id string) error {
+	es, err := d.Trashed()
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	for _, e := range es {
+		if e.Id == id {
+			name = e.Name
+		}
+	}
+	if name == "" {
+		// Let it fail
+		return d.BinDir().Untrash(id)
+	}
+
+	return writeIndexedLck(d.Path(name), d.BinDir().Opts(), func() error {
+		return d.BinDir().Untrash(id)
+	})
+}
+
+func (d *Dir) Purge(id string) error {
+	return d.BinDir().Purge(id)
+}
+
+func (d *Dir) PurgeTrash(maxAge time.Duration) error {
+	return d.BinDir().PurgeTrash(maxAge)
+}
@@ -0,0 +1,40 @@
+package jsn
+
+import (
+	"errors"
+	"github.com/agcom/dirb/bin"
+	"reflect"
+	"testing"
+)
+
+func TestUntrash(t *testing.T) {
+	d := NewDirOpts(newTestDir(t, 2).BinDir().Dir(), &bin.Opts{Trash: true})
+	err := d.Rm("0.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testEq(testIndex(t, d, "n"), 0); len(got) != 0 {
+		t.Errorf("got %q of 0 in the index after the remove; want none", got)
+	}
+
+	es, err := d.Trashed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 {
+		t.Fatalf("got %+v; want an entry", es)
+	}
+	err = d.Untrash(es[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testEq(testIndex(t, d, "n"), 0); !reflect.DeepEqual(got, []string{"0.json"}) {
+		t.Errorf("got %q of 0 in the index after the restore; want 0.json", got)
+	}
+
+	var errNotExist *bin.ErrNotExist
+	err = d.Untrash(es[0].Id)
+	if !errors.As(err, &errNotExist) {
+		t.Errorf("got %v of restoring a restored entry; want a bin.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,173 @@
+package jsn
+
+import (
+	"errors"
+	"fmt"
+	"github.com/agcom/dirb/bin"
+	"go.uber.org/multierr"
+)
+
+// Tx is a bin.Tx of jsons.
+type Tx bin.Tx
+
+func (d *Dir) Begin() *Tx {
+	return (*Tx)(d.BinDir().Begin())
+}
+
+func (t *Tx) BinTx() *bin.Tx {
+	return (*bin.Tx)(t)
+}
+
+func (t *Tx) New(name string, j interface{}) error {
+	return t.BinTx().New(name, jsnToReader(j))
+}
+
+func (t *Tx) Get(name string) (rJ interface{}, rErr error) {
+	r, err := t.BinTx().Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := r.Close()
+		if err != nil {
+			rErr = multierr.Append(rErr, fmt.Errorf("failed to close binary %q; %w", r.Name(), err))
+		}
+	}()
+
+	j, err := ReaderToJsn(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %q into a json; %w", r.Name(), err)
+	}
+
+	return j, nil
+}
+
+func (t *Tx) Over(name string, j interface{}) error {
+	return t.BinTx().Over(name, jsnToReader(j))
+}
+
+// Up applies j on the named json as a merge patch; see MergePatch.
+func (t *Tx) Up(name string, j interface{}) error {
+	return t.UpMode(name, j, MergeModePatch)
+}
+
+// UpMode is like Up, but merges j into the json by mode m.
+func (t *Tx) UpMode(name string, j interface{}, m MergeMode) error {
+	jOld, err := t.Get(name)
+	if err != nil {
+		return err
+	}
+
+	return t.Over(name, m.Merge(jOld, j))
+}
+
+func (t *Tx) Rm(name string) error {
+	return t.BinTx().Rm(name)
+}
+
+// Commit is like bin.Tx.Commit, but also updates the indexes of the touched jsons after; see Index.
+// Fails, before committing, with an ErrSchema if a staged json doesn't conform to the schema of the directory, or with an ErrUnique if the staged changes violate a unique constraint of it; see Manifest.
+func (t *Tx) Commit() error {
+	bt := t.BinTx()
+	ps := make([]string, 0, len(bt.Names()))
+	for _, n := range bt.Names() {
+		ps = append(ps, bt.Dir().Path(n))
+	}
+
+	unmarks := make([]func() error, 0, len(ps))
+	for _, p := range ps {
+		unmark, err := markPending(p)
+		if err != nil {
+			for _, unmark := range unmarks {
+				err = multierr.Append(err, unmark())
+			}
+			return multierr.Append(err, t.Rollback())
+		}
+		unmarks = append(unmarks, unmark)
+	}
+
+	dir := bt.Dir().Dir()
+	o := bt.Dir().Opts()
+	m, err := ReadManifest(dir)
+	var js map[string]*indexable
+	if err == nil {
+		js, err = t.staged()
+	}
+	if err == nil {
+		for _, n := range bt.Names() {
+			err = m.validate(bt.Dir().Path(n), js[n])
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		for _, unmark := range unmarks {
+			err = multierr.Append(err, unmark())
+		}
+		return multierr.Append(err, t.Rollback())
+	}
+
+	uixs, unlck, err := lckUnique(dir, m, o)
+	if err != nil {
+		for _, unmark := range unmarks {
+			err = multierr.Append(err, unmark())
+		}
+		return multierr.Append(err, t.Rollback())
+	}
+
+	err = checkUnique(dir, uixs, js)
+	if err != nil {
+		err = multierr.Append(err, unlck())
+		for _, unmark := range unmarks {
+			err = multierr.Append(err, unmark())
+		}
+		return multierr.Append(err, t.Rollback())
+	}
+
+	// Reindex even if the commit fails; it may have failed after applying the changes.
+	err = bt.Commit()
+	var ixErr error
+	if err == nil {
+		// Before releasing their locks; the touched jsons are reindexed below, after their locks are released by the commit.
+		for _, ix := range uixs {
+			ixErr = multierr.Append(ixErr, ix.log(dir, js, indexOpts(o)))
+		}
+	}
+	ixErr = multierr.Append(ixErr, unlck())
+	for i, p := range ps {
+		err := reindexLck(p, o)
+		if err != nil {
+			// Left pending
+			ixErr = multierr.Append(ixErr, err)
+		} else {
+			ixErr = multierr.Append(ixErr, unmarks[i]())
+		}
+	}
+
+	return multierr.Append(err, ixErr)
+}
+
+// staged returns the contents of the touched jsons (by their names), as seen by the transaction.
+func (t *Tx) staged() (map[string]*indexable, error) {
+	ns := t.BinTx().Names()
+	js := make(map[string]*indexable, len(ns))
+	for _, n := range ns {
+		j, err := t.Get(n)
+		if err != nil {
+			var errNotExist *bin.ErrNotExist
+			if errors.As(err, &errNotExist) {
+				js[n] = &indexable{}
+				continue
+			}
+			return nil, err
+		}
+		js[n] = &indexable{j, true}
+	}
+
+	return js, nil
+}
+
+func (t *Tx) Rollback() error {
+	return t.BinTx().Rollback()
+}
@@ -0,0 +1,225 @@
+package jsn
+
+import (
+	"errors"
+	"fmt"
+	"github.com/agcom/dirb/bin"
+	"go.uber.org/multierr"
+	"path/filepath"
+	"sort"
+)
+
+// ErrUnique is a violated unique constraint; the json at Path holds a value which the json Holder already holds.
+type ErrUnique struct {
+	Path       string
+	Constraint string
+	Val        interface{}
+	Holder     string
+}
+
+func (e *ErrUnique) Error() string {
+	return fmt.Sprintf("%q violates unique constraint %q; %s is already held by %q", e.Path, e.Constraint, jsnStr(e.Val), e.Holder)
+}
+
+func NewErrUnique(path, constraint string, val interface{}, holder string) *ErrUnique {
+	return &ErrUnique{path, constraint, val, holder}
+}
+
+// AddUnique declares unique constraint name of the jsons of dir, on the values at path p, and creates its index; fails with an ErrUnique if the jsons already violate it.
+// It's declared before its index is created; so any write which misses it is either awaited and seen by the index (see fillIndex), or updates the index before the final check.
+func AddUnique(dir string, name string, p Path, o *bin.Opts) error {
+	ixPath := filepath.Join(IndexDir(dir), name)
+	err := UpdateManifest(dir, o, func(m *Manifest) error {
+		if m.unique(name) != nil {
+			return fmt.Errorf("unique constraint %q already exists", name)
+		} else if m.index(name) != nil {
+			return bin.NewErrExists(ixPath)
+		}
+
+		// Don't take over an existing index.
+		err := bin.ErrIfExists(ixPath)
+		if err != nil {
+			return err
+		}
+
+		m.Unique = append(m.Unique, &Unique{name, p})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = createIndex(dir, name, &Index{Path: p, Entries: []*IndexEntry{}}, o)
+	if err != nil {
+		// A failed createIndex leaves no index behind, nor removes one created by another process in between.
+		return multierr.Append(err, rmUniqueDecl(dir, name, o))
+	}
+
+	err = checkUniqueIndex(dir, name, o)
+	if err != nil {
+		return multierr.Append(err, RmUnique(dir, name, o))
+	}
+
+	return nil
+}
+
+// RmUnique removes unique constraint name of the jsons of dir, along with its index.
+func RmUnique(dir string, name string, o *bin.Opts) error {
+	err := rmUniqueDecl(dir, name, o)
+	if err != nil {
+		return err
+	}
+
+	err = rmIndex(filepath.Join(IndexDir(dir), name), indexOpts(o))
+	if err != nil {
+		var errNotExist *bin.ErrNotExist
+		if !errors.As(err, &errNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func rmUniqueDecl(dir string, name string, o *bin.Opts) error {
+	return UpdateManifest(dir, o, func(m *Manifest) error {
+		us := make([]*Unique, 0, len(m.Unique))
+		for _, u := range m.Unique {
+			if u.Name != name {
+				us = append(us, u)
+			}
+		}
+		if len(us) == len(m.Unique) {
+			return fmt.Errorf("no unique constraint %q", name)
+		}
+
+		m.Unique = us
+		return nil
+	})
+}
+
+// checkUniqueIndex returns an ErrUnique if the index of unique constraint name holds a value of two jsons.
+func checkUniqueIndex(dir string, name string, o *bin.Opts) error {
+	ix, err := readIndexRLck(filepath.Join(IndexDir(dir), name), indexOpts(o))
+	if err != nil {
+		return err
+	}
+
+	es := ix.Entries
+	for i := 1; i < len(es); i++ {
+		if c, _ := Cmp(es[i-1].Val, es[i].Val); c == 0 && es[i-1].Name != es[i].Name {
+			return NewErrUnique(filepath.Join(dir, es[i].Name), name, es[i].Val, es[i-1].Name)
+		}
+	}
+
+	return nil
+}
+
+// lckUnique acquires the locks of the (existing) indexes of the unique constraints of dir (declared in its manifest m), in the order of their names; returns them (read under the locks), and the function which releases the locks.
+// The writers hold them from checking the new contents (see checkUnique) until updating the indexes; so two concurrent writes can't both take a value.
+func lckUnique(dir string, m *Manifest, o *bin.Opts) ([]*Index, func() error, error) {
+	ns := make([]string, 0, len(m.Unique))
+	for _, u := range m.Unique {
+		ns = append(ns, u.Name)
+	}
+	sort.Strings(ns)
+
+	o = indexOpts(o)
+	unlcks := make([]func() error, 0, len(ns))
+	unlck := func() error {
+		var rErr error
+		for _, unlck := range unlcks {
+			rErr = multierr.Append(rErr, unlck())
+		}
+		return rErr
+	}
+
+	uixs := make([]*Index, 0, len(ns))
+	for _, n := range ns {
+		ixPath := filepath.Join(IndexDir(dir), n)
+		err := bin.ErrIfNotExist(ixPath)
+		if err != nil {
+			var errNotExist *bin.ErrNotExist
+			if errors.As(err, &errNotExist) {
+				// Not yet created; see AddUnique.
+				continue
+			}
+			return nil, nil, multierr.Append(err, unlck())
+		}
+
+		lckPath := bin.DefLckPath(ixPath)
+		lckFile, err := bin.WLck(lckPath, o)
+		if err != nil {
+			return nil, nil, multierr.Append(err, unlck())
+		}
+		unlcks = append(unlcks, func() error {
+			return bin.Unlck(lckPath, lckFile)
+		})
+
+		ix, err := readIndex(ixPath)
+		if err != nil {
+			var errNotExist *bin.ErrNotExist
+			if errors.As(err, &errNotExist) {
+				// Just removed
+				continue
+			}
+			return nil, nil, multierr.Append(err, unlck())
+		}
+		uixs = append(uixs, ix)
+	}
+
+	return uixs, unlck, nil
+}
+
+// checkUnique returns an ErrUnique if the given new contents of the jsons of dir (by their names) violate any of the unique constraints, whose indexes are uixs.
+// The values the other jsons already share are not of its concern; each new value is looked up in the indexes, instead of merging them.
+func checkUnique(dir string, uixs []*Index, js map[string]*indexable) error {
+	for _, ix := range uixs {
+		es := make([]*IndexEntry, 0)
+		for _, r := range ix.recs(js) {
+			es = append(es, r.Entries...)
+		}
+		sort.SliceStable(es, func(i, j int) bool {
+			c, _ := Cmp(es[i].Val, es[j].Val)
+			return c < 0
+		})
+
+		for i, e := range es {
+			// Among the new contents
+			if i > 0 {
+				if p := es[i-1]; p.Name != e.Name {
+					if c, _ := Cmp(p.Val, e.Val); c == 0 {
+						return NewErrUnique(filepath.Join(dir, e.Name), ix.Name, e.Val, p.Name)
+					}
+				}
+			}
+
+			// Against the other jsons
+			for _, h := range ix.entries(e.Val) {
+				if _, ok := js[h.Name]; !ok {
+					return NewErrUnique(filepath.Join(dir, e.Name), ix.Name, e.Val, h.Name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// indexNameSet returns the set of the names of ixs.
+func indexNameSet(ixs []*Index) map[string]bool {
+	ns := make(map[string]bool, len(ixs))
+	for _, ix := range ixs {
+		ns[ix.Name] = true
+	}
+
+	return ns
+}
+
+func (d *Dir) AddUnique(name string, p Path) error {
+	return AddUnique(d.BinDir().Dir(), name, p, d.BinDir().Opts())
+}
+
+func (d *Dir) RmUnique(name string) error {
+	return RmUnique(d.BinDir().Dir(), name, d.BinDir().Opts())
+}
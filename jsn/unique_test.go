@@ -0,0 +1,149 @@
+package jsn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newUniqueTestDir(t *testing.T) *Dir {
+	t.Helper()
+	d := NewDir(t.TempDir())
+	err := d.AddUnique("isbn", Path{{Key: "isbn"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestUniqueConcurrentCreates(t *testing.T) {
+	d := newUniqueTestDir(t)
+
+	const n = 15
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = d.New(fmt.Sprint(i, ".json"), map[string]interface{}{"isbn": "978-0"})
+		}(i)
+	}
+	wg.Wait()
+
+	wins := 0
+	for i, err := range errs {
+		var errUnique *ErrUnique
+		if err == nil {
+			wins++
+		} else if !errors.As(err, &errUnique) {
+			t.Errorf("create %d: got %v; want an ErrUnique", i, err)
+		}
+	}
+	if wins != 1 {
+		t.Errorf("%d creates won; want exactly one", wins)
+	}
+
+	ns, err := d.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 1 {
+		t.Errorf("got %d jsons; want the winner only", len(ns))
+	}
+}
+
+func TestUniqueTx(t *testing.T) {
+	d := newUniqueTestDir(t)
+	err := d.New("a.json", map[string]interface{}{"isbn": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, js := range []map[string]interface{}{
+		// Against an existing json
+		{"b.json": map[string]interface{}{"isbn": "1"}},
+		// Within the transaction
+		{"b.json": map[string]interface{}{"isbn": "2"}, "c.json": map[string]interface{}{"isbn": "2"}},
+	} {
+		tx := d.Begin()
+		for n, j := range js {
+			err := tx.New(n, j)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := tx.Commit()
+		var errUnique *ErrUnique
+		if !errors.As(err, &errUnique) {
+			t.Errorf("got %v; want an ErrUnique", err)
+		}
+
+		ns, err := d.All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ns) != 1 {
+			t.Errorf("got %q; want the violating transaction rolled back", ns)
+		}
+	}
+
+	// Swapping the values is not a violation.
+	err = d.New("b.json", map[string]interface{}{"isbn": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := d.Begin()
+	err = tx.Over("a.json", map[string]interface{}{"isbn": "2"})
+	if err == nil {
+		err = tx.Over("b.json", map[string]interface{}{"isbn": "1"})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddUniqueDuplicates(t *testing.T) {
+	d := NewDir(t.TempDir())
+	for n, isbn := range map[string]string{"a.json": "1", "b.json": "2", "c.json": "1"} {
+		err := d.New(n, map[string]interface{}{"isbn": isbn})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := d.AddUnique("isbn", Path{{Key: "isbn"}})
+	var errUnique *ErrUnique
+	if !errors.As(err, &errUnique) {
+		t.Fatalf("got %v; want an ErrUnique", err)
+	}
+
+	m, err := d.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Unique) != 0 {
+		t.Errorf("the violated constraint is left declared")
+	}
+	_, err = os.Stat(filepath.Join(IndexDir(d.BinDir().Dir()), "isbn"))
+	if !os.IsNotExist(err) {
+		t.Errorf("the index of the violated constraint is left behind; %v", err)
+	}
+
+	// Still a free name
+	err = d.Rm("c.json")
+	if err == nil {
+		err = d.AddUnique("isbn", Path{{Key: "isbn"}})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
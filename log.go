@@ -63,3 +63,17 @@ func fatalMultiErr(err error) {
 	multiErr(err)
 	os.Exit(1)
 }
+
+func warningf(format string, v ...interface{}) {
+	lvlf("Warning", format, v...)
+}
+
+func warning(v interface{}) {
+	lvl("Warning", v)
+}
+
+func multiWarning(err error) {
+	for _, err := range multierr.Errors(err) {
+		warning(err)
+	}
+}
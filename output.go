@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/agcom/dirb/jsn"
+	"io"
+	"os"
+)
+
+// outputFormat is how the documents (or their projections) of the matching instances are printed; see resultPrinter.
+type outputFormat int
+
+const (
+	// A json object per line
+	outputNDJSON outputFormat = iota
+	// A json array of them
+	outputArray
+	// A tab-indented json object per instance
+	outputPretty
+)
+
+func parseOutputFormat(s string) (outputFormat, error) {
+	switch s {
+	case "ndjson":
+		return outputNDJSON, nil
+	case "array":
+		return outputArray, nil
+	case "pretty":
+		return outputPretty, nil
+	default:
+		return 0, fmt.Errorf("unknown format %q; should be \"ndjson\", \"array\", or \"pretty\"", s)
+	}
+}
+
+// projField is a field of a projection; src is how it's written (e.g. `authors[0]`), which is its key in the projection.
+type projField struct {
+	src string
+	ref fieldRef
+}
+
+// parseProjection parses s as a comma-separated list of fields (see queryExpr), e.g. `title,authors[*]`; a '\' escapes a ',' of a field.
+func parseProjection(s string) ([]*projField, error) {
+	pfs := make([]*projField, 0)
+	for _, src := range splitUnescaped(s, ',') {
+		ref, err := parseFieldRef(src)
+		if err != nil {
+			return nil, err
+		}
+
+		pfs = append(pfs, &projField{src, ref})
+	}
+
+	return pfs, nil
+}
+
+// project returns the projection of instance jo on fields pfs; an object of the values of the fields, by their sources.
+// A field with many values (i.e. having a "[*]") is projected to an array of them, and a missing one is left out.
+func project(jo map[string]interface{}, pfs []*projField) map[string]interface{} {
+	r := make(map[string]interface{}, len(pfs))
+	for _, pf := range pfs {
+		vs := jsn.Path(pf.ref).Values(jo)
+
+		each := false
+		for _, e := range pf.ref {
+			each = each || e.Each
+		}
+
+		if each {
+			r[pf.src] = vs
+		} else if len(vs) == 1 {
+			r[pf.src] = vs[0]
+		}
+	}
+
+	return r
+}
+
+// What find prints of each matching instance; its name, or (if printDocs) a json object of its name and its document (or its projection on the fields of projection, if any), e.g. {"name": "x", "json": {...}}.
+var printDocs = false
+var projection []*projField
+var outFormat = outputNDJSON
+
+// docResult is what find prints of a matching instance, if printDocs.
+type docResult struct {
+	Name string      `json:"name"`
+	JSON interface{} `json:"json"`
+}
+
+// resultPrinter prints the matching instances of d, one at a time, into w; see printDocs.
+type resultPrinter struct {
+	d *dir
+	w io.Writer
+	n int
+}
+
+func newResultPrinter(d *dir) *resultPrinter {
+	return &resultPrinter{d: d, w: os.Stdout}
+}
+
+// print prints instance name, whose document is jo; it's read (under its shared lock) if it's nil, and needed.
+func (p *resultPrinter) print(name string, jo map[string]interface{}) bool {
+	if !printDocs {
+		fmt.Fprintln(p.w, name)
+		return true
+	}
+
+	if jo == nil {
+		var err error
+		jo, err = p.d.getObj(name)
+		if err != nil {
+			errorr(err)
+			return false
+		}
+	}
+
+	var j interface{} = jo
+	if projection != nil {
+		j = project(jo, projection)
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	if outFormat == outputPretty {
+		enc.SetIndent("", "\t")
+	}
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(&docResult{name, j})
+	if err != nil {
+		fatalf("failed to encode instance %q; %v", name, err)
+	}
+
+	if outFormat == outputArray {
+		if p.n == 0 {
+			fmt.Fprint(p.w, "[\n")
+		} else {
+			fmt.Fprint(p.w, ",\n")
+		}
+		// Without the trailing newline
+		b.Truncate(b.Len() - 1)
+	}
+	_, err = p.w.Write(b.Bytes())
+	if err != nil {
+		fatalf("failed to write instance %q; %v", name, err)
+	}
+	p.n++
+
+	return true
+}
+
+// close ends the output.
+func (p *resultPrinter) close() {
+	if printDocs && outFormat == outputArray {
+		if p.n == 0 {
+			fmt.Fprintln(p.w, "[]")
+		} else {
+			fmt.Fprint(p.w, "\n]\n")
+		}
+	}
+}
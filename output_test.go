@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"github.com/agcom/dirb/jsn"
+	"testing"
+)
+
+func TestResultPrinter(t *testing.T) {
+	dirr = newDirOpts(t.TempDir(), nil)
+	defer func() {
+		dirr, printDocs, projection, outFormat = nil, false, nil, outputNDJSON
+	}()
+	for n, s := range map[string]string{"a": `{"t": "x", "authors": ["p", "q"], "n": 1.50}`, "b": `{"t": "<y>"}`} {
+		jo, err := jsn.StrToJsnObj(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = dirr.new(n, jo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pfs, err := parseProjection(`t,authors[*],authors[1],missing,root.n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		docs   bool
+		proj   []*projField
+		format outputFormat
+		names  []string
+		want   string
+	}{
+		{false, nil, outputArray, []string{"a", "b"}, "a\nb\n"},
+		{true, nil, outputNDJSON, []string{"a", "b"}, `{"name":"a","json":{"authors":["p","q"],"n":1.50,"t":"x"}}` + "\n" + `{"name":"b","json":{"t":"<y>"}}` + "\n"},
+		{true, nil, outputNDJSON, nil, ""},
+		{true, nil, outputArray, []string{"a", "b"}, "[\n" + `{"name":"a","json":{"authors":["p","q"],"n":1.50,"t":"x"}}` + ",\n" + `{"name":"b","json":{"t":"<y>"}}` + "\n]\n"},
+		{true, nil, outputArray, nil, "[]\n"},
+		{true, nil, outputPretty, []string{"b"}, "{\n\t\"name\": \"b\",\n\t\"json\": {\n\t\t\"t\": \"<y>\"\n\t}\n}\n"},
+		{true, pfs, outputNDJSON, []string{"a", "b"}, `{"name":"a","json":{"authors[*]":["p","q"],"authors[1]":"q","root.n":1.50,"t":"x"}}` + "\n" + `{"name":"b","json":{"authors[*]":[],"t":"<y>"}}` + "\n"},
+	} {
+		printDocs, projection, outFormat = c.docs, c.proj, c.format
+		var b bytes.Buffer
+		p := &resultPrinter{d: dirr, w: &b}
+		for _, n := range c.names {
+			if !p.print(n, nil) {
+				t.Fatalf("failed to print %q", n)
+			}
+		}
+		p.close()
+
+		if got := b.String(); got != c.want {
+			t.Errorf("docs %v, fields %v, format %d: got\n%s\nwant\n%s", c.docs, c.proj != nil, c.format, got, c.want)
+		}
+	}
+}
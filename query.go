@@ -0,0 +1,653 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/agcom/dirb/jsn"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// A query is a boolean expression over the fields of an instance, e.g. `lang == "en" and (pages > 300 or not translated == false)`.
+//
+//	expr    := and ("or" and)*
+//	and     := not ("and" not)*
+//	not     := "not" not | primary
+//	primary := "(" expr ")" | operand op operand
+//	operand := literal | field
+//	op      := "<" | "<=" | ">" | ">=" | "==" | "!=" | "in" | "!in"
+//
+// A literal is a json value (e.g. "en", 300, true, or [1, 2]), and a field is a reference to a (nested) field of the instance, e.g. `a.b.c`; "root" refers to the instance itself, a "[*]" refers to each element of an array (e.g. `authors[*].name`), an "[n]" to element n of one (e.g. `authors[0]`), and a '\' escapes the next character (e.g. `a\.b` is field "a.b").
+// A numeric key also refers to an element of an array, as in a json pointer (e.g. `authors.0`); a field can be written as a json pointer too (see RFC 6901), e.g. `/authors/0`.
+// A comparison holds if any of the values of its operands satisfy it; e.g. `authors[*] == "x"` holds if any of the authors is "x".
+// The "and", "or", and "not" keywords can also be written as "&&", "||", and "!".
+type queryExpr interface {
+	eval(jo map[string]interface{}) bool
+}
+
+type andExpr struct {
+	l, r queryExpr
+}
+
+func (e *andExpr) eval(jo map[string]interface{}) bool {
+	return e.l.eval(jo) && e.r.eval(jo)
+}
+
+type orExpr struct {
+	l, r queryExpr
+}
+
+func (e *orExpr) eval(jo map[string]interface{}) bool {
+	return e.l.eval(jo) || e.r.eval(jo)
+}
+
+type notExpr struct {
+	e queryExpr
+}
+
+func (e *notExpr) eval(jo map[string]interface{}) bool {
+	return !e.e.eval(jo)
+}
+
+// cmpExpr is false if any of its fields is missing in the instance.
+type cmpExpr struct {
+	l, r operand
+	sym  string
+	op   func(interface{}, interface{}) bool
+}
+
+func (e *cmpExpr) eval(jo map[string]interface{}) bool {
+	rs := e.r.values(jo)
+	for _, l := range e.l.values(jo) {
+		for _, r := range rs {
+			if e.op(l, r) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type operand interface {
+	// values returns the values of the operand for instance jo; none if it's missing.
+	values(jo map[string]interface{}) []interface{}
+}
+
+type litOperand struct {
+	v interface{}
+}
+
+func (o *litOperand) values(map[string]interface{}) []interface{} {
+	return []interface{}{o.v}
+}
+
+type fieldRef jsn.Path
+
+func (ref fieldRef) values(jo map[string]interface{}) []interface{} {
+	return jsn.Path(ref).Values(jo)
+}
+
+// String returns the source of ref, e.g. `a.b[*]`; see lexWord.
+// A ref with an empty key, or a leading "root" one (which the dotted form can't express), is written as a json pointer; see lexPtr.
+func (ref fieldRef) String() string {
+	if len(ref) == 0 {
+		return "root"
+	}
+
+	for i, e := range ref {
+		if !e.Each && !e.Index && (e.Key == "" || (i == 0 && e.Key == "root")) {
+			return ref.ptrString()
+		}
+	}
+
+	var b strings.Builder
+	if e := ref[0]; e.Each || e.Index {
+		b.WriteString("root")
+	}
+	for i, e := range ref {
+		if e.Each {
+			b.WriteString("[*]")
+			continue
+		} else if e.Index {
+			b.WriteString("[" + e.Key + "]")
+			continue
+		}
+
+		if i > 0 {
+			b.WriteByte('.')
+		}
+
+		k := e.Key
+		switch k {
+		case "and", "or", "not", "in", "true", "false", "null":
+			if len(ref) == 1 {
+				b.WriteByte('\\')
+			}
+		}
+		for j := 0; j < len(k); j++ {
+			c := k[j]
+			if c == '\\' || c == '.' || isQueryDelim(c) || (i == 0 && j == 0 && strings.IndexByte("\"[{-0123456789/", c) >= 0) {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// ptrString returns the source of ref as a json pointer; its steps into each element of an array are written as "*" (which is not what lexPtr reads).
+func (ref fieldRef) ptrString() string {
+	var b strings.Builder
+	for _, e := range ref {
+		b.WriteByte('/')
+		if e.Each {
+			b.WriteByte('*')
+			continue
+		}
+
+		k := jsn.EscapePtrToken(e.Key)
+		for j := 0; j < len(k); j++ {
+			if k[j] == '\\' || isQueryDelim(k[j]) {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(k[j])
+		}
+	}
+
+	return b.String()
+}
+
+type tknKind int
+
+const (
+	tknEOF tknKind = iota
+	tknLParen
+	tknRParen
+	tknAnd
+	tknOr
+	tknNot
+	tknOp
+	tknLit
+	tknField
+)
+
+type tkn struct {
+	kind tknKind
+	pos  int
+	s    string // Op, or the source
+	v    interface{}
+	ref  fieldRef
+}
+
+func (t *tkn) String() string {
+	if t.kind == tknEOF {
+		return "end of query"
+	}
+
+	return fmt.Sprintf("%q at %d", t.s, t.pos)
+}
+
+// Special characters of the query; can't be used in a field, unless escaped.
+const querySpecials = "()<>=!&|\"[]{}"
+
+func lexQuery(s string) ([]*tkn, error) {
+	ts := make([]*tkn, 0)
+	for i := 0; ; {
+		for i < len(s) && unicode.IsSpace(rune(s[i])) {
+			i++
+		}
+		if i == len(s) {
+			return append(ts, &tkn{kind: tknEOF, pos: i}), nil
+		}
+
+		rest := s[i:]
+		switch {
+		case rest[0] == '(':
+			ts = append(ts, &tkn{kind: tknLParen, pos: i, s: "("})
+			i++
+		case rest[0] == ')':
+			ts = append(ts, &tkn{kind: tknRParen, pos: i, s: ")"})
+			i++
+		case strings.HasPrefix(rest, "&&"):
+			ts = append(ts, &tkn{kind: tknAnd, pos: i, s: "&&"})
+			i += 2
+		case strings.HasPrefix(rest, "||"):
+			ts = append(ts, &tkn{kind: tknOr, pos: i, s: "||"})
+			i += 2
+		case strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, ">="), strings.HasPrefix(rest, "=="), strings.HasPrefix(rest, "!="):
+			ts = append(ts, &tkn{kind: tknOp, pos: i, s: rest[:2]})
+			i += 2
+		case rest[0] == '<', rest[0] == '>':
+			ts = append(ts, &tkn{kind: tknOp, pos: i, s: rest[:1]})
+			i++
+		case strings.HasPrefix(rest, "!in") && (len(rest) == 3 || isQueryDelim(rest[3])):
+			ts = append(ts, &tkn{kind: tknOp, pos: i, s: "!in"})
+			i += 3
+		case rest[0] == '!':
+			ts = append(ts, &tkn{kind: tknNot, pos: i, s: "!"})
+			i++
+		case strings.ContainsRune("\"[{-0123456789", rune(rest[0])):
+			dec := json.NewDecoder(strings.NewReader(rest))
+			dec.UseNumber()
+			var v interface{}
+			err := dec.Decode(&v)
+			if err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, fmt.Errorf("invalid literal at %d; %w", i, err)
+			}
+
+			n := int(dec.InputOffset())
+			ts = append(ts, &tkn{kind: tknLit, pos: i, s: rest[:n], v: v})
+			i += n
+		case rest[0] == '=', rest[0] == '&', rest[0] == '|', rest[0] == ']', rest[0] == '}':
+			return nil, fmt.Errorf("unexpected %q at %d", rest[0], i)
+		default:
+			t, n, err := lexWord(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%v at %d", err, i)
+			}
+
+			t.pos = i
+			ts = append(ts, t)
+			i += n
+		}
+	}
+}
+
+func isQueryDelim(c byte) bool {
+	return unicode.IsSpace(rune(c)) || strings.IndexByte(querySpecials, c) >= 0
+}
+
+// lexWord lexes a keyword, a keyword literal (e.g. true), or a field, at the start of s; returns the consumed length too.
+func lexWord(s string) (*tkn, int, error) {
+	if s[0] == '/' {
+		return lexPtr(s)
+	}
+
+	ref := make(fieldRef, 0, 1)
+	var seg strings.Builder
+	escaped := false
+	// After a "[*]", or an "[n]"
+	afterBracket := false
+
+	i := 0
+	for ; i < len(s); i++ {
+		c := s[i]
+		if escaped {
+			seg.WriteByte(c)
+			escaped = false
+		} else if c == '[' && (strings.HasPrefix(s[i:], "[*]") || isIndexBracket(s[i:])) {
+			if !afterBracket {
+				ref = append(ref, jsn.PathElem{Key: seg.String()})
+				seg.Reset()
+			}
+
+			n := strings.IndexByte(s[i:], ']')
+			if s[i+1] == '*' {
+				ref = append(ref, jsn.PathElem{Each: true})
+			} else {
+				ref = append(ref, jsn.PathElem{Key: s[i+1 : i+n], Index: true})
+			}
+			afterBracket = true
+			i += n
+		} else if c == '.' {
+			if !afterBracket {
+				ref = append(ref, jsn.PathElem{Key: seg.String()})
+				seg.Reset()
+			}
+			afterBracket = false
+		} else if isQueryDelim(c) {
+			break
+		} else if afterBracket {
+			return nil, 0, fmt.Errorf("expected '.' or '[' after ']' in %q", s[:i+1])
+		} else if c == '\\' {
+			escaped = true
+		} else {
+			seg.WriteByte(c)
+		}
+	}
+	if escaped {
+		return nil, 0, fmt.Errorf("dangling escape character")
+	}
+	if !afterBracket {
+		ref = append(ref, jsn.PathElem{Key: seg.String()})
+	}
+
+	w := s[:i]
+	switch w {
+	case "and":
+		return &tkn{kind: tknAnd, s: w}, i, nil
+	case "or":
+		return &tkn{kind: tknOr, s: w}, i, nil
+	case "not":
+		return &tkn{kind: tknNot, s: w}, i, nil
+	case "in":
+		return &tkn{kind: tknOp, s: w}, i, nil
+	case "true":
+		return &tkn{kind: tknLit, s: w, v: true}, i, nil
+	case "false":
+		return &tkn{kind: tknLit, s: w, v: false}, i, nil
+	case "null":
+		return &tkn{kind: tknLit, s: w, v: nil}, i, nil
+	}
+
+	for _, e := range ref {
+		if !e.Each && !e.Index && e.Key == "" {
+			return nil, 0, fmt.Errorf("empty field name in %q", w)
+		}
+	}
+
+	// A leading "root" is the instance itself.
+	if e := ref[0]; !e.Each && !e.Index && e.Key == "root" {
+		ref = ref[1:]
+	}
+
+	return &tkn{kind: tknField, s: w, ref: ref}, i, nil
+}
+
+// isIndexBracket reports whether s starts with an array index in brackets, e.g. "[0]".
+func isIndexBracket(s string) bool {
+	n := strings.IndexByte(s, ']')
+	if n < 2 {
+		return false
+	}
+
+	d := s[1:n]
+	return strings.TrimLeft(d, "0123456789") == "" && (d == "0" || d[0] != '0')
+}
+
+// lexPtr lexes a field written as a json pointer (e.g. `/authors/0`; see RFC 6901), at the start of s; returns the consumed length too.
+// Like the other fields, it ends at a delimiter of the query, unless it's escaped by a '\'.
+func lexPtr(s string) (*tkn, int, error) {
+	var b strings.Builder
+	escaped := false
+
+	i := 0
+	for ; i < len(s); i++ {
+		c := s[i]
+		if escaped {
+			b.WriteByte(c)
+			escaped = false
+		} else if isQueryDelim(c) {
+			break
+		} else if c == '\\' {
+			escaped = true
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	if escaped {
+		return nil, 0, fmt.Errorf("dangling escape character")
+	}
+
+	p, err := jsn.PtrPath(b.String())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &tkn{kind: tknField, s: s[:i], ref: fieldRef(p)}, i, nil
+}
+
+// parseFieldRef parses s as a field of a query, e.g. `a.b`.
+func parseFieldRef(s string) (fieldRef, error) {
+	if s == "" {
+		return nil, fmt.Errorf("invalid field reference %q; empty", s)
+	}
+
+	t, n, err := lexWord(s)
+	if err == nil && (n != len(s) || t.kind != tknField) {
+		err = fmt.Errorf("not a field")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid field reference %q; %w", s, err)
+	}
+
+	return t.ref, nil
+}
+
+type queryParser struct {
+	ts []*tkn
+	i  int
+}
+
+func parseQuery(s string) (queryExpr, error) {
+	ts, err := lexQuery(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query; %w", err)
+	}
+
+	p := &queryParser{ts: ts}
+	e, err := p.parseOr()
+	if err == nil && p.peek().kind != tknEOF {
+		err = fmt.Errorf("unexpected %v", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid query; %w", err)
+	}
+
+	return e, nil
+}
+
+func (p *queryParser) peek() *tkn {
+	return p.ts[p.i]
+}
+
+func (p *queryParser) next() *tkn {
+	t := p.ts[p.i]
+	if t.kind != tknEOF {
+		p.i++
+	}
+
+	return t
+}
+
+func (p *queryParser) parseOr() (queryExpr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tknOr {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		l = &orExpr{l, r}
+	}
+
+	return l, nil
+}
+
+func (p *queryParser) parseAnd() (queryExpr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tknAnd {
+		p.next()
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		l = &andExpr{l, r}
+	}
+
+	return l, nil
+}
+
+func (p *queryParser) parseNot() (queryExpr, error) {
+	if p.peek().kind == tknNot {
+		p.next()
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return &notExpr{e}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryExpr, error) {
+	if p.peek().kind == tknLParen {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		t := p.next()
+		if t.kind != tknRParen {
+			return nil, fmt.Errorf("expected \")\", but got %v", t)
+		}
+
+		return e, nil
+	}
+
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.next()
+	if t.kind != tknOp {
+		return nil, fmt.Errorf("expected an operator, but got %v", t)
+	}
+
+	op, err := opFunc(t.s)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmpExpr{l, r, t.s, op}, nil
+}
+
+func (p *queryParser) parseOperand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tknLit:
+		return &litOperand{t.v}, nil
+	case tknField:
+		return t.ref, nil
+	default:
+		return nil, fmt.Errorf("expected a literal or a field, but got %v", t)
+	}
+}
+
+// lookupIndexes returns the names of the instances which may satisfy e (a superset of them), looked up in the indexes on its fields; false if e can't be answered by the indexes, e.g. it has a comparison on a field without an index.
+// Only the comparisons of a field with a primitive literal by the ==, <, <=, >, and >= operators can be looked up; see jsn.Index.Range.
+func lookupIndexes(e queryExpr, ixs []*jsn.Index) (map[string]bool, bool) {
+	switch x := e.(type) {
+	case *andExpr:
+		l, lok := lookupIndexes(x.l, ixs)
+		r, rok := lookupIndexes(x.r, ixs)
+		// If a side can't be looked up, the instances of the other side are filtered by it later.
+		if !lok {
+			return r, rok
+		} else if !rok {
+			return l, lok
+		}
+
+		ns := make(map[string]bool)
+		for n := range l {
+			if r[n] {
+				ns[n] = true
+			}
+		}
+
+		return ns, true
+	case *orExpr:
+		l, lok := lookupIndexes(x.l, ixs)
+		if !lok {
+			return nil, false
+		}
+
+		r, rok := lookupIndexes(x.r, ixs)
+		if !rok {
+			return nil, false
+		}
+
+		for n := range r {
+			l[n] = true
+		}
+
+		return l, true
+	case *cmpExpr:
+		return lookupIndexesCmp(x, ixs)
+	default:
+		return nil, false
+	}
+}
+
+func lookupIndexesCmp(e *cmpExpr, ixs []*jsn.Index) (map[string]bool, bool) {
+	ref, lok := e.l.(fieldRef)
+	lit, rok := e.r.(*litOperand)
+	sym := e.sym
+	if !lok || !rok {
+		// Maybe `literal op field`; flip it.
+		ref, lok = e.r.(fieldRef)
+		lit, rok = e.l.(*litOperand)
+		switch sym {
+		case "<":
+			sym = ">"
+		case "<=":
+			sym = ">="
+		case ">":
+			sym = "<"
+		case ">=":
+			sym = "<="
+		}
+	}
+	if !lok || !rok || !jsn.Ordered(lit.v) {
+		return nil, false
+	}
+
+	var ix *jsn.Index
+	for _, cand := range ixs {
+		if !cand.Text && cand.Path.Equal(jsn.Path(ref)) {
+			ix = cand
+			break
+		}
+	}
+	if ix == nil {
+		return nil, false
+	}
+
+	var lo, hi *jsn.Bound
+	switch sym {
+	case "==":
+		lo, hi = &jsn.Bound{Val: lit.v, Incl: true}, &jsn.Bound{Val: lit.v, Incl: true}
+	case "<":
+		hi = &jsn.Bound{Val: lit.v}
+	case "<=":
+		hi = &jsn.Bound{Val: lit.v, Incl: true}
+	case ">":
+		lo = &jsn.Bound{Val: lit.v}
+	case ">=":
+		lo = &jsn.Bound{Val: lit.v, Incl: true}
+	default:
+		return nil, false
+	}
+
+	ns := make(map[string]bool)
+	for _, n := range ix.Range(lo, hi) {
+		ns[n] = true
+	}
+
+	return ns, true
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"github.com/agcom/dirb/bin"
+	"github.com/agcom/dirb/jsn"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestQueryEval(t *testing.T) {
+	docs := map[string]string{
+		"pages":  `{"pages": 464}`,
+		"one":    `{"n": 1}`,
+		"oneDec": `{"n": 1.0}`,
+		"oneStr": `{"n": "1"}`,
+		"big":    `{"n": 12345678901234567890.000000000000000001}`,
+		"tags":   `{"tags": [1, "a", null]}`,
+		"nested": `{"o": {"a": [1, {"b": 2}]}, "arrs": [[1, 2], [3, 4]]}`,
+	}
+
+	tests := []struct {
+		doc  string
+		q    string
+		want bool
+	}{
+		// Numerically, not by their texts
+		{"pages", "pages < 90", false},
+		{"pages", "pages > 90", true},
+		{"pages", "pages >= 464.0", true},
+		{"pages", "pages == 464", true},
+		{"pages", "pages == 4.64e2", true},
+		{"pages", "pages != 464.0", false},
+
+		{"one", "n == 1.0", true},
+		{"oneDec", "n == 1", true},
+		{"oneDec", "n != 1", false},
+		{"one", "n in [1.0, 2]", true},
+		{"oneDec", "n in [1, 2]", true},
+
+		// Beyond float64
+		{"big", "n == 12345678901234567890.000000000000000001", true},
+		{"big", "n == 12345678901234567890", false},
+		{"big", "n > 12345678901234567890", true},
+		{"big", "n < 12345678901234567890.00000000000000001", true},
+
+		// Mixed types are never equal
+		{"oneStr", "n == 1", false},
+		{"one", "n == \"1\"", false},
+		{"oneStr", "n == \"1\"", true},
+		{"one", "n == true", false},
+		{"one", "n == null", false},
+		{"tags", "1.0 in tags", true},
+		{"tags", "\"1\" in tags", false},
+		{"tags", "null in tags", true},
+
+		// Arrays element by element, in order; as jsn.Equal.
+		{"tags", `tags == [1, "a", null]`, true},
+		{"tags", `tags == [1.0, "a", null]`, true},
+		{"tags", `tags == ["a", 1, null]`, false},
+		{"tags", `tags != ["a", 1, null]`, true},
+		{"tags", `tags == [1, "a"]`, false},
+		{"nested", `o == {"a": [1.0, {"b": 2}]}`, true},
+		{"nested", `o == {"a": [{"b": 2}, 1]}`, false},
+		{"nested", `[1, 2] in arrs`, true},
+		{"nested", `[2, 1] in arrs`, false},
+	}
+
+	for _, tt := range tests {
+		jo, err := jsn.StrToJsnObj(docs[tt.doc])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q, err := parseQuery(tt.q)
+		if err != nil {
+			t.Errorf("%s: %v", tt.q, err)
+			continue
+		}
+
+		if got := q.eval(jo); got != tt.want {
+			t.Errorf("%s on %s = %v; want %v", tt.q, docs[tt.doc], got, tt.want)
+		}
+	}
+}
+
+func TestQueryEqual(t *testing.T) {
+	vals := []string{`1`, `1.0`, `"1"`, `null`, `true`, `[1, 2]`, `[2, 1]`, `[1.0, 2]`, `[1, 2, 2]`, `{"a": [1, 2]}`, `{"a": [2, 1]}`, `{"a": [1, 2], "b": null}`, `{}`, `[]`}
+	for _, s1 := range vals {
+		for _, s2 := range vals {
+			j1, err := jsn.StrToJsn(s1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			j2, err := jsn.StrToJsn(s2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := jsn.Equal(j1, j2)
+
+			// The same as the json patch test; and, on the primitives, as the unique constraints (see jsn.Cmp).
+			q, err := parseQuery("v == " + s2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.eval(map[string]interface{}{"v": j1}); got != want {
+				t.Errorf("v == %s on %s = %v; want %v", s2, s1, got, want)
+			}
+
+			p, err := jsn.StrToJsn(`[{"op": "test", "path": "/v", "value": ` + s2 + `}]`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pp, err := jsn.ParsePatch(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = pp.Apply(map[string]interface{}{"v": j1})
+			if got := err == nil; got != want {
+				t.Errorf("test %s on %s = %v; want %v", s2, s1, got, want)
+			}
+		}
+	}
+}
+
+// findTest returns the names of the instances of dirr matching query s; through the indexes, if indexed (and fails if they can't be used), or by scanning all the instances.
+func findTest(t *testing.T, s string, indexed bool) []string {
+	t.Helper()
+	q, err := parseQuery(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns, found := findCands(q)
+	if indexed && !found {
+		t.Fatalf("%s: not looked up in the indexes", s)
+	} else if !indexed {
+		ns, err = dirr.all()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ms := make([]string, 0)
+	for _, n := range ns {
+		jo, err := dirr.getObjBare(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.eval(jo) {
+			ms = append(ms, n)
+		}
+	}
+	sort.Strings(ms)
+
+	return ms
+}
+
+func TestFindIndexed(t *testing.T) {
+	d := t.TempDir()
+	dirr = newDirOpts(d, &bin.Opts{Trash: true})
+	defer func() {
+		dirr = nil
+	}()
+
+	for i := 0; i < 30; i++ {
+		var pages interface{} = i * 37 % 500
+		if i%7 == 0 {
+			pages = fmt.Sprint(pages)
+		}
+		jo := map[string]interface{}{"pages": pages, "lang": []string{"en", "fa", "de"}[i%3], "tags": []interface{}{i % 4, "t"}}
+		if i%11 == 0 {
+			delete(jo, "pages")
+		}
+
+		err := dirr.new(fmt.Sprint("b", i), jo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for n, f := range map[string]string{"pages": "pages", "lang": "lang", "tags": "tags[*]"} {
+		ref, err := parseFieldRef(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = dirr.createIndex(n, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	qs := []string{
+		"pages > 300",
+		"pages <= 74",
+		"pages == 74",
+		"pages == \"259\"",
+		"lang == \"en\" and pages < 200",
+		"lang == \"fa\" or lang == \"de\"",
+		"tags[*] == 2",
+		"tags[*] >= 3 and not pages > 100",
+	}
+	check := func(step string) {
+		t.Helper()
+		for _, s := range qs {
+			want := findTest(t, s, false)
+			got := findTest(t, s, true)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("after %s, %s: got %q through the indexes; want %q", step, s, got, want)
+			}
+		}
+	}
+	check("create")
+
+	for i := 0; i < 30; i += 3 {
+		err := dirr.up(fmt.Sprint("b", i), map[string]interface{}{"pages": i * 17, "lang": "en"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("update")
+
+	for i := 1; i < 30; i += 4 {
+		err := dirr.rm(fmt.Sprint("b", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("remove")
+
+	es, err := dirr.trashed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range es[:3] {
+		err := dirr.untrash(e.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("trash restore")
+
+	tx := dirr.begin()
+	err = tx.over("b2", map[string]interface{}{"pages": 1000, "lang": "fa"})
+	if err == nil {
+		err = tx.rm("b4")
+	}
+	if err == nil {
+		err = tx.new("b100", map[string]interface{}{"pages": 74, "tags": []interface{}{2}})
+	}
+	if err == nil {
+		err = tx.commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("transaction")
+
+	// The metadata are not journaled in nested metadata directories.
+	for _, p := range []string{filepath.Join(jsn.IndexDir(d), bin.MetaDirName), filepath.Join(d, bin.MetaDirName, bin.MetaDirName)} {
+		_, err := os.Stat(p)
+		if !os.IsNotExist(err) {
+			t.Errorf("%q exists", p)
+		}
+	}
+}
+
+func TestParseFieldRef(t *testing.T) {
+	for s, want := range map[string]jsn.Path{
+		"a":         {{Key: "a"}},
+		"a.b":       {{Key: "a"}, {Key: "b"}},
+		"root":      {},
+		"root.a":    {{Key: "a"}},
+		"root[*]":   {{Each: true}},
+		"a.root":    {{Key: "a"}, {Key: "root"}},
+		"a[0]":      {{Key: "a"}, {Key: "0", Index: true}},
+		"a[10]":     {{Key: "a"}, {Key: "10", Index: true}},
+		"a[*]":      {{Key: "a"}, {Each: true}},
+		"a[*].b":    {{Key: "a"}, {Each: true}, {Key: "b"}},
+		"a[*][0]":   {{Key: "a"}, {Each: true}, {Key: "0", Index: true}},
+		"a[*][*]":   {{Key: "a"}, {Each: true}, {Each: true}},
+		"a[1].b[*]": {{Key: "a"}, {Key: "1", Index: true}, {Key: "b"}, {Each: true}},
+		"a.0":       {{Key: "a"}, {Key: "0"}},
+		"a.01":      {{Key: "a"}, {Key: "01"}},
+		"a.*":       {{Key: "a"}, {Key: "*"}},
+		`a\.b`:      {{Key: "a.b"}},
+		`a\ b`:      {{Key: "a b"}},
+		`a\[0\]`:    {{Key: "a[0]"}},
+		`\0`:        {{Key: "0"}},
+		`\and`:      {{Key: "and"}},
+		`a\\`:       {{Key: `a\`}},
+		"/":         {{Key: ""}},
+		"/a/0":      {{Key: "a"}, {Key: "0"}},
+		"/a~1b/~0":  {{Key: "a/b"}, {Key: "~"}},
+		"/~01":      {{Key: "~1"}},
+		"/~10":      {{Key: "/0"}},
+		"/root":     {{Key: "root"}},
+		`/a\ b`:     {{Key: "a b"}},
+		`/a\(b\)`:   {{Key: "a(b)"}},
+		"/a/*":      {{Key: "a"}, {Key: "*"}},
+	} {
+		ref, err := parseFieldRef(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if !jsn.Path(ref).Equal(want) {
+			t.Errorf("%q: got %v; want %v", s, ref, want)
+		}
+	}
+
+	for _, s := range []string{"", "a[01]", "a[00]", "a[0]b", "a[*]b", "a..b", "a.", ".a", "a.[0]", `a\`, "and", "true", "null", "a b", "/~2", "/a~"} {
+		ref, err := parseFieldRef(s)
+		if err == nil {
+			t.Errorf("%q: parsed an invalid field reference into %v", s, ref)
+		}
+	}
+}
+
+func TestFieldRefString(t *testing.T) {
+	for _, ref := range []fieldRef{
+		{},
+		{{Key: "a"}},
+		{{Key: "a"}, {Key: "b"}},
+		{{Key: "a"}, {Each: true}, {Key: "b"}},
+		{{Key: "a"}, {Key: "0", Index: true}, {Each: true}},
+		{{Each: true}},
+		{{Key: "0", Index: true}},
+		{{Key: "0"}},
+		{{Key: "a"}, {Key: "0"}},
+		{{Key: "a.b"}, {Key: "c d"}},
+		{{Key: "a[0]"}},
+		{{Key: "a[*]"}},
+		{{Key: `a\b`}},
+		{{Key: `"a"`}},
+		{{Key: "-a"}},
+		{{Key: "/a"}},
+		{{Key: "a/b"}},
+		{{Key: "~"}},
+		{{Key: "and"}},
+		{{Key: "a"}, {Key: "or"}},
+		{{Key: "true"}},
+		{{Key: "root"}},
+		{{Key: "root"}, {Key: "a"}},
+		{{Key: "a"}, {Key: "root"}},
+		{{Key: ""}},
+		{{Key: "a"}, {Key: ""}, {Key: "b c"}},
+		{{Key: ""}, {Key: "0"}, {Key: "~1"}, {Key: "x/y"}},
+		{{Key: "a"}, {Key: "(b)"}, {Key: "c==d"}},
+	} {
+		s := ref.String()
+		got, err := parseFieldRef(s)
+		if err != nil {
+			t.Errorf("%v: %q; %v", ref, s, err)
+		} else if !jsn.Path(got).Equal(jsn.Path(ref)) {
+			t.Errorf("%v: %q parses into %v", ref, s, got)
+		}
+	}
+}
+
+func TestLexWord(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		n    int
+		kind tknKind
+	}{
+		{"a.b==1", 3, tknField},
+		{"a[*] in [1]", 4, tknField},
+		{"a[0]>1", 4, tknField},
+		{`a\ b == 1`, 4, tknField},
+		{"/a/b<1", 4, tknField},
+		{`/a\ b c`, 5, tknField},
+		{"/a)", 2, tknField},
+		{"and(", 3, tknAnd},
+		{"not x", 3, tknNot},
+		{"null)", 4, tknLit},
+		{"in[", 2, tknOp},
+	} {
+		tk, n, err := lexWord(c.s)
+		if err != nil {
+			t.Errorf("%q: %v", c.s, err)
+		} else if n != c.n || tk.kind != c.kind {
+			t.Errorf("%q: got a %v of length %d; want a %v of length %d", c.s, tk.kind, n, c.kind, c.n)
+		}
+	}
+}
@@ -0,0 +1,813 @@
+package main
+
+import (
+	"fmt"
+	"github.com/agcom/dirb/bin"
+	"github.com/agcom/dirb/jsn"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cmdSpec describes a command; the registry (cmdSpecs) drives the dispatching, the usage and help texts, and the parsing (and validation) of the flags.
+type cmdSpec struct {
+	name     string
+	aliases  []string
+	synopsis string // e.g. "dirb read name [-d path]"
+	short    string
+	long     string
+	flags    []*flagSpec
+	examples []string
+	run      func()
+	// subs are the sub-commands (e.g. history's ls), one of which is run instead of the command; each of its own synopsis, flags, and run.
+	subs   []*cmdSpec
+	parent *cmdSpec
+}
+
+type flagSpec struct {
+	names []string // e.g. "d" and "directory"
+	// arg is the value of the flag, as shown in the help text; an optional one is in brackets (e.g. "[bool]"), and can only be given in the same argument (e.g. -p=false).
+	arg  string
+	desc string
+	// parse parses (and so validates) the value of the flag, e.g. parseBoolFlag; hasVal is false if an optional value is not given. The value is taken as is, if it's nil.
+	parse func(s string, hasVal bool) (interface{}, error)
+}
+
+func (f *flagSpec) optVal() bool {
+	return strings.HasPrefix(f.arg, "[")
+}
+
+// longName returns the last (i.e. the longest) name of the flag, as used in the error messages; e.g. "directory".
+func (f *flagSpec) longName() string {
+	return f.names[len(f.names)-1]
+}
+
+func (f *flagSpec) String() string {
+	ns := make([]string, 0, len(f.names))
+	for _, n := range f.names {
+		if len(n) == 1 {
+			ns = append(ns, "-"+n)
+		} else {
+			ns = append(ns, "--"+n)
+		}
+	}
+
+	s := strings.Join(ns, ", ")
+	if f.arg != "" {
+		s += " " + f.arg
+	}
+
+	return s
+}
+
+func parseBoolFlag(s string, hasVal bool) (interface{}, error) {
+	if !hasVal {
+		return true, nil
+	}
+
+	return parseBoolVal(s)
+}
+
+func parseWaitFlag(s string, hasVal bool) (interface{}, error) {
+	return parseWaitVal(s, hasVal)
+}
+
+func parseDurationFlag(s string, _ bool) (interface{}, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration %q", s)
+	}
+
+	return d, nil
+}
+
+func parseDurabilityFlag(s string, _ bool) (interface{}, error) {
+	return bin.ParseDurability(s)
+}
+
+func parseKeepFlag(s string, _ bool) (interface{}, error) {
+	k, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of revisions %q", s)
+	}
+
+	return k, nil
+}
+
+func parseLimitFlag(s string, _ bool) (interface{}, error) {
+	l, err := strconv.Atoi(s)
+	if err != nil || l < 0 {
+		return nil, fmt.Errorf("invalid limit %q", s)
+	}
+
+	return l, nil
+}
+
+func parseOffsetFlag(s string, _ bool) (interface{}, error) {
+	o, err := strconv.Atoi(s)
+	if err != nil || o < 0 {
+		return nil, fmt.Errorf("invalid offset %q", s)
+	}
+
+	return o, nil
+}
+
+func parseSortFlag(s string, _ bool) (interface{}, error) {
+	return parseSortKeys(s)
+}
+
+func parseFieldsFlag(s string, _ bool) (interface{}, error) {
+	return parseProjection(s)
+}
+
+func parseFormatFlag(s string, _ bool) (interface{}, error) {
+	return parseOutputFormat(s)
+}
+
+func parseMergeFlag(s string, _ bool) (interface{}, error) {
+	return jsn.ParseMergeMode(s)
+}
+
+func parseFieldFlag(s string, _ bool) (interface{}, error) {
+	return parseFieldRef(s)
+}
+
+func parseExtFlag(s string, _ bool) (interface{}, error) {
+	return s, errIfInvalidExt(s)
+}
+
+func parseNamesFlag(s string, _ bool) (interface{}, error) {
+	return s, errIfInvalidNames(s)
+}
+
+func parseNameFlag(s string, _ bool) (interface{}, error) {
+	return s, errIfInvalidName(s)
+}
+
+func parseSchemaFlag(s string, _ bool) (interface{}, error) {
+	if s == "" {
+		return nil, fmt.Errorf("no value assigned to a \"schema\" flag")
+	}
+
+	return s, nil
+}
+
+var flagDir = &flagSpec{[]string{"d", "directory"}, "path", "The directory of the instances; defaults to the working directory.", nil}
+var flagWait = &flagSpec{[]string{"w", "wait"}, "[duration]", "Waits for a locked instance, forever or for at most the given duration (e.g. -w=5s), instead of failing fast.", parseWaitFlag}
+var flagSync = &flagSpec{[]string{"s", "sync"}, "durability", "The durability of the write; \"none\", \"file\", or \"file+dir\" (the default).", parseDurabilityFlag}
+var flagPretty = &flagSpec{[]string{"p", "pretty"}, "[bool]", "Pretty prints the json, indented by tabs.", parseBoolFlag}
+var flagIfMatch = &flagSpec{[]string{"m", "if-match"}, "etag", "Fails if the version token (etag) of the instance is not the given one; see read's -e flag.", nil}
+var flagKeep = &flagSpec{[]string{"k", "keep"}, "n", "Keeps the replaced content as a revision, and at most the last n revisions (all of them, if n is negative); overrides the default of the directory (see the init command). See the history command.", parseKeepFlag}
+var flagSort = &flagSpec{[]string{"sort"}, "field[:desc],...", "Orders by the given fields (e.g. lang,pages:desc), and then by the names; a field with many values by the least of them (or the greatest, if desc), and the instances lacking one come last.", parseSortFlag}
+var flagLimit = &flagSpec{[]string{"limit"}, "n", "Prints at most n names.", parseLimitFlag}
+var flagOffset = &flagSpec{[]string{"offset"}, "n", "Skips the first n names.", parseOffsetFlag}
+var flagTrash = &flagSpec{[]string{"t", "trash"}, "[bool]", "Moves the removed instances into the trash, instead of removing them for good; see the trash command.", parseBoolFlag}
+
+var cmdSpecs []*cmdSpec
+
+func init() {
+	cmdSpecs = []*cmdSpec{
+		{
+			name:     "init",
+			synopsis: "dirb init [--ext ext] [--names strategy] [-s durability] [-k n] [--schema json] [-d path]",
+			short:    "Creates the directory of the instances, along with its manifest.",
+			long: "Creates the directory (and its parents) if it doesn't exist, and writes its manifest (the hidden `.dirb/manifest.json`); the format version, the file extension of the instances, the name generation strategy, the default durability of the writes (when -s is not given), the default number of the revisions they keep (when -k is not given), the json schema, and the indexes. " +
+				"Every command honors the manifest, and refuses a directory of a newer format version.\n" +
+				"Run again to change the given settings; the missing ones keep their values (or get the defaults, in a new manifest). The extension can't be changed while there are instances.\n" +
+				"With --schema, create, update, overwrite, and tx fail on a write whose result doesn't conform to the schema (a subset of draft 2020-12). The existing instances are not checked; see the validate command.",
+			flags: []*flagSpec{
+				{[]string{"ext", "extension"}, "ext", "Sets the file extension of the instances (\".json\", by default).", parseExtFlag},
+				{[]string{"names"}, "strategy", "Sets the name generation strategy; \"random\" (the default), \"uuid\", or \"time\" (names which sort by their creation time).", parseNamesFlag},
+				{[]string{"s", "sync"}, "durability", "Sets the default durability of the writes; \"none\", \"file\", or \"file+dir\" (the default).", parseDurabilityFlag},
+				{[]string{"k", "keep"}, "n", "Sets the default number of the revisions kept by the writes (all of them, if n is negative; none, by default); see the history command.", parseKeepFlag},
+				{[]string{"schema"}, "json", "Sets the schema (or reads it from the standard input, if it's \"-\"; or removes it, if it's null).", parseSchemaFlag},
+				flagDir,
+			},
+			examples: []string{"dirb init -d books", "dirb init --names time -s file", "dirb init -k 10", "dirb init --schema '{\"type\": \"object\", \"required\": [\"title\"]}'"},
+			run:      cmdInit,
+		},
+		{
+			name:     "create",
+			aliases:  []string{"new", "add"},
+			synopsis: "dirb create json [-s durability] [-d path]",
+			short:    "Creates an instance.",
+			long:     "Creates an instance out of the given json object (or the standard input, if it's \"-\"), under a generated name; prints the name.",
+			flags:    []*flagSpec{flagSync, flagDir},
+			examples: []string{`dirb create '{"title": "The Hobbit", "pages": 310}'`, "dirb create - < book.json"},
+			run:      cmdNew,
+		},
+		{
+			name:     "read",
+			aliases:  []string{"get"},
+			synopsis: "dirb read name [-e [bool]] [-w [duration]] [-d path] [-p [bool]]",
+			short:    "Prints an instance.",
+			long:     "Prints the named instance, under a shared lock.",
+			flags: []*flagSpec{
+				{[]string{"e", "etag"}, "[bool]", "Prints the version token (etag) of the instance first, in a separate line.", parseBoolFlag},
+				flagWait, flagDir, flagPretty,
+			},
+			examples: []string{"dirb read x -p", "dirb read x -e"},
+			run:      cmdGet,
+		},
+		{
+			name:     "update",
+			aliases:  []string{"up", "patch", "pch"},
+			synopsis: "dirb update name json [--merge mode | --json-patch [bool]] [-m etag] [-k n] [-w [duration]] [-s durability] [-d path]",
+			short:    "Updates an instance.",
+			long: "Applies the given json object (or the standard input, if it's \"-\") on the named instance as a merge patch (RFC 7386); recursively, a null removes its key, and any other non-object value replaces the one of its key. " +
+				"With --merge deep, merges the objects recursively instead, keeping the nulls (i.e. never removes a key); as the older versions did. " +
+				"With --json-patch, the json is a json patch (RFC 6902) instead; an array of add, remove, replace, move, copy, and test operations on json pointers, which are applied in order, all or none of them.",
+			flags: []*flagSpec{
+				{[]string{"merge"}, "mode", "The merge mode; \"patch\" (the default), or \"deep\".", parseMergeFlag},
+				{[]string{"json-patch"}, "[bool]", "Applies the json as a json patch, instead of merging it.", parseBoolFlag},
+				flagIfMatch, flagKeep, flagWait, flagSync, flagDir,
+			},
+			examples: []string{`dirb update x '{"pages": 320}'`, `dirb update x '{"draft": null}'`, `dirb update x '{"draft": null}' --merge deep`, `dirb patch x '[{"op": "test", "path": "/pages", "value": 300}, {"op": "add", "path": "/authors/-", "value": "y"}]' --json-patch`, `dirb update x '{"pages": 320}' -m 0123456789abcdef0123456789abcdef`},
+			run:      cmdUp,
+		},
+		{
+			name:     "overwrite",
+			aliases:  []string{"ow", "replace", "over"},
+			synopsis: "dirb overwrite name json [-m etag] [-k n] [-w [duration]] [-s durability] [-d path]",
+			short:    "Overwrites an instance.",
+			long:     "Replaces the named instance with the given json object (or the standard input, if it's \"-\").",
+			flags:    []*flagSpec{flagIfMatch, flagKeep, flagWait, flagSync, flagDir},
+			examples: []string{`dirb overwrite x '{"title": "The Hobbit"}'`},
+			run:      cmdOver,
+		},
+		{
+			name:     "remove",
+			aliases:  []string{"rm", "delete"},
+			synopsis: "dirb rm name [-m etag] [-k n] [-t [bool]] [-a duration] [-w [duration]] [-s durability] [-d path]",
+			short:    "Removes an instance.",
+			long:     "Removes the named instance; for good, or into the trash.",
+			flags: []*flagSpec{
+				flagIfMatch, flagKeep, flagTrash,
+				{[]string{"a", "max-age"}, "duration", "Along with -t, purges the trashed instances older than the given duration.", parseDurationFlag},
+				flagWait, flagSync, flagDir,
+			},
+			examples: []string{"dirb rm x", "dirb rm x -t -a 720h"},
+			run:      cmdRm,
+		},
+		{
+			name:     "help",
+			synopsis: "dirb help [command]",
+			short:    "Prints the help of a command, or the list of the commands.",
+			run:      cmdHelp,
+		},
+		{
+			name:     "grep",
+			synopsis: "dirb grep regex [-f field] [-k [bool]] [-v [bool]] [-o [bool]] [-w [duration]] [-d path]",
+			short:    "Searches the instances by a regular expression.",
+			long:     "Searches the keys and the (primitive) values of the instances by the regular expression; prints the names of the matching instances, or the matches themselves.",
+			flags: []*flagSpec{
+				{[]string{"f", "field"}, "field", "Searches only within the given field (e.g. a.b, authors[0], or /authors/0; as in find).", parseFieldFlag},
+				{[]string{"k", "keys"}, "[bool]", "Searches the keys (only, unless -v is given too).", parseBoolFlag},
+				{[]string{"v", "values"}, "[bool]", "Searches the values (only, unless -k is given too).", parseBoolFlag},
+				{[]string{"o", "matches"}, "[bool]", "Prints a json object per match (e.g. {\"name\": \"x\", \"path\": \"/tags/0\", \"value\": \"draft\"}), instead of the names.", parseBoolFlag},
+				flagWait, flagDir,
+			},
+			examples: []string{"dirb grep tolkien", "dirb grep '^draft$' -f tags -o"},
+			run:      cmdGrep,
+		},
+		{
+			name:     "ls",
+			aliases:  []string{"list"},
+			synopsis: "dirb ls [--sort field[:desc],...] [--limit n] [--offset n] [-w [duration]] [-d path]",
+			short:    "Lists the names of the instances.",
+			long:     "Lists the names of the instances; by the names, or (with --sort) in the order of the given fields, which is looked up in an index if there's one on the (single) field. With --limit and --offset, lists only a page of them.",
+			flags:    []*flagSpec{flagSort, flagLimit, flagOffset, flagWait, flagDir},
+			examples: []string{"dirb ls --sort pages:desc --limit 10", "dirb ls --sort lang,title --limit 10 --offset 20"},
+			run:      cmdLs,
+		},
+		{
+			name:     "find",
+			synopsis: "dirb find query... [--docs [bool]] [--fields field,...] [--format format] [-p [bool]] [--sort field[:desc],...] [--limit n] [--offset n] [-w [duration]] [-d path]",
+			short:    "Finds the instances matching a query.",
+			long: "Prints the names of the instances matching the query; a boolean expression of comparisons (<, <=, >, >=, ==, !=, in, and !in) between fields (e.g. a.b, authors[*] for each of the authors, authors[0] or authors.0 for the first one, the json pointer /authors/0, or root for the whole instance) and json literals, combined by and, or, not, and parentheses.\n" +
+				"The equality (==, !=, and in) is of the values; numbers by value (e.g. 1 == 1.0), and arrays element by element, in order. " +
+				"Multiple arguments are joined by spaces. The comparisons on the indexed fields are looked up in the indexes; see the index command. " +
+				"The names are printed by the names, or (with --sort) in the order of the given fields; see ls. " +
+				"With --docs, prints a json object per instance instead, of its name and document (e.g. {\"name\": \"x\", \"json\": {...}}); or with --fields, of its name and a projection of the document on the given fields, by how they're written (a field with a \"[*]\" as an array of its values, and a missing one left out).",
+			flags: []*flagSpec{
+				{[]string{"docs"}, "[bool]", "Prints the documents of the instances, instead of the names.", parseBoolFlag},
+				{[]string{"fields"}, "field,...", "Prints the projections of the documents on the given fields (e.g. title,authors[0]), instead of the names.", parseFieldsFlag},
+				{[]string{"format"}, "format", "The format of the documents; \"ndjson\" (a json object per line; the default), \"array\" (a json array), or \"pretty\" (tab-indented).", parseFormatFlag},
+				{[]string{"p", "pretty"}, "[bool]", "Same as --format pretty; has no effect on the names.", parseBoolFlag},
+				flagSort, flagLimit, flagOffset, flagWait, flagDir,
+			},
+			examples: []string{`dirb find 'lang == "en" and (pages > 300 or not "draft" in tags)'`, `dirb find 'lang == "en"' --sort pages:desc --limit 10`, `dirb find 'lang == "en"' --docs --format array`, `dirb find 'pages > 300' --fields 'title,authors[0]' -p`},
+			run:      cmdFind,
+		},
+		{
+			name:     "join",
+			synopsis: "dirb join l r [-j path] [-l [bool]] [-n [bool]] [-w [duration]] [-d path]",
+			short:    "Joins the instances of two directories.",
+			long:     "Joins the instances of the directory with the ones of another (or the same) directory, whose field l equals field r; prints the joined pairs as merged json objects (the right one's fields win on a clash), or as pairs of names.",
+			flags: []*flagSpec{
+				{[]string{"j", "join-directory"}, "path", "The directory of the right-hand instances; defaults to the -d directory.", nil},
+				{[]string{"l", "left"}, "[bool]", "Left join; keeps the left-hand instances without a match too.", parseBoolFlag},
+				{[]string{"n", "names"}, "[bool]", "Prints the names of the joined pairs, separated by a tab, instead of the merged instances.", parseBoolFlag},
+				flagWait, flagDir,
+			},
+			examples: []string{"dirb join author id -d books -j authors"},
+			run:      cmdJoin,
+		},
+		{
+			name:     "usage",
+			aliases:  []string{"usg"},
+			synopsis: "dirb usage command",
+			short:    "Prints the usage of a command.",
+			run:      cmdUsg,
+		},
+		{
+			name:     "unlock",
+			aliases:  []string{"unlck"},
+			synopsis: "dirb unlock name [-a duration] [-d path]",
+			short:    "Breaks the stale lock of an instance.",
+			long:     "Breaks the lock of the named instance if its owner is dead, or (with -a) it's older than the given duration.",
+			flags:    []*flagSpec{{[]string{"a", "max-age"}, "duration", "Breaks the lock if it's older than the given duration too.", parseDurationFlag}, flagDir},
+			examples: []string{"dirb unlock x -a 10m"},
+			run:      cmdUnlck,
+		},
+		{
+			name:     "transaction",
+			aliases:  []string{"tx"},
+			synopsis: "dirb tx [-k n] [-t [bool]] [-w [duration]] [-s durability] [-d path]",
+			short:    "Applies a batch of writes, all or nothing.",
+			long:     "Reads the operations from the standard input, a json object per operation (e.g. {\"op\": \"update\", \"name\": \"x\", \"json\": {...}}, where op is create, update, overwrite, json-patch, or rm; an update may also have a \"merge\" mode, as the --merge flag of update, and a json-patch has a \"patch\" array instead of the json), and applies them all or none of them; prints the generated names of the created instances.",
+			flags:    []*flagSpec{flagKeep, flagTrash, flagWait, flagSync, flagDir},
+			examples: []string{`echo '{"op": "rm", "name": "x"}' | dirb tx`},
+			run:      cmdTx,
+		},
+		{
+			name:     "fsck",
+			aliases:  []string{"check"},
+			synopsis: "dirb fsck [--repair [bool]] [-a duration] [-d path]",
+			short:    "Checks the consistency of the directory.",
+			long:     "Reports (as a json object per line) what the crashed processes left behind, and the broken instances; exits with 1 if any problem remains.",
+			flags: []*flagSpec{
+				{[]string{"repair"}, "[bool]", "Cleans up what the crashed processes left behind, and quarantines the broken instances.", parseBoolFlag},
+				{[]string{"a", "max-age"}, "duration", "Considers the locks older than the given duration stale too.", parseDurationFlag},
+				flagDir,
+			},
+			examples: []string{"dirb fsck --repair"},
+			run:      cmdFsck,
+		},
+		{
+			name:     "history",
+			aliases:  []string{"hist"},
+			short:    "Lists, shows, compares, or restores the revisions of an instance.",
+			long:     "The revisions are kept by the writes given -k; diff prints the changes (as a json object per line) from a revision to another (or to the current instance), and restore atomically brings a revision back.",
+			examples: []string{"dirb update x '{\"pages\": 320}' -k 10", "dirb history diff x 1", "dirb history restore x 1"},
+			subs: []*cmdSpec{
+				{name: "ls", aliases: []string{"list"}, synopsis: "dirb history ls name [-d path]", flags: []*flagSpec{flagDir}, run: cmdHistLs},
+				{name: "show", aliases: []string{"get", "read"}, synopsis: "dirb history show name rev [-d path] [-p [bool]]", flags: []*flagSpec{flagDir, flagPretty}, run: cmdHistShow},
+				{name: "diff", synopsis: "dirb history diff name rev [rev] [-d path]", flags: []*flagSpec{flagDir}, run: cmdHistDiff},
+				{name: "restore", aliases: []string{"rollback"}, synopsis: "dirb history restore name rev [-k n] [-w [duration]] [-s durability] [-d path]", flags: []*flagSpec{flagKeep, flagWait, flagSync, flagDir}, run: cmdHistRestore},
+			},
+		},
+		{
+			name:     "trash",
+			short:    "Lists, restores, or purges the trashed instances.",
+			long:     "The instances are trashed by rm -t; purge without an id purges the ones older than -a (all of them, by default).",
+			examples: []string{"dirb trash ls", "dirb trash purge -a 720h"},
+			subs: []*cmdSpec{
+				{name: "ls", aliases: []string{"list"}, synopsis: "dirb trash ls [-d path]", flags: []*flagSpec{flagDir}, run: cmdTrashLs},
+				{name: "restore", aliases: []string{"untrash"}, synopsis: "dirb trash restore id [-w [duration]] [-s durability] [-d path]", flags: []*flagSpec{flagWait, flagSync, flagDir}, run: cmdTrashRestore},
+				{
+					name:     "purge",
+					synopsis: "dirb trash purge [id] [-a duration] [-d path]",
+					flags: []*flagSpec{
+						{[]string{"a", "max-age"}, "duration", "Purges only the trashed instances older than the given duration.", parseDurationFlag},
+						flagDir,
+					},
+					run: cmdTrashPurge,
+				},
+			},
+		},
+		{
+			name:    "index",
+			aliases: []string{"idx"},
+			short:   "Creates, lists, removes, or rebuilds the indexes of the instances.",
+			long: "An index maps the values of a field (e.g. `authors[*]`, for each of the authors) to the instances holding them; it's kept up to date by the writes, and used by find for the ==, <, <=, >, and >= comparisons of the field with a literal. " +
+				"Rebuild (without a name, all the indexes) for when an index is suspected to be out of date.",
+			examples: []string{"dirb index create author 'authors[*]'", "dirb index create words root --text", "dirb find 'authors[*] == \"x\"'", "dirb index rebuild"},
+			subs: []*cmdSpec{
+				{
+					name:     "create",
+					aliases:  []string{"new", "add"},
+					synopsis: "dirb index create name field [--text [bool]] [-w [duration]] [-s durability] [-d path]",
+					flags: []*flagSpec{
+						{[]string{"text"}, "[bool]", "Creates a text index of the words of the strings in the field, for the search command.", parseBoolFlag},
+						flagWait, flagSync, flagDir,
+					},
+					run: cmdIndexNew,
+				},
+				{name: "ls", aliases: []string{"list"}, synopsis: "dirb index ls [-d path]", flags: []*flagSpec{flagDir}, run: cmdIndexLs},
+				{name: "rm", aliases: []string{"remove", "delete", "del"}, synopsis: "dirb index rm name [-w [duration]] [-s durability] [-d path]", flags: []*flagSpec{flagWait, flagSync, flagDir}, run: cmdIndexRm},
+				{name: "rebuild", synopsis: "dirb index rebuild [name] [-w [duration]] [-s durability] [-d path]", flags: []*flagSpec{flagWait, flagSync, flagDir}, run: cmdIndexRebuild},
+			},
+		},
+		{
+			name:     "validate",
+			synopsis: "dirb validate [-w [duration]] [-d path]",
+			short:    "Checks the instances against the schema.",
+			long:     "Prints a json object per violation of the schema of the directory by an instance (e.g. {\"name\": \"x\", \"ptr\": \"/pages\", \"schemaPtr\": \"/properties/pages/type\", \"msg\": \"expected integer, not string\"}); see the init command's --schema flag. Exits with 1 if there's any.",
+			flags:    []*flagSpec{flagWait, flagDir},
+			examples: []string{"dirb validate -d books"},
+			run:      cmdValidate,
+		},
+		{
+			name:  "unique",
+			short: "Adds, lists, or removes the unique constraints of the instances.",
+			long: "A unique constraint forbids two instances from holding an equal value in a field (e.g. `isbn`, or `ids[*]` for each of the ids); create, update, overwrite, and tx fail on a write which violates it, and add fails if the instances already do. " +
+				"The constraints are declared in the manifest of the directory (the hidden `.dirb/manifest.json`), and each is enforced through an index of its name; see the index command.",
+			examples: []string{"dirb unique add isbn isbn", "dirb unique ls", "dirb unique rm isbn"},
+			subs: []*cmdSpec{
+				{name: "add", aliases: []string{"create", "new"}, synopsis: "dirb unique add name field [-w [duration]] [-s durability] [-d path]", flags: []*flagSpec{flagWait, flagSync, flagDir}, run: cmdUniqueAdd},
+				{name: "ls", aliases: []string{"list"}, synopsis: "dirb unique ls [-d path]", flags: []*flagSpec{flagDir}, run: cmdUniqueLs},
+				{name: "rm", aliases: []string{"remove", "delete", "del"}, synopsis: "dirb unique rm name [-w [duration]] [-s durability] [-d path]", flags: []*flagSpec{flagWait, flagSync, flagDir}, run: cmdUniqueRm},
+			},
+		},
+		{
+			name:     "search",
+			synopsis: "dirb search words... [-i index] [--scores [bool]] [-w [duration]] [-d path]",
+			short:    "Searches the instances by words, in the text indexes.",
+			long: "Prints the names of the instances holding any of the words (case-insensitively) in the strings of the text indexes, ranked by relevance (Okapi BM25), best first; see the index command's --text flag.\n" +
+				"Multiple arguments are joined by spaces. Note that search used to be an alias of grep; use grep for the regular expressions.",
+			flags: []*flagSpec{
+				{[]string{"i", "index"}, "index", "Searches the given text index only.", parseNameFlag},
+				{[]string{"scores"}, "[bool]", "Prints a json object per instance, along with its score (e.g. {\"name\": \"x\", \"score\": 1.5}), instead of the names.", parseBoolFlag},
+				flagWait, flagDir,
+			},
+			examples: []string{"dirb index create words root --text", "dirb search hobbit tolkien"},
+			run:      cmdSearch,
+		},
+	}
+
+	for _, c := range cmdSpecs {
+		for _, s := range c.subs {
+			s.parent = c
+		}
+	}
+}
+
+func lookupCmd(name string) *cmdSpec {
+	return lookupSpec(cmdSpecs, name)
+}
+
+// sub returns the sub-command of c by name (or alias); nil if there's none.
+func (c *cmdSpec) sub(name string) *cmdSpec {
+	return lookupSpec(c.subs, name)
+}
+
+func lookupSpec(cs []*cmdSpec, name string) *cmdSpec {
+	for _, c := range cs {
+		if c.name == name {
+			return c
+		}
+		for _, a := range c.aliases {
+			if a == name {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+// popSub pops the sub-command of c off the remaining arguments; fatal if it's missing, or unknown.
+func (c *cmdSpec) popSub() *cmdSpec {
+	ns := make([]string, 0, len(c.subs))
+	for _, s := range c.subs {
+		ns = append(ns, strconv.Quote(s.name))
+	}
+	should := strings.Join(ns[:len(ns)-1], ", ") + ", or " + ns[len(ns)-1]
+
+	if len(remArgs) == 0 {
+		fatalf("no %s command; should be %s", c.name, should)
+	}
+
+	name := remArgs[0]
+	remArgs = remArgs[1:]
+	s := c.sub(name)
+	if s == nil {
+		fatalf("unknown %s command %q; should be %s", c.name, name, should)
+	}
+
+	return s
+}
+
+// fullName returns the name of the command, after the one of its parent if it's a sub-command; e.g. "history ls".
+func (c *cmdSpec) fullName() string {
+	if c.parent != nil {
+		return c.parent.name + " " + c.name
+	}
+
+	return c.name
+}
+
+// usage returns the synopsis of the command; a line per sub-command, if it has them.
+func (c *cmdSpec) usage() string {
+	if len(c.subs) == 0 {
+		return c.synopsis
+	}
+
+	ss := make([]string, 0, len(c.subs))
+	for _, s := range c.subs {
+		ss = append(ss, s.synopsis)
+	}
+
+	return strings.Join(ss, "\n")
+}
+
+// allFlags returns the flags of the command, along with the ones of its sub-commands; once per first name.
+func (c *cmdSpec) allFlags() []*flagSpec {
+	fs := make([]*flagSpec, 0, len(c.flags))
+	seen := make(map[string]bool)
+	add := func(f *flagSpec) {
+		if !seen[f.names[0]] {
+			seen[f.names[0]] = true
+			fs = append(fs, f)
+		}
+	}
+
+	for _, f := range c.flags {
+		add(f)
+	}
+	for _, s := range c.subs {
+		for _, f := range s.flags {
+			add(f)
+		}
+	}
+
+	return fs
+}
+
+// noNxtArgVal returns the flag names of command c whose value is optional, so it can't be the next argument; see sflag.ParseNoNxtArgVal.
+// If c is nil (i.e. the command is not known yet), only the names whose value is optional in every command having them; e.g. not "k", which is optional in grep (keys), but not in update (keep).
+func noNxtArgVal(c *cmdSpec) []string {
+	if c != nil {
+		ns := make([]string, 0)
+		for _, f := range c.allFlags() {
+			if f.optVal() {
+				ns = append(ns, f.names...)
+			}
+		}
+
+		return ns
+	}
+
+	opt := make(map[string]bool)
+	for _, c := range cmdSpecs {
+		for _, f := range c.allFlags() {
+			for _, n := range f.names {
+				if o, ok := opt[n]; !ok || o {
+					opt[n] = f.optVal()
+				}
+			}
+		}
+	}
+
+	ns := make([]string, 0)
+	for n, o := range opt {
+		if o {
+			ns = append(ns, n)
+		}
+	}
+
+	return ns
+}
+
+// flagVals are the values of the given flags (see flagSpec.parse), by their first names; e.g. "d", for both -d and --directory.
+type flagVals map[string]interface{}
+
+// cmdFlags are the flags given to the command; see cmdSpec.parseFlags.
+var cmdFlags flagVals
+
+// parseFlags parses the given flags by the specs of the command; reports the unexpected, the repeated, and the invalid ones.
+func (c *cmdSpec) parseFlags() (flagVals, bool) {
+	fail := false
+	fv := make(flagVals)
+	for _, f := range flags {
+		fs := c.flag(f.Name)
+		if fs == nil {
+			fail = true
+			cands := make([]string, 0)
+			for _, fs := range c.flags {
+				cands = append(cands, fs.names...)
+			}
+			if s := suggest(f.Name, cands); s != "" {
+				errorf("unexpected flag %q for command %q; did you mean %q?", f.Name, c.fullName(), s)
+			} else {
+				errorf("unexpected flag %q for command %q", f.Name, c.fullName())
+			}
+			continue
+		}
+
+		n := fs.longName()
+		if _, ok := fv[fs.names[0]]; ok {
+			// Already found
+			fail = true
+			errorf("multiple %q flags", n)
+			continue
+		}
+
+		var v interface{} = f.Val
+		if !f.HasVal && !fs.optVal() {
+			fail = true
+			if strings.ContainsRune("aeiou", rune(n[0])) {
+				errorf("no value assigned to an %q flag", n)
+			} else {
+				errorf("no value assigned to a %q flag", n)
+			}
+		} else if fs.parse != nil {
+			var err error
+			v, err = fs.parse(f.Val, f.HasVal)
+			if err != nil {
+				fail = true
+				errorr(err)
+			}
+		}
+		fv[fs.names[0]] = v
+	}
+
+	return fv, !fail
+}
+
+func (fv flagVals) has(name string) bool {
+	_, ok := fv[name]
+	return ok
+}
+
+// str returns the value of a flag which is taken as is (see flagSpec.parse), or def if it's not given.
+func (fv flagVals) str(name, def string) string {
+	if s, ok := fv[name].(string); ok {
+		return s
+	}
+
+	return def
+}
+
+func (fv flagVals) boolean(name string) bool {
+	b, _ := fv[name].(bool)
+	return b
+}
+
+func (fv flagVals) integer(name string, def int) int {
+	if i, ok := fv[name].(int); ok {
+		return i
+	}
+
+	return def
+}
+
+func (fv flagVals) duration(name string) time.Duration {
+	d, _ := fv[name].(time.Duration)
+	return d
+}
+
+// dir returns the -d flag, or the working directory.
+func (fv flagVals) dir() string {
+	return fv.str("d", ".")
+}
+
+// wait returns the -w flag; nil if it's not given.
+func (fv flagVals) wait() *bin.WaitOpts {
+	w, _ := fv["w"].(*bin.WaitOpts)
+	return w
+}
+
+// durability returns the -s flag, or the default durability.
+func (fv flagVals) durability() bin.Durability {
+	if d, ok := fv["s"].(bin.Durability); ok {
+		return d
+	}
+
+	return bin.DurabilityFileDir
+}
+
+func (c *cmdSpec) flag(name string) *flagSpec {
+	for _, f := range c.flags {
+		for _, n := range f.names {
+			if n == name {
+				return f
+			}
+		}
+	}
+
+	return nil
+}
+
+// suggest returns the candidate closest to s (by edit distance), if it's close enough; empty otherwise.
+func suggest(s string, cands []string) string {
+	best, bestD := "", -1
+	for _, c := range cands {
+		d := editDist(s, c)
+		if bestD < 0 || d < bestD {
+			best, bestD = c, d
+		}
+	}
+
+	if bestD < 0 || bestD > 2 || bestD >= len(s) {
+		return ""
+	}
+
+	return best
+}
+
+// editDist returns the Levenshtein distance of a and b.
+func editDist(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+
+	return a
+}
+
+func cmdNames() []string {
+	ns := make([]string, 0, len(cmdSpecs))
+	for _, c := range cmdSpecs {
+		ns = append(ns, c.name)
+		ns = append(ns, c.aliases...)
+	}
+
+	return ns
+}
+
+// printHelp prints the full help of the command.
+func (c *cmdSpec) printHelp() {
+	w := os.Stdout
+	fmt.Fprintf(w, "Usage: %s\n", strings.ReplaceAll(c.usage(), "\n", "\n       "))
+	fmt.Fprintf(w, "\n%s\n", c.short)
+	if c.long != "" {
+		fmt.Fprintf(w, "%s\n", c.long)
+	}
+
+	if len(c.aliases) > 0 {
+		fmt.Fprintf(w, "\nAliases: %s\n", strings.Join(c.aliases, ", "))
+	}
+
+	if fs := c.allFlags(); len(fs) > 0 {
+		fmt.Fprintf(w, "\nFlags:\n")
+		width := 0
+		for _, f := range fs {
+			if l := len(f.String()); l > width {
+				width = l
+			}
+		}
+		for _, f := range fs {
+			fmt.Fprintf(w, "  %-*s  %s\n", width, f.String(), f.desc)
+		}
+	}
+
+	if len(c.examples) > 0 {
+		fmt.Fprintf(w, "\nExamples:\n")
+		for _, e := range c.examples {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+}
+
+func printCmds() {
+	w := os.Stdout
+	fmt.Fprintf(w, "Usage: dirb command [arguments] [flags]\n\nCommands:\n")
+	width := 0
+	for _, c := range cmdSpecs {
+		if len(c.name) > width {
+			width = len(c.name)
+		}
+	}
+	for _, c := range cmdSpecs {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, c.name, c.short)
+	}
+	fmt.Fprintf(w, "\nRun \"dirb help command\" for the details of a command.\n")
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"container/heap"
+	stdErrors "errors"
+	"fmt"
+	"github.com/agcom/dirb/bin"
+	"github.com/agcom/dirb/jsn"
+	"sort"
+	"strings"
+)
+
+// sortKey orders the instances by the values of a field; ascending, unless desc.
+// A field with many values (e.g. `authors[*]`) is ordered by the least of them, or (if desc) by the greatest; only its primitive values are ordered (see jsn.Cmp), and the instances having none come last.
+type sortKey struct {
+	ref  fieldRef
+	desc bool
+}
+
+// parseSortKeys parses s as a comma-separated list of fields (see queryExpr), each optionally followed by ":asc" or ":desc", e.g. `lang,pages:desc`; a '\' escapes a ',' or a ':' of a field.
+func parseSortKeys(s string) ([]*sortKey, error) {
+	ks := make([]*sortKey, 0)
+	for _, p := range splitUnescaped(s, ',') {
+		k := &sortKey{}
+		ps := splitUnescaped(p, ':')
+		if len(ps) > 2 {
+			return nil, fmt.Errorf("invalid sort key %q; multiple ':'", p)
+		} else if len(ps) == 2 {
+			switch ps[1] {
+			case "asc":
+			case "desc":
+				k.desc = true
+			default:
+				return nil, fmt.Errorf("invalid sort key %q; should end with \":asc\", or \":desc\"", p)
+			}
+		}
+
+		var err error
+		k.ref, err = parseFieldRef(ps[0])
+		if err != nil {
+			return nil, err
+		}
+
+		ks = append(ks, k)
+	}
+
+	return ks, nil
+}
+
+// splitUnescaped splits s by the occurrences of sep which are not escaped by a '\'; the escapes are kept.
+func splitUnescaped(s string, sep byte) []string {
+	ss := make([]string, 0, 1)
+	var b strings.Builder
+	escaped := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if escaped {
+			escaped = false
+		} else if c == '\\' {
+			escaped = true
+		} else if c == sep {
+			ss = append(ss, b.String())
+			b.Reset()
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return append(ss, b.String())
+}
+
+// val returns the value of k for instance jo; false if it has none.
+func (k *sortKey) val(jo map[string]interface{}) (interface{}, bool) {
+	var r interface{}
+	found := false
+	for _, v := range jsn.Path(k.ref).Values(jo) {
+		if !jsn.Ordered(v) {
+			continue
+		}
+
+		if !found {
+			r, found = v, true
+		} else if c, _ := jsn.Cmp(v, r); (c < 0 && !k.desc) || (c > 0 && k.desc) {
+			r = v
+		}
+	}
+
+	return r, found
+}
+
+// sortedName is an instance, along with its values of the sort keys.
+type sortedName struct {
+	name string
+	vals []interface{}
+	has  []bool
+}
+
+func newSortedName(name string, jo map[string]interface{}, ks []*sortKey) *sortedName {
+	sn := &sortedName{name, make([]interface{}, len(ks)), make([]bool, len(ks))}
+	for i, k := range ks {
+		sn.vals[i], sn.has[i] = k.val(jo)
+	}
+
+	return sn
+}
+
+// lessSorted orders the instances by the sort keys ks, and then by their names.
+func lessSorted(ks []*sortKey, a, b *sortedName) bool {
+	for i, k := range ks {
+		if a.has[i] != b.has[i] {
+			return a.has[i]
+		} else if !a.has[i] {
+			continue
+		}
+
+		if c, _ := jsn.Cmp(a.vals[i], b.vals[i]); c != 0 {
+			return (c < 0) != k.desc
+		}
+	}
+
+	return a.name < b.name
+}
+
+// sortedHeap is a max-heap of instances, by lessSorted; it keeps the least of them (see add).
+type sortedHeap struct {
+	ks  []*sortKey
+	sns []*sortedName
+}
+
+func (h *sortedHeap) Len() int { return len(h.sns) }
+
+func (h *sortedHeap) Less(i, j int) bool { return lessSorted(h.ks, h.sns[j], h.sns[i]) }
+
+func (h *sortedHeap) Swap(i, j int) { h.sns[i], h.sns[j] = h.sns[j], h.sns[i] }
+
+func (h *sortedHeap) Push(x interface{}) { h.sns = append(h.sns, x.(*sortedName)) }
+
+func (h *sortedHeap) Pop() interface{} {
+	sn := h.sns[len(h.sns)-1]
+	h.sns = h.sns[:len(h.sns)-1]
+	return sn
+}
+
+// add adds sn to h, while keeping at most k (or all, if k is negative) of the least instances.
+func (h *sortedHeap) add(sn *sortedName, k int) {
+	if k < 0 || h.Len() < k {
+		heap.Push(h, sn)
+	} else if k > 0 && lessSorted(h.ks, sn, h.sns[0]) {
+		h.sns[0] = sn
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the instances of h in order.
+func (h *sortedHeap) sorted() []*sortedName {
+	sort.Slice(h.sns, func(i, j int) bool {
+		return lessSorted(h.ks, h.sns[i], h.sns[j])
+	})
+
+	return h.sns
+}
+
+// pageBounds returns the bounds of the page of offset, and limit (none, if negative), within n items.
+func pageBounds(n, offset, limit int) (int, int) {
+	i := offset
+	if i > n {
+		i = n
+	}
+
+	j := n
+	if limit >= 0 && i+limit < j {
+		j = i + limit
+	}
+
+	return i, j
+}
+
+// printPage prints the instances ns matching q (see resultPrinter) (all of them, if it's nil), in the order of sortKeys (or by their names); from the offset-th of them, and at most limit of them (all, if it's negative).
+// The instances are read one at a time, each while holding its shared lock only (see dir.getObj); so the writers are blocked by none but the one being read, and only the names (and the sort values) of the page are held in memory.
+// Thus, the page isn't a snapshot of the directory; the instances removed in the meantime are skipped, and (when sorted in memory) the page's ones are re-read and re-matched before being printed.
+// With a single sort key on an indexed field, the instances are walked in the order of the index (see walkIndexOrder), and no more of them are read than the page needs.
+func printPage(d *dir, ns []string, q queryExpr) bool {
+	if !sort.StringsAreSorted(ns) {
+		ns = append([]string(nil), ns...)
+		sort.Strings(ns)
+	}
+
+	rp := newResultPrinter(d)
+	defer rp.close()
+
+	if q == nil && len(sortKeys) == 0 {
+		i, j := pageBounds(len(ns), offset, limit)
+		ok := true
+		for _, n := range ns[i:j] {
+			ok = rp.print(n, nil) && ok
+		}
+
+		return ok
+	}
+
+	var ix *jsn.Index
+	if len(sortKeys) == 1 {
+		ix = sortIndex(d, sortKeys[0])
+	}
+
+	ok := true
+	// match returns the instance n, if it (still) exists, and matches q.
+	match := func(n string) (map[string]interface{}, bool) {
+		if q == nil && ix != nil {
+			return nil, true
+		}
+
+		jo, err := d.getObj(n)
+		if err != nil {
+			var errNotExist *bin.ErrNotExist
+			if !stdErrors.As(err, &errNotExist) {
+				ok = false
+				errorr(err)
+			}
+			return nil, false
+		}
+
+		return jo, q == nil || q.eval(jo)
+	}
+
+	if len(sortKeys) == 0 || ix != nil {
+		// In order; print as they're found.
+		walk := func(f func(n string) bool) {
+			for _, n := range ns {
+				if !f(n) {
+					return
+				}
+			}
+		}
+		if ix != nil {
+			walk = func(f func(n string) bool) {
+				walkIndexOrder(d, ix, sortKeys[0].desc, ns, f)
+			}
+		}
+
+		i := 0
+		walk(func(n string) bool {
+			if limit >= 0 && i >= offset+limit {
+				return false
+			}
+
+			if jo, m := match(n); m {
+				if i >= offset && !rp.print(n, jo) {
+					ok = false
+				}
+				i++
+			}
+
+			return true
+		})
+
+		return ok
+	}
+
+	k := -1
+	if limit >= 0 {
+		k = offset + limit
+	}
+	h := &sortedHeap{ks: sortKeys}
+	for _, n := range ns {
+		if jo, m := match(n); m {
+			h.add(newSortedName(n, jo, sortKeys), k)
+		}
+	}
+
+	sns := h.sorted()
+	i, j := pageBounds(len(sns), offset, limit)
+	for _, sn := range sns[i:j] {
+		var jo map[string]interface{}
+		if printDocs {
+			var m bool
+			jo, m = match(sn.name)
+			if !m {
+				continue
+			}
+		}
+
+		if !rp.print(sn.name, jo) {
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// sortIndex returns an index of d on the field of k, or nil if there's none; see jsn.Index.
+func sortIndex(d *dir, k *sortKey) *jsn.Index {
+	ixs, err := d.indexes()
+	if err != nil {
+		// Just slower
+		multiWarning(err)
+		return nil
+	}
+
+	for _, ix := range ixs {
+		if !ix.Text && ix.Path.Equal(jsn.Path(k.ref)) {
+			return ix
+		}
+	}
+
+	return nil
+}
+
+// walkIndexOrder calls f on the instances ns (sorted by name) in the order of index ix, as of sortKey (i.e. by their least values, or if desc, their greatest ones, and then by their names); followed by the ones the index doesn't hold, by their names.
+// Stops once f returns false.
+func walkIndexOrder(d *dir, ix *jsn.Index, desc bool, ns []string, f func(n string) bool) {
+	inNs := make(map[string]bool, len(ns))
+	for _, n := range ns {
+		inNs[n] = true
+	}
+
+	seen := make(map[string]bool)
+	visit := func(e *jsn.IndexEntry) bool {
+		n, ok := d.instName(e.Name)
+		if !ok || !inNs[n] || seen[n] {
+			return true
+		}
+		seen[n] = true
+
+		return f(n)
+	}
+
+	es := ix.Entries
+	if !desc {
+		for _, e := range es {
+			if !visit(e) {
+				return
+			}
+		}
+	} else {
+		// The groups of equal values backwards, but each by the names.
+		for j := len(es); j > 0; {
+			i := j - 1
+			for i > 0 {
+				if c, _ := jsn.Cmp(es[i-1].Val, es[j-1].Val); c != 0 {
+					break
+				}
+				i--
+			}
+
+			for _, e := range es[i:j] {
+				if !visit(e) {
+					return
+				}
+			}
+			j = i
+		}
+	}
+
+	for _, n := range ns {
+		if !seen[n] && !f(n) {
+			return
+		}
+	}
+}
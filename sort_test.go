@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/agcom/dirb/jsn"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// evalFunc is a query, which calls a function on each instance it's evaluated on.
+type evalFunc func(jo map[string]interface{}) bool
+
+func (f evalFunc) eval(jo map[string]interface{}) bool { return f(jo) }
+
+// capturePage returns the output of printPage, along with its result.
+func capturePage(t *testing.T, ns []string, q queryExpr) (string, bool) {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stdout := os.Stdout
+	os.Stdout = f
+	ok := printPage(dirr, ns, q)
+	os.Stdout = stdout
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b), ok
+}
+
+func TestPrintPageLcks(t *testing.T) {
+	dir := t.TempDir()
+	dirr = newDirOpts(dir, nil)
+	defer func() {
+		dirr, sortKeys, limit, offset, printDocs = nil, nil, -1, 0, false
+	}()
+	ns := []string{"a", "b", "c"}
+	for i, n := range ns {
+		err := dirr.new(n, map[string]interface{}{"i": 3 - i})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sks, err := parseSortKeys("i")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		sks  []*sortKey
+		docs bool
+		want string
+	}{
+		{nil, false, "a\nb\nc\n"},
+		{sks, false, "c\nb\na\n"},
+		{sks, true, "c\nb\na\n"},
+	} {
+		sortKeys, printDocs = c.sks, c.docs
+
+		// No instance is locked while the others are read; so the writers can go on.
+		got, ok := capturePage(t, ns, evalFunc(func(jo map[string]interface{}) bool {
+			for i, n := range ns {
+				err := dirr.over(n, map[string]interface{}{"i": 3 - i})
+				if err != nil {
+					t.Errorf("sort %v: failed to write %q amid the scan; %v", c.sks != nil, n, err)
+				}
+			}
+			return true
+		}))
+		if !ok {
+			t.Errorf("sort %v: failed", c.sks != nil)
+		}
+		if !printDocs && got != c.want {
+			t.Errorf("sort %v: got %q; want %q", c.sks != nil, got, c.want)
+		}
+
+		es, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range es {
+			if n := e.Name(); strings.HasSuffix(n, ".tmp") || strings.HasSuffix(n, ".readers") {
+				t.Errorf("sort %v: %q is left behind", c.sks != nil, n)
+			}
+		}
+	}
+}
+
+func TestPrintPageRemoved(t *testing.T) {
+	dirr = newDirOpts(t.TempDir(), nil)
+	defer func() {
+		dirr, sortKeys, limit, offset, printDocs = nil, nil, -1, 0, false
+	}()
+	ns := []string{"a", "b", "c"}
+
+	sks, err := parseSortKeys("i")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		sks  []*sortKey
+		docs bool
+		// The instance removed while evaluating the first one
+		rm   string
+		want []string
+	}{
+		{nil, false, "c", []string{"a", "b"}},
+		{sks, false, "b", []string{"a", "c"}},
+		// Re-read before being printed
+		{sks, true, "a", []string{"b", "c"}},
+	} {
+		for _, n := range ns {
+			err := dirr.over(n, map[string]interface{}{"i": n})
+			if err != nil {
+				err = dirr.new(n, map[string]interface{}{"i": n})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		sortKeys, printDocs = c.sks, c.docs
+
+		rmd := false
+		got, ok := capturePage(t, ns, evalFunc(func(jo map[string]interface{}) bool {
+			if !rmd {
+				rmd = true
+				err := dirr.rm(c.rm)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			return true
+		}))
+		if !ok {
+			t.Errorf("sort %v, docs %v: failed", c.sks != nil, c.docs)
+		}
+
+		var gotNs []string
+		for _, l := range strings.Split(strings.TrimSuffix(got, "\n"), "\n") {
+			if !c.docs {
+				gotNs = append(gotNs, l)
+				continue
+			}
+
+			jo, err := jsn.StrToJsnObj(l)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotNs = append(gotNs, jo["name"].(string))
+		}
+		if strings.Join(gotNs, ",") != strings.Join(c.want, ",") {
+			t.Errorf("sort %v, docs %v: got %q; want %q", c.sks != nil, c.docs, gotNs, c.want)
+		}
+
+		_, err := os.Stat(dirr.jsnDir().Path(dirr.file(c.rm)))
+		if !os.IsNotExist(err) {
+			t.Fatalf("%q isn't removed; %v", c.rm, err)
+		}
+	}
+}
+
+func TestParseSortKeys(t *testing.T) {
+	for s, want := range map[string][]struct {
+		path jsn.Path
+		desc bool
+	}{
+		"a":               {{jsn.Path{{Key: "a"}}, false}},
+		"a:asc,b.c:desc":  {{jsn.Path{{Key: "a"}}, false}, {jsn.Path{{Key: "b"}, {Key: "c"}}, true}},
+		"a[*]:desc":       {{jsn.Path{{Key: "a"}, {Each: true}}, true}},
+		`a\,b,c\:d:desc`:  {{jsn.Path{{Key: "a,b"}}, false}, {jsn.Path{{Key: "c:d"}}, true}},
+		`/a\:b/c\,d:desc`: {{jsn.Path{{Key: "a:b"}, {Key: "c,d"}}, true}},
+		`a\\:desc`:        {{jsn.Path{{Key: `a\`}}, true}},
+	} {
+		ks, err := parseSortKeys(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if len(ks) != len(want) {
+			t.Errorf("%q: got %d keys; want %d", s, len(ks), len(want))
+			continue
+		}
+		for i, k := range ks {
+			if !jsn.Path(k.ref).Equal(want[i].path) || k.desc != want[i].desc {
+				t.Errorf("%q: got the key %v (desc %v); want %v (desc %v)", s, k.ref, k.desc, want[i].path, want[i].desc)
+			}
+		}
+	}
+
+	for _, s := range []string{"", "a,", "a:", "a:up", "a:desc:asc", "a[", "and"} {
+		ks, err := parseSortKeys(s)
+		if err == nil {
+			t.Errorf("%q: parsed invalid sort keys into %d keys", s, len(ks))
+		}
+	}
+}
+
+func TestPageBounds(t *testing.T) {
+	for _, c := range []struct {
+		n, offset, limit int
+		i, j             int
+	}{
+		{5, 0, -1, 0, 5},
+		{5, 2, -1, 2, 5},
+		{5, 2, 2, 2, 4},
+		{5, 2, 10, 2, 5},
+		{5, 0, 0, 0, 0},
+		{5, 7, 1, 5, 5},
+		{0, 0, -1, 0, 0},
+	} {
+		if i, j := pageBounds(c.n, c.offset, c.limit); i != c.i || j != c.j {
+			t.Errorf("%d items, offset %d, limit %d: got [%d, %d); want [%d, %d)", c.n, c.offset, c.limit, i, j, c.i, c.j)
+		}
+	}
+}
+
+func TestSortedHeap(t *testing.T) {
+	ks, err := parseSortKeys("n:desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []int{-1, 0, 1, 3, 10} {
+		h := &sortedHeap{ks: ks}
+		for i, n := range []string{"a", "b", "c", "d", "e", "f"} {
+			h.add(newSortedName(n, map[string]interface{}{"n": json.Number(strconv.Itoa(i % 3))}, ks), k)
+		}
+
+		var got []string
+		for _, sn := range h.sorted() {
+			got = append(got, sn.name)
+		}
+		want := []string{"c", "f", "b", "e", "a", "d"}
+		if k >= 0 && k < len(want) {
+			want = want[:k]
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("keeping %d: got %q; want %q", k, got, want)
+		}
+	}
+}
+
+// The order is the same, whether an index is walked or not.
+func TestSortOrder(t *testing.T) {
+	dir := newTestInstances(t, map[string]string{
+		"a": `{"n": 2, "t": [3, 1]}`,
+		"b": `{"n": 1, "t": [4]}`,
+		"c": `{"n": 2, "t": []}`,
+		"d": `{"s": "x"}`,
+		"e": `{"n": 1.0, "t": [2, "x"]}`,
+	})
+	defer func() {
+		dirr, sortKeys, limit, offset, printDocs = nil, nil, -1, 0, false
+	}()
+
+	cs := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"ls"}, "a b c d e"},
+		{[]string{"ls", "--sort", "n"}, "b e a c d"},
+		{[]string{"ls", "--sort", "n:desc"}, "a c b e d"},
+		{[]string{"ls", "--sort", "n:desc,t[*]"}, "a c e b d"},
+		{[]string{"ls", "--sort", "t[*]"}, "a e b c d"},
+		// The strings come after the numbers.
+		{[]string{"ls", "--sort", "t[*]:desc"}, "e b a c d"},
+		{[]string{"ls", "--sort", "n", "--limit", "2", "--offset", "1"}, "e a"},
+		{[]string{"ls", "--sort", "n:desc", "--offset", "3"}, "e d"},
+		{[]string{"ls", "--sort", "n", "--offset", "10"}, ""},
+		{[]string{"ls", "--sort", "n", "--limit", "0"}, ""},
+		{[]string{"ls", "--limit", "2", "--offset", "2"}, "c d"},
+		{[]string{"find", "n >= 1", "--sort", "n:desc", "--limit", "3"}, "a c b"},
+		{[]string{"find", "n == 1", "--sort", "n", "--offset", "1"}, "e"},
+	}
+	run := func(indexed bool) {
+		for _, c := range cs {
+			args := append(append([]string(nil), c.args...), "-d", dir)
+			got := strings.Join(strings.Fields(runTestCmd(t, args...)), " ")
+			if got != c.want {
+				t.Errorf("indexed %v, %q: got %q; want %q", indexed, c.args, got, c.want)
+			}
+		}
+	}
+
+	run(false)
+	runTestCmd(t, "index", "create", "n", "n", "-d", dir)
+	runTestCmd(t, "index", "create", "t", "t[*]", "-d", dir)
+	run(true)
+}